@@ -7,10 +7,13 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/lbryio/lbrytv-player/pkg/paid"
+	"github.com/lbryio/lbrytv/app/admin"
 	"github.com/lbryio/lbrytv/app/auth"
 	"github.com/lbryio/lbrytv/app/proxy"
 	"github.com/lbryio/lbrytv/app/publish"
+	"github.com/lbryio/lbrytv/app/query"
 	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/app/ratelimit"
 	"github.com/lbryio/lbrytv/app/sdkrouter"
 	"github.com/lbryio/lbrytv/apps/lbrytv/config"
 	"github.com/lbryio/lbrytv/internal/ip"
@@ -23,9 +26,26 @@ import (
 
 var logger = monitor.NewModuleLogger("api")
 
-// InstallRoutes sets up global API handlers
-func InstallRoutes(r *mux.Router, sdkRouter *sdkrouter.Router) {
+// InstallRoutes sets up global API handlers. callerOpts apply to all queries sent to the SDK by the proxy.
+func InstallRoutes(r *mux.Router, sdkRouter *sdkrouter.Router, callerOpts query.CallerOptions) {
 	upHandler := &publish.Handler{UploadPath: config.GetPublishSourceDir()}
+	qCache, err := cache.New(
+		config.GetQueryCacheBackend(), config.GetQueryCacheRedisURL(), config.GetQueryCacheTTL(), config.GetQueryCacheMaxSize())
+	if err != nil {
+		logger.Log().Fatal(err)
+	}
+	rateLimits, err := config.GetRateLimits()
+	if err != nil {
+		logger.Log().Fatal(err)
+	}
+	limits, err := ratelimit.NewLimits(rateLimits)
+	if err != nil {
+		logger.Log().Fatal(err)
+	}
+	limiter, err := ratelimit.New(config.GetRateLimitBackend(), config.GetRateLimitRedisURL(), limits)
+	if err != nil {
+		logger.Log().Fatal(err)
+	}
 
 	r.Use(methodTimer)
 
@@ -35,7 +55,7 @@ func InstallRoutes(r *mux.Router, sdkRouter *sdkrouter.Router) {
 	r.HandleFunc("", proxy.HandleCORS)
 
 	v1Router := r.PathPrefix("/api/v1").Subrouter()
-	v1Router.Use(defaultMiddlewares(sdkRouter, config.GetInternalAPIHost()))
+	v1Router.Use(defaultMiddlewares(sdkRouter, config.GetInternalAPIHost(), qCache, limiter, callerOpts))
 
 	v1Router.HandleFunc("/proxy", upHandler.Handle).MatcherFunc(upHandler.CanHandle)
 	v1Router.HandleFunc("/proxy", proxy.Handle).Methods(http.MethodPost)
@@ -49,22 +69,24 @@ func InstallRoutes(r *mux.Router, sdkRouter *sdkrouter.Router) {
 
 	internalRouter := r.PathPrefix("/internal").Subrouter()
 	internalRouter.Handle("/metrics", promhttp.Handler())
+	admin.InstallRoutes(internalRouter.PathPrefix("/admin").Subrouter(), sdkRouter, qCache, config.GetAdminToken())
 
 	v2Router := r.PathPrefix("/api/v2").Subrouter()
-	v2Router.Use(defaultMiddlewares(sdkRouter, config.GetInternalAPIHost()))
+	v2Router.Use(defaultMiddlewares(sdkRouter, config.GetInternalAPIHost(), qCache, limiter, callerOpts))
 	v2Router.HandleFunc("/status", status.GetStatusV2).Methods(http.MethodGet)
 	v2Router.HandleFunc("/status", proxy.HandleCORS).Methods(http.MethodOptions)
 }
 
-func defaultMiddlewares(rt *sdkrouter.Router, internalAPIHost string) mux.MiddlewareFunc {
+func defaultMiddlewares(rt *sdkrouter.Router, internalAPIHost string, qCache cache.QueryCache, limiter *ratelimit.Limiter, callerOpts query.CallerOptions) mux.MiddlewareFunc {
 	authProvider := auth.NewIAPIProvider(rt, internalAPIHost)
-	memCache := cache.NewMemoryCache()
 	return middleware.Chain(
 		metrics.MeasureMiddleware(),
 		ip.Middleware,
 		sdkrouter.Middleware(rt),
 		auth.Middleware(authProvider),
-		cache.Middleware(memCache),
+		ratelimit.Middleware(limiter),
+		cache.Middleware(qCache),
+		query.Middleware(callerOpts),
 	)
 }
 
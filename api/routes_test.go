@@ -10,6 +10,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/lbryio/lbrytv/app/publish"
+	"github.com/lbryio/lbrytv/app/query"
 	"github.com/lbryio/lbrytv/app/sdkrouter"
 	"github.com/lbryio/lbrytv/apps/lbrytv/config"
 	"github.com/lbryio/lbrytv/internal/middleware"
@@ -25,7 +26,7 @@ func TestRoutesProxy(t *testing.T) {
 	require.NoError(t, err)
 	rr := httptest.NewRecorder()
 
-	InstallRoutes(r, rt)
+	InstallRoutes(r, rt, query.CallerOptions{})
 	r.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -39,7 +40,7 @@ func TestRoutesPublish(t *testing.T) {
 	req := publish.CreatePublishRequest(t, []byte("test file"))
 	rr := httptest.NewRecorder()
 
-	InstallRoutes(r, rt)
+	InstallRoutes(r, rt, query.CallerOptions{})
 	r.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -51,7 +52,7 @@ func TestRoutesPublish(t *testing.T) {
 func TestRoutesOptions(t *testing.T) {
 	r := mux.NewRouter()
 	rt := sdkrouter.New(config.GetLbrynetServers())
-	InstallRoutes(r, rt)
+	InstallRoutes(r, rt, query.CallerOptions{})
 
 	for _, url := range []string{"/api/v1/proxy", "/api/v2/status"} {
 		t.Run(url, func(t *testing.T) {
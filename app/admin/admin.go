@@ -0,0 +1,146 @@
+// Package admin implements internal API for managing lbrytv infrastructure.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/monitor"
+	"github.com/lbryio/lbrytv/internal/responses"
+
+	"github.com/gorilla/mux"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+// TokenHeader is the header admin token should be passed in.
+const TokenHeader = "X-Admin-Token"
+
+var logger = monitor.NewModuleLogger("admin")
+
+// Handler serves admin API requests.
+// Server list changes are applied by the receiving process immediately,
+// other API processes pick them up on their next reload from the database.
+type Handler struct {
+	rt         *sdkrouter.Router
+	qCache     cache.QueryCache
+	migrations migrations
+}
+
+// InstallRoutes adds admin API handlers to the router, all of them requiring the admin token.
+// If the token is empty, admin API is disabled.
+func InstallRoutes(r *mux.Router, rt *sdkrouter.Router, qCache cache.QueryCache, token string) {
+	h := &Handler{rt: rt, qCache: qCache}
+	r.Use(Middleware(token))
+	r.HandleFunc("/cache/flush", h.FlushCache).Methods(http.MethodPost)
+	r.HandleFunc("/servers", h.ListServers).Methods(http.MethodGet)
+	r.HandleFunc("/servers", h.AddServer).Methods(http.MethodPost)
+	r.HandleFunc("/servers/{name}", h.UpdateServer).Methods(http.MethodPatch)
+	r.HandleFunc("/servers/{name}", h.RemoveServer).Methods(http.MethodDelete)
+	r.HandleFunc("/servers/{name}/migration", h.StartMigration).Methods(http.MethodPost)
+	r.HandleFunc("/servers/{name}/migration", h.MigrationStatus).Methods(http.MethodGet)
+}
+
+// Middleware rejects requests that don't carry the admin token.
+func Middleware(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				writeError(w, http.StatusForbidden, errors.Err("admin api is disabled"))
+				return
+			}
+			provided := r.Header.Get(TokenHeader)
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.Err("invalid admin token"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (h *Handler) ListServers(w http.ResponseWriter, r *http.Request) {
+	servers, err := ListServers(boil.GetDB())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, servers)
+}
+
+func (h *Handler) AddServer(w http.ResponseWriter, r *http.Request) {
+	var params ServerParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Err(err))
+		return
+	}
+	server, err := AddServer(boil.GetDB(), params)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	logger.Log().Infof("server %s added at %s", server.Name, server.Address)
+	h.rt.Invalidate()
+	writeJSON(w, http.StatusCreated, server)
+}
+
+func (h *Handler) UpdateServer(w http.ResponseWriter, r *http.Request) {
+	var params ServerParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Err(err))
+		return
+	}
+	server, err := UpdateServer(boil.GetDB(), mux.Vars(r)["name"], params)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	logger.Log().Infof("server %s updated", server.Name)
+	h.rt.Invalidate()
+	writeJSON(w, http.StatusOK, server)
+}
+
+func (h *Handler) RemoveServer(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := RemoveServer(boil.GetDB(), name); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	logger.Log().Infof("server %s removed", name)
+	h.rt.Invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrServerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrServerExists), errors.Is(err, ErrServerInUse), errors.Is(err, ErrMigrationRunning):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidServer):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		logger.Log().Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	responses.AddJSONContentType(w)
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		logger.Log().Error(err)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
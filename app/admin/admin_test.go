@@ -0,0 +1,279 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/storage"
+	"github.com/lbryio/lbrytv/internal/test"
+	"github.com/lbryio/lbrytv/models"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volatiletech/null"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+const testToken = "admintoken"
+
+func TestMain(m *testing.M) {
+	dbConfig := config.GetDatabase()
+	params := storage.ConnParams{
+		Connection: dbConfig.Connection,
+		DBName:     dbConfig.DBName,
+		Options:    dbConfig.Options,
+	}
+	dbConn, connCleanup := storage.CreateTestConn(params)
+	dbConn.SetDefaultConnection()
+
+	code := m.Run()
+
+	connCleanup()
+	os.Exit(code)
+}
+
+func setValidator(t *testing.T, fn func(string) error) {
+	orig := ValidateServer
+	ValidateServer = fn
+	t.Cleanup(func() { ValidateServer = orig })
+}
+
+func setupRouter(rt *sdkrouter.Router, token string) *mux.Router {
+	r := mux.NewRouter()
+	InstallRoutes(r.PathPrefix("/internal/admin").Subrouter(), rt, cache.NewMemoryCache(), token)
+	return r
+}
+
+func call(r http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set(TokenHeader, token)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMiddleware(t *testing.T) {
+	rt := sdkrouter.NewWithServers(&models.LbrynetServer{Name: "srv", Address: "http://srv"})
+
+	rr := call(setupRouter(rt, ""), http.MethodGet, "/internal/admin/servers", testToken, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = call(setupRouter(rt, testToken), http.MethodGet, "/internal/admin/servers", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = call(setupRouter(rt, testToken), http.MethodGet, "/internal/admin/servers", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid admin token")
+}
+
+func TestServersCRUD(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	srv.QueueResponses(`{"jsonrpc": "2.0", "id": 0, "result": {"is_running": true}}`)
+
+	rt := sdkrouter.New(nil)
+	r := setupRouter(rt, testToken)
+
+	rr := call(r, http.MethodPost, "/internal/admin/servers", testToken,
+		`{"name": "admintest", "address": "`+srv.URL+`", "weight": 5}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	var created models.LbrynetServer
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "admintest", created.Name)
+	assert.Equal(t, 5, created.Weight)
+	defer created.DeleteG()
+
+	found := false
+	for _, s := range rt.GetAll() {
+		if s.Name == "admintest" {
+			found = true
+		}
+	}
+	assert.True(t, found, "router should pick up the new server immediately")
+
+	rr = call(r, http.MethodPost, "/internal/admin/servers", testToken, `{"name": "admintest", "address": "`+srv.URL+`"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = call(r, http.MethodPatch, "/internal/admin/servers/admintest", testToken, `{"draining": true}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	s, err := models.FindLbrynetServerG(created.ID)
+	require.NoError(t, err)
+	assert.True(t, s.Draining)
+	assert.Equal(t, 5, s.Weight)
+
+	rr = call(r, http.MethodGet, "/internal/admin/servers", testToken, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"admintest"`)
+
+	rr = call(r, http.MethodDelete, "/internal/admin/servers/admintest", testToken, "")
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	_, err = models.FindLbrynetServerG(created.ID)
+	assert.Error(t, err)
+
+	rr = call(r, http.MethodDelete, "/internal/admin/servers/admintest", testToken, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAddServerValidation(t *testing.T) {
+	setValidator(t, func(string) error { return errors.Err("connection refused") })
+
+	addr := "http://nowhere:5279/"
+	_, err := AddServer(boil.GetDB(), ServerParams{Name: "invalid", Address: &addr})
+	assert.True(t, errors.Is(err, ErrInvalidServer))
+	assert.Contains(t, err.Error(), "connection refused")
+
+	_, err = AddServer(boil.GetDB(), ServerParams{Name: "invalid"})
+	assert.True(t, errors.Is(err, ErrInvalidServer))
+
+	exists, err := models.LbrynetServers(models.LbrynetServerWhere.Name.EQ("invalid")).ExistsG()
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	rr := call(setupRouter(sdkrouter.New(nil), testToken), http.MethodPost, "/internal/admin/servers", testToken,
+		`{"name": "invalid", "address": "`+addr+`"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestUpdateServerValidation(t *testing.T) {
+	setValidator(t, func(string) error { return nil })
+
+	addr := "http://lbrynet:5279/"
+	s, err := AddServer(boil.GetDB(), ServerParams{Name: "updatetest", Address: &addr})
+	require.NoError(t, err)
+	defer s.DeleteG()
+
+	weight := -1
+	_, err = UpdateServer(boil.GetDB(), "updatetest", ServerParams{Weight: &weight})
+	assert.True(t, errors.Is(err, ErrInvalidServer))
+
+	setValidator(t, func(string) error { return errors.Err("connection refused") })
+	_, err = UpdateServer(boil.GetDB(), "updatetest", ServerParams{Address: &addr})
+	assert.NoError(t, err, "unchanged address should not be validated")
+
+	newAddr := "http://lbrynet2:5279/"
+	_, err = UpdateServer(boil.GetDB(), "updatetest", ServerParams{Address: &newAddr})
+	assert.True(t, errors.Is(err, ErrInvalidServer))
+}
+
+func TestRemoveServerInUse(t *testing.T) {
+	setValidator(t, func(string) error { return nil })
+
+	addr := "http://lbrynet:5279/"
+	s, err := AddServer(boil.GetDB(), ServerParams{Name: "inuse", Address: &addr})
+	require.NoError(t, err)
+	defer s.DeleteG()
+
+	u := &models.User{ID: 99123, LbrynetServerID: null.IntFrom(s.ID)}
+	require.NoError(t, u.InsertG(boil.Infer()))
+	defer u.DeleteG()
+
+	err = RemoveServer(boil.GetDB(), "inuse")
+	assert.True(t, errors.Is(err, ErrServerInUse))
+}
+
+func TestValidateServer(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+
+	srv.QueueResponses(`{"jsonrpc": "2.0", "id": 0, "result": {"is_running": true}}`)
+	assert.NoError(t, ValidateServer(srv.URL))
+
+	srv.QueueResponses(`{"jsonrpc": "2.0", "id": 0, "result": {"is_running": false}}`)
+	assert.EqualError(t, ValidateServer(srv.URL), "lbrynet is not running")
+
+	srv.Close()
+	assert.Error(t, ValidateServer(srv.URL))
+}
+
+func TestFlushCache(t *testing.T) {
+	rt := sdkrouter.NewWithServers(&models.LbrynetServer{Name: "srv", Address: "http://srv"})
+	qCache := cache.NewMemoryCache()
+	r := mux.NewRouter()
+	InstallRoutes(r.PathPrefix("/internal/admin").Subrouter(), rt, qCache, testToken)
+	flush := func(body string) *httptest.ResponseRecorder {
+		return call(r, http.MethodPost, "/internal/admin/cache/flush", testToken, body)
+	}
+	fill := func() {
+		qCache.Save("resolve", map[string]interface{}{"urls": "one"}, "resolved", 0, "claim:one")
+		qCache.Save("claim_search", map[string]interface{}{"page": 1.0}, "found", 0, "claim:two")
+		qCache.Save("claim_list", nil, "listed", 0)
+	}
+
+	fill()
+	rr := flush(`{"method": "claim_search", "params": {"page": 1}}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `{"invalidated": 1}`, rr.Body.String())
+
+	rr = flush(`{"pattern": "claim_*"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `{"invalidated": 1}`, rr.Body.String())
+
+	rr = flush(`{"tag": "claim:one"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `{"invalidated": 1}`, rr.Body.String())
+	assert.Equal(t, 0, qCache.Count())
+
+	fill()
+	rr = flush(`{"pattern": "*", "tag": "claim:one"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = flush(`{}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = flush(`not json`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, 3, qCache.Count())
+}
+
+func TestMigration(t *testing.T) {
+	setValidator(t, func(string) error { return nil })
+	src := test.MockHTTPServer(nil)
+	defer src.Close()
+	dst := test.MockHTTPServer(nil)
+	defer dst.Close()
+
+	from, err := AddServer(boil.GetDB(), ServerParams{Name: "migratefrom", Address: &src.URL})
+	require.NoError(t, err)
+	defer from.DeleteG()
+	to, err := AddServer(boil.GetDB(), ServerParams{Name: "migrateto", Address: &dst.URL})
+	require.NoError(t, err)
+	defer to.DeleteG()
+
+	u := &models.User{ID: 99124, LbrynetServerID: null.IntFrom(from.ID)}
+	require.NoError(t, u.InsertG(boil.Infer()))
+	defer u.DeleteG()
+
+	r := setupRouter(sdkrouter.NewWithServers(from, to), testToken)
+
+	rr := call(r, http.MethodPost, "/internal/admin/servers/migratefrom/migration", testToken, `{"to": "migratefrom"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = call(r, http.MethodPost, "/internal/admin/servers/migratefrom/migration", testToken, `{"to": "nowhere"}`)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	src.QueueResponses(`{"result": {"id": "lbrytv-id.99124.wallet"}}`)
+	dst.QueueResponses(`{"result": {"id": "lbrytv-id.99124.wallet"}}`)
+	rr = call(r, http.MethodPost, "/internal/admin/servers/migratefrom/migration", testToken, `{"to": "migrateto"}`)
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+
+	var status MigrationStatus
+	assert.Eventually(t, func() bool {
+		rr = call(r, http.MethodGet, "/internal/admin/servers/migratefrom/migration", testToken, "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+		return !status.Running
+	}, 5*time.Second, 50*time.Millisecond)
+	require.NotNil(t, status.Progress)
+	assert.Equal(t, 1, status.Progress.Migrated)
+	assert.Equal(t, map[string]int{"done": 1}, status.States)
+}
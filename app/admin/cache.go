@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lbryio/lbrytv/internal/errors"
+)
+
+// FlushCacheParams select query cache entries to remove. Exactly one way of selecting them should be used:
+// Method with Params for a single query, Pattern for all queries of methods matching it (e.g. "claim_*")
+// or Tag for queries related to a claim (e.g. "claim:<claim_id>").
+type FlushCacheParams struct {
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	Pattern string      `json:"pattern"`
+	Tag     string      `json:"tag"`
+}
+
+// FlushCache removes entries from the query cache of this API instance.
+func (h *Handler) FlushCache(w http.ResponseWriter, r *http.Request) {
+	var params FlushCacheParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Err(err))
+		return
+	}
+
+	var n int
+	switch {
+	case params.Method != "" && params.Pattern == "" && params.Tag == "":
+		n = h.qCache.Invalidate(params.Method, params.Params)
+	case params.Pattern != "" && params.Method == "" && params.Tag == "":
+		n = h.qCache.InvalidateMethod(params.Pattern)
+	case params.Tag != "" && params.Method == "" && params.Pattern == "":
+		n = h.qCache.InvalidateTag(params.Tag)
+	default:
+		writeError(w, http.StatusBadRequest, errors.Err("exactly one of method, pattern or tag should be set"))
+		return
+	}
+
+	logger.Log().Infof("flushed %d cached queries (%+v)", n, params)
+	writeJSON(w, http.StatusOK, map[string]int{"invalidated": n})
+}
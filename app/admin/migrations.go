@@ -0,0 +1,138 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/wallet/migration"
+	"github.com/lbryio/lbrytv/internal/errors"
+
+	"github.com/gorilla/mux"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+var ErrMigrationRunning = errors.Base("users are already being migrated off this server")
+
+// MigrationParams define a migration of users started via admin API, see migration.Options.
+type MigrationParams struct {
+	To              string `json:"to"`
+	DryRun          bool   `json:"dry_run"`
+	Limit           int    `json:"limit"`
+	SourceWalletDir string `json:"source_wallet_dir"`
+	TargetWalletDir string `json:"target_wallet_dir"`
+}
+
+// MigrationStatus describes the last migration run off a server started by this API instance,
+// along with per-user migration states stored in the database.
+type MigrationStatus struct {
+	Params   *MigrationParams    `json:"params,omitempty"`
+	Running  bool                `json:"running"`
+	Started  time.Time           `json:"started"`
+	Finished time.Time           `json:"finished"`
+	Progress *migration.Progress `json:"progress,omitempty"`
+	Error    string              `json:"error,omitempty"`
+	States   map[string]int      `json:"states"`
+}
+
+// migrations keeps track of migration runs started by this API instance, keyed by source server name.
+type migrations struct {
+	mu   sync.Mutex
+	runs map[string]*MigrationStatus
+}
+
+// start runs the migration in the background unless there's one running off the same server already.
+func (m *migrations) start(from string, params MigrationParams, opts migration.Options) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.runs == nil {
+		m.runs = map[string]*MigrationStatus{}
+	}
+	if run, ok := m.runs[from]; ok && run.Running {
+		return errors.Err(ErrMigrationRunning)
+	}
+	run := &MigrationStatus{Params: &params, Running: true, Started: time.Now()}
+	m.runs[from] = run
+
+	go func() {
+		progress, err := migration.Migrate(boil.GetDB(), opts)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		run.Running = false
+		run.Finished = time.Now()
+		run.Progress = progress
+		if err != nil {
+			logger.Log().Errorf("migration off %s failed: %v", from, err)
+			run.Error = err.Error()
+		}
+	}()
+	return nil
+}
+
+// status returns a copy of the last run off the server, an empty status if there was none.
+func (m *migrations) status(from string) MigrationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run, ok := m.runs[from]; ok {
+		return *run
+	}
+	return MigrationStatus{}
+}
+
+// StartMigration starts moving users off the server in the background.
+// Its progress can be followed with MigrationStatus.
+func (h *Handler) StartMigration(w http.ResponseWriter, r *http.Request) {
+	var params MigrationParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Err(err))
+		return
+	}
+	name := mux.Vars(r)["name"]
+	from, err := getServer(boil.GetDB(), name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	to, err := getServer(boil.GetDB(), params.To)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	opts := migration.Options{
+		From:            from,
+		To:              to,
+		DryRun:          params.DryRun,
+		Limit:           params.Limit,
+		SourceWalletDir: params.SourceWalletDir,
+		TargetWalletDir: params.TargetWalletDir,
+	}
+	if err := migration.Validate(opts); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.migrations.start(name, params, opts); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	logger.Log().Infof("started migrating users from %s to %s", from.Name, to.Name)
+	writeJSON(w, http.StatusAccepted, h.migrations.status(name))
+}
+
+// MigrationStatus reports the last migration run off the server and migration states of its users.
+func (h *Handler) MigrationStatus(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	from, err := getServer(boil.GetDB(), name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	status := h.migrations.status(name)
+	status.States, err = migration.StateCounts(boil.GetDB(), from.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
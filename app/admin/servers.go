@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/models"
+
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+	"github.com/lib/pq"
+	"github.com/volatiletech/null"
+	"github.com/volatiletech/sqlboiler/boil"
+	"github.com/volatiletech/sqlboiler/queries/qm"
+)
+
+const (
+	validationTimeout = 10 * time.Second
+
+	pgUniqueConstraintViolation = "23505"
+)
+
+var (
+	ErrServerNotFound = errors.Base("server not found")
+	ErrServerExists   = errors.Base("server with this name already exists")
+	ErrInvalidServer  = errors.Base("invalid server")
+	ErrServerInUse    = errors.Base("server has users assigned, drain it and migrate them first")
+)
+
+// ServerParams contains LbrynetServer fields that can be set via admin API.
+// Nil fields are left unchanged on update.
+type ServerParams struct {
+	Name     string  `json:"name"`
+	Address  *string `json:"address"`
+	Weight   *int    `json:"weight"`
+	Draining *bool   `json:"draining"`
+}
+
+// ValidateServer checks that there's a running lbrynet instance at the address.
+// It's a variable so it can be replaced in tests.
+var ValidateServer = func(address string) error {
+	c := ljsonrpc.NewClient(address)
+	c.SetRPCTimeout(validationTimeout)
+	status, err := c.Status()
+	if err != nil {
+		return errors.Prefix("calling status", err)
+	}
+	if !status.IsRunning {
+		return errors.Err("lbrynet is not running")
+	}
+	return nil
+}
+
+// ListServers returns all servers ordered by name.
+func ListServers(exec boil.Executor) (models.LbrynetServerSlice, error) {
+	servers, err := models.LbrynetServers(qm.OrderBy(models.LbrynetServerColumns.Name)).All(exec)
+	return servers, errors.Err(err)
+}
+
+// AddServer validates and stores a new server.
+func AddServer(exec boil.Executor, params ServerParams) (*models.LbrynetServer, error) {
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		return nil, invalidServer("name is required")
+	}
+	if params.Address == nil || strings.TrimSpace(*params.Address) == "" {
+		return nil, invalidServer("address is required")
+	}
+
+	server := &models.LbrynetServer{Name: params.Name}
+	if err := applyParams(server, params); err != nil {
+		return nil, err
+	}
+	if err := server.Insert(exec, boil.Infer()); err != nil {
+		// server names are unique in the database, checking before inserting would be racy
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueConstraintViolation {
+			return nil, errors.Err(ErrServerExists)
+		}
+		return nil, errors.Err(err)
+	}
+	return server, nil
+}
+
+// UpdateServer changes address, weight or drain flag of an existing server.
+// The server is validated again if its address changes.
+func UpdateServer(exec boil.Executor, name string, params ServerParams) (*models.LbrynetServer, error) {
+	server, err := getServer(exec, name)
+	if err != nil {
+		return nil, err
+	}
+	if params.Address != nil && *params.Address == server.Address {
+		params.Address = nil
+	}
+	if err := applyParams(server, params); err != nil {
+		return nil, err
+	}
+	if _, err := server.Update(exec, boil.Infer()); err != nil {
+		return nil, errors.Err(err)
+	}
+	return server, nil
+}
+
+// RemoveServer deletes a server. Servers that still have users assigned cannot be removed.
+func RemoveServer(exec boil.Executor, name string) error {
+	server, err := getServer(exec, name)
+	if err != nil {
+		return err
+	}
+	users, err := models.Users(models.UserWhere.LbrynetServerID.EQ(null.IntFrom(server.ID))).Count(exec)
+	if err != nil {
+		return errors.Err(err)
+	}
+	if users > 0 {
+		return errors.Err(ErrServerInUse)
+	}
+	_, err = server.Delete(exec)
+	return errors.Err(err)
+}
+
+func getServer(exec boil.Executor, name string) (*models.LbrynetServer, error) {
+	server, err := models.LbrynetServers(models.LbrynetServerWhere.Name.EQ(name)).One(exec)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Err(ErrServerNotFound)
+	} else if err != nil {
+		return nil, errors.Err(err)
+	}
+	return server, nil
+}
+
+func applyParams(server *models.LbrynetServer, params ServerParams) error {
+	if params.Address != nil {
+		address := strings.TrimSpace(*params.Address)
+		if err := ValidateServer(address); err != nil {
+			return invalidServer("%v: %v", address, err)
+		}
+		server.Address = address
+	}
+	if params.Weight != nil {
+		if *params.Weight < 0 {
+			return invalidServer("weight cannot be negative")
+		}
+		server.Weight = *params.Weight
+	}
+	if params.Draining != nil {
+		server.Draining = *params.Draining
+	}
+	return nil
+}
+
+func invalidServer(format string, a ...interface{}) error {
+	return errors.Err("%w: "+format, append([]interface{}{ErrInvalidServer}, a...)...)
+}
@@ -7,10 +7,12 @@ package proxy
 // remote clients.
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 
 	"github.com/lbryio/lbrytv/app/auth"
 	"github.com/lbryio/lbrytv/app/query"
@@ -18,6 +20,8 @@ import (
 	"github.com/lbryio/lbrytv/app/rpcerrors"
 	"github.com/lbryio/lbrytv/app/sdkrouter"
 	"github.com/lbryio/lbrytv/app/wallet"
+	"github.com/lbryio/lbrytv/app/wallet/migration"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
 	"github.com/lbryio/lbrytv/internal/audit"
 	"github.com/lbryio/lbrytv/internal/errors"
 	"github.com/lbryio/lbrytv/internal/ip"
@@ -28,6 +32,7 @@ import (
 	"github.com/lbryio/lbrytv/models"
 	"github.com/sirupsen/logrus"
 
+	"github.com/volatiletech/sqlboiler/boil"
 	"github.com/ybbus/jsonrpc"
 )
 
@@ -52,6 +57,7 @@ func writeResponse(w http.ResponseWriter, b []byte) {
 }
 
 // Handle forwards client JSON-RPC request to proxy.
+// It accepts both single queries and JSON-RPC batches (arrays of queries).
 func Handle(w http.ResponseWriter, r *http.Request) {
 	responses.AddJSONContentType(w)
 
@@ -75,6 +81,11 @@ func Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isBatch(body) {
+		handleBatch(w, r, body)
+		return
+	}
+
 	var rpcReq *jsonrpc.RPCRequest
 	err = json.Unmarshal(body, &rpcReq)
 	if err != nil {
@@ -86,16 +97,119 @@ func Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	serialized, err := proxyQuery(r, rpcReq, body)
+	if err != nil {
+		writeResponse(w, rpcerrors.ToJSON(err))
+		return
+	}
+
+	writeResponse(w, serialized)
+}
+
+// handleBatch processes a JSON-RPC batch request. Queries are performed concurrently,
+// each one going through the same checks as a single query would.
+// Responses are returned in the same order as the queries in the batch.
+func handleBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	var rawQueries []json.RawMessage
+	err := json.Unmarshal(body, &rawQueries)
+	if err != nil {
+		writeResponse(w, rpcerrors.NewJSONParseError(err).JSON())
+
+		observeFailure(metrics.GetDuration(r), "", metrics.FailureKindClientJSON)
+		logger.Log().Debugf("error unmarshaling batch request body: %v", err)
+
+		return
+	}
+
+	if len(rawQueries) == 0 {
+		writeResponse(w, rpcerrors.NewInvalidRequestError(errors.Err("empty batch")).JSON())
+		observeFailure(metrics.GetDuration(r), "", metrics.FailureKindClient)
+		return
+	}
+
+	maxSize := config.GetMaxBatchSize()
+	if len(rawQueries) > maxSize {
+		writeResponse(w, rpcerrors.NewInvalidRequestError(
+			fmt.Errorf("batch is too large: %d queries supplied, maximum is %d", len(rawQueries), maxSize)).JSON())
+		observeFailure(metrics.GetDuration(r), "", metrics.FailureKindClient)
+		return
+	}
+
+	logger.Log().Tracef("batch of %d queries", len(rawQueries))
+
+	results := make([]json.RawMessage, len(rawQueries))
+	wg := sync.WaitGroup{}
+	for i, rq := range rawQueries {
+		wg.Add(1)
+		go func(i int, rawQuery []byte) {
+			defer wg.Done()
+			results[i] = proxyBatchItem(r, rawQuery)
+		}(i, rq)
+	}
+	wg.Wait()
+
+	serialized, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		monitor.ErrorToSentry(err)
+
+		writeResponse(w, rpcerrors.NewInternalError(err).JSON())
+
+		logger.Log().Errorf("error marshaling batch response: %v", err)
+		observeFailure(metrics.GetDuration(r), "", metrics.FailureKindRPCJSON)
+
+		return
+	}
+
+	writeResponse(w, serialized)
+}
+
+// proxyBatchItem performs a single query from the batch.
+// Errors are returned as JSON-RPC error responses so they occupy their place in the batch response.
+func proxyBatchItem(r *http.Request, rawQuery []byte) json.RawMessage {
+	var rpcReq *jsonrpc.RPCRequest
+	err := json.Unmarshal(rawQuery, &rpcReq)
+	if err != nil || rpcReq == nil {
+		if err == nil {
+			err = errors.Err("invalid request object")
+		}
+		observeFailure(metrics.GetDuration(r), "", metrics.FailureKindClientJSON)
+		logger.Log().Debugf("error unmarshaling batch item: %v", err)
+		return errorResponse(rpcerrors.NewJSONParseError(err), 0)
+	}
+
+	serialized, err := proxyQuery(r, rpcReq, rawQuery)
+	if err != nil {
+		return errorResponse(err, rpcReq.ID)
+	}
+	return serialized
+}
+
+func errorResponse(err error, id int) json.RawMessage {
+	b, mErr := json.Marshal(rpcerrors.ToResponse(err, id))
+	if mErr != nil {
+		logger.Log().Errorf("error marshaling error response: %v", mErr)
+	}
+	return b
+}
+
+// isBatch returns true if the request body contains a JSON array.
+func isBatch(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// proxyQuery performs authorization checks and sends the query to lbrynet,
+// returning a serialized response or an error that should be reported back to the client.
+// rawQuery is the query as it was received from the client, used for audit logging.
+func proxyQuery(r *http.Request, rpcReq *jsonrpc.RPCRequest, rawQuery []byte) ([]byte, error) {
 	logger.Log().Tracef("call to method %s", rpcReq.Method)
 
 	user, err := auth.FromRequest(r)
 	if query.MethodRequiresWallet(rpcReq.Method, rpcReq.Params) {
 		authErr := GetAuthError(user, err)
 		if authErr != nil {
-			writeResponse(w, rpcerrors.ErrorToJSON(authErr))
 			observeFailure(metrics.GetDuration(r), rpcReq.Method, metrics.FailureKindAuth)
-
-			return
+			return nil, authErr
 		}
 	}
 
@@ -114,44 +228,72 @@ func Handle(w http.ResponseWriter, r *http.Request) {
 	if cache.IsOnRequest(r) {
 		qCache = cache.FromRequest(r)
 	}
-	c := query.NewCaller(sdkAddress, userID)
-
 	remoteIP := ip.FromRequest(r)
-	// Logging remote IP with query
-	c.AddPostflightHook("wallet_", func(_ *query.Caller, hctx *query.HookContext) (*jsonrpc.RPCResponse, error) {
-		hctx.AddLogField("remote_ip", remoteIP)
-		return nil, nil
-	}, "")
-	c.AddPostflightHook(query.MethodWalletSend, func(_ *query.Caller, hctx *query.HookContext) (*jsonrpc.RPCResponse, error) {
-		audit.LogQuery(userID, remoteIP, query.MethodWalletSend, body)
-		return nil, nil
-	}, "")
+	opts := query.OptionsFromRequest(r)
+	newCaller := func(sdkAddress string) *query.Caller {
+		c := query.NewCaller(sdkAddress, userID)
+		c.CallerOptions = opts
+		c.Router = sdkrouter.FromRequest(r)
+		// Logging remote IP with query
+		c.AddPostflightHook("wallet_", func(_ *query.Caller, hctx *query.HookContext) (*jsonrpc.RPCResponse, error) {
+			hctx.AddLogField("remote_ip", remoteIP)
+			return nil, nil
+		}, "")
+		for _, method := range []string{query.MethodWalletSend, query.MethodAccountSend} {
+			method := method
+			c.AddPostflightHook(method, func(_ *query.Caller, hctx *query.HookContext) (*jsonrpc.RPCResponse, error) {
+				audit.LogQuery(userID, remoteIP, method, rawQuery)
+				return nil, nil
+			}, "")
+		}
 
-	lbrynext.InstallHooks(c)
-	c.Cache = qCache
+		lbrynext.InstallHooks(c)
+		c.Cache = qCache
+		c.CheckWalletReload = func(endpoint string, userID int) error {
+			return migration.CheckReload(boil.GetDB(), endpoint, userID)
+		}
+		c.AlternativeEndpoint = func(exclude ...string) string {
+			if s := sdkrouter.FromRequest(r).AlternativeServer(exclude...); s != nil {
+				return s.Address
+			}
+			return ""
+		}
+		return c
+	}
 
-	rpcRes, err := c.Call(rpcReq)
+	rpcRes, err := newCaller(sdkAddress).CallContext(r.Context(), rpcReq)
+	// Queries made without a wallet can be served by any other server while this one is failing
+	if userID == 0 && errors.Is(err, sdkrouter.ErrCircuitOpen) {
+		if fallback := sdkrouter.FromRequest(r).AlternativeServer(sdkAddress); fallback != nil {
+			logger.Log().Infof("circuit breaker for %v is open, sending %v query to %v", sdkAddress, rpcReq.Method, fallback.Address)
+			sdkAddress = fallback.Address
+			rpcRes, err = newCaller(sdkAddress).CallContext(r.Context(), rpcReq)
+		}
+	}
 
 	if err != nil {
+		kind := query.FailureKind(err)
+		if kind == metrics.FailureKindCanceled {
+			// Nobody is waiting for the response
+			observeFailure(metrics.GetDuration(r), rpcReq.Method, kind)
+			return nil, err
+		}
 		monitor.ErrorToSentry(err, map[string]string{"request": fmt.Sprintf("%+v", rpcReq), "response": fmt.Sprintf("%+v", rpcRes)})
-		writeResponse(w, rpcerrors.ToJSON(err))
 
 		logger.Log().Errorf("error calling lbrynet: %v, request: %+v", err, rpcReq)
-		observeFailure(metrics.GetDuration(r), rpcReq.Method, metrics.FailureKindNet)
+		observeFailure(metrics.GetDuration(r), rpcReq.Method, kind)
 
-		return
+		return nil, err
 	}
 
 	serialized, err := responses.JSONRPCSerialize(rpcRes)
 	if err != nil {
 		monitor.ErrorToSentry(err)
 
-		writeResponse(w, rpcerrors.NewInternalError(err).JSON())
-
 		logger.Log().Errorf("error marshaling response: %v", err)
 		observeFailure(metrics.GetDuration(r), rpcReq.Method, metrics.FailureKindRPCJSON)
 
-		return
+		return nil, rpcerrors.NewInternalError(err)
 	}
 
 	if rpcRes.Error != nil {
@@ -165,7 +307,7 @@ func Handle(w http.ResponseWriter, r *http.Request) {
 		observeSuccess(metrics.GetDuration(r), rpcReq.Method)
 	}
 
-	writeResponse(w, serialized)
+	return serialized, nil
 }
 
 // HandleCORS returns necessary CORS headers for pre-flight requests to proxy API
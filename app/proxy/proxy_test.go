@@ -6,12 +6,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/lbryio/lbrytv/app/auth"
 	"github.com/lbryio/lbrytv/app/sdkrouter"
 	"github.com/lbryio/lbrytv/app/wallet"
 	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
 	"github.com/lbryio/lbrytv/internal/middleware"
+	"github.com/lbryio/lbrytv/models"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -89,3 +92,163 @@ func TestProxyDontAuthRelaxedMethods(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 0, apiCalls)
 }
+
+// newEchoServer creates a mock SDK server which responds with the method name of the query it receives
+func newEchoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonrpc.RPCRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		require.NoError(t, err)
+		err = json.NewEncoder(w).Encode(jsonrpc.RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: req.Method})
+		require.NoError(t, err)
+	}))
+}
+
+func batchHandler(rt *sdkrouter.Router) http.Handler {
+	return middleware.Apply(
+		middleware.Chain(
+			sdkrouter.Middleware(rt),
+			auth.NilMiddleware,
+		), Handle)
+}
+
+func TestProxyBatch(t *testing.T) {
+	config.Override("LbrynetXPercentage", 0)
+	defer config.RestoreOverridden()
+
+	ts := newEchoServer(t)
+	defer ts.Close()
+	rt := sdkrouter.New(map[string]string{"srv": ts.URL})
+
+	batch := []*jsonrpc.RPCRequest{
+		{JSONRPC: "2.0", ID: 10, Method: "resolve", Params: map[string]interface{}{"urls": "what"}},
+		{JSONRPC: "2.0", ID: 20, Method: "wallet_balance"},
+		{JSONRPC: "2.0", ID: 30, Method: "version"},
+	}
+	raw, err := json.Marshal(batch)
+	require.NoError(t, err)
+
+	r, err := http.NewRequest("POST", "/api/v1/proxy", bytes.NewBuffer(raw))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	batchHandler(rt).ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var res []jsonrpc.RPCResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &res)
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+
+	assert.Equal(t, 10, res[0].ID)
+	assert.Nil(t, res[0].Error)
+	assert.Equal(t, "resolve", res[0].Result)
+
+	assert.Equal(t, 20, res[1].ID)
+	require.NotNil(t, res[1].Error)
+	assert.Equal(t, -32084, res[1].Error.Code)
+
+	assert.Equal(t, 30, res[2].ID)
+	assert.Nil(t, res[2].Error)
+	assert.Equal(t, "version", res[2].Result)
+}
+
+func TestProxyBatchInvalidItem(t *testing.T) {
+	config.Override("LbrynetXPercentage", 0)
+	defer config.RestoreOverridden()
+
+	ts := newEchoServer(t)
+	defer ts.Close()
+	rt := sdkrouter.New(map[string]string{"srv": ts.URL})
+
+	r, err := http.NewRequest("POST", "/api/v1/proxy", bytes.NewBuffer([]byte(
+		`[{"jsonrpc": "2.0", "id": 1, "method": "version"}, 1, null]`)))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	batchHandler(rt).ServeHTTP(rr, r)
+
+	var res []jsonrpc.RPCResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &res)
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	assert.Equal(t, "version", res[0].Result)
+	require.NotNil(t, res[1].Error)
+	assert.Equal(t, -32700, res[1].Error.Code)
+	require.NotNil(t, res[2].Error)
+	assert.Equal(t, -32700, res[2].Error.Code)
+}
+
+func TestProxyBatchLimits(t *testing.T) {
+	config.Override("MaxBatchSize", 2)
+	defer config.RestoreOverridden()
+
+	rt := sdkrouter.New(config.GetLbrynetServers())
+
+	cases := map[string]string{
+		"empty batch":        `[]`,
+		"batch is too large": `[{"method": "version"}, {"method": "version"}, {"method": "version"}]`,
+	}
+	for msg, body := range cases {
+		t.Run(msg, func(t *testing.T) {
+			r, err := http.NewRequest("POST", "/api/v1/proxy", bytes.NewBuffer([]byte(body)))
+			require.NoError(t, err)
+			rr := httptest.NewRecorder()
+			batchHandler(rt).ServeHTTP(rr, r)
+
+			var res jsonrpc.RPCResponse
+			err = json.Unmarshal(rr.Body.Bytes(), &res)
+			require.NoError(t, err)
+			require.NotNil(t, res.Error)
+			assert.Equal(t, -32600, res.Error.Code)
+			assert.Contains(t, res.Error.Message, msg)
+		})
+	}
+}
+
+func TestProxyCircuitOpenFallback(t *testing.T) {
+	config.Override("LbrynetXPercentage", 0)
+	defer config.RestoreOverridden()
+	ts := newEchoServer(t)
+	defer ts.Close()
+	bad := &models.LbrynetServer{Name: "bad", Address: "http://bad.invalid:5279/"}
+	good := &models.LbrynetServer{Name: "good", Address: ts.URL}
+	draining := &models.LbrynetServer{Name: "draining", Address: "http://draining.invalid:5279/", Draining: true}
+	rt := sdkrouter.NewWithServers(bad, good, draining)
+	rt.SetBreakerSettings(sdkrouter.BreakerSettings{
+		Window: time.Minute, MinRequests: 1, ErrorRate: 0.5, OpenDuration: time.Hour, HalfOpenRequests: 1,
+	})
+
+	c, err := rt.AllowCall(bad.Address)
+	require.NoError(t, err)
+	c.Done(time.Second, errors.Err("connection refused"))
+	require.True(t, rt.CircuitOpen(bad.Address))
+
+	user := &models.User{ID: 1}
+	user.R = user.R.NewStruct()
+	user.R.LbrynetServer = bad
+	provider := func(token, ip string) (*models.User, error) { return user, nil }
+	handler := middleware.Apply(middleware.Chain(sdkrouter.Middleware(rt), auth.Middleware(provider)), Handle)
+
+	call := func(method string) jsonrpc.RPCResponse {
+		raw, err := json.Marshal(jsonrpc.NewRequest(method))
+		require.NoError(t, err)
+		r, err := http.NewRequest("POST", "/api/v1/proxy", bytes.NewBuffer(raw))
+		require.NoError(t, err)
+		r.Header.Set(wallet.TokenHeader, "abc")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var res jsonrpc.RPCResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		return res
+	}
+
+	for i := 0; i < 10; i++ {
+		res := call("version")
+		require.Nil(t, res.Error, "queries without a wallet should go to another healthy server")
+		assert.Equal(t, "version", res.Result)
+	}
+
+	res := call("wallet_balance")
+	require.NotNil(t, res.Error, "queries with a wallet should fail fast")
+	assert.Equal(t, -32088, res.Error.Code)
+}
@@ -109,11 +109,19 @@ func (h Handler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	c := getCaller(sdkrouter.GetSDKAddress(user), f.Name(), user.ID, qCache)
+	if sdkrouter.IsOnRequest(r) {
+		c.Router = sdkrouter.FromRequest(r)
+	}
 
 	op := metrics.StartOperation("sdk", "call_publish")
-	rpcRes, err := c.Call(rpcReq)
+	rpcRes, err := c.CallContext(r.Context(), rpcReq)
 	op.End()
 	if err != nil {
+		failureKind := metrics.FailureKindRPC
+		if kind := query.FailureKind(err); kind != metrics.FailureKindNet {
+			// the client is gone or the query took too long, neither is an SDK failure
+			failureKind = kind
+		}
 		monitor.ErrorToSentry(
 			fmt.Errorf("error calling publish: %v", err),
 			map[string]string{
@@ -123,7 +131,7 @@ func (h Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		)
 		logger.Log().Errorf("error calling publish: %v, request: %+v", err, rpcReq)
 		w.Write(rpcerrors.ToJSON(err))
-		observeFailure(metrics.GetDuration(r), metrics.FailureKindRPC)
+		observeFailure(metrics.GetDuration(r), failureKind)
 		return
 	}
 
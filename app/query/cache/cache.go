@@ -1,9 +1,6 @@
 package cache
 
 import (
-	"crypto/sha256"
-	"encoding/gob"
-	"encoding/hex"
 	"fmt"
 	"time"
 
@@ -17,25 +14,60 @@ var cacheLogger = monitor.NewModuleLogger("cache")
 
 // QueryCache caches Query responses
 type QueryCache interface {
-	Save(method string, params interface{}, r interface{})
+	// Save stores the response for ttl, the default cache expiration is used when ttl is zero.
+	// Tags allow to invalidate related entries at once, e.g. all responses containing a certain claim.
+	Save(method string, params interface{}, r interface{}, ttl time.Duration, tags ...string)
 	Retrieve(method string, params interface{}) interface{}
 	Count() int
 
+	// Invalidate removes the response saved for method and params.
+	Invalidate(method string, params interface{}) int
+	// InvalidateMethod removes responses for all methods matching the pattern, e.g. "claim_*" or "*".
+	InvalidateMethod(pattern string) int
+	// InvalidateTag removes responses saved with the tag.
+	InvalidateTag(tag string) int
+
 	getKey(method string, params interface{}) (string, error)
 	flush()
 }
 
+const (
+	BackendMemory = "memory"
+	BackendLRU    = "lru"
+	BackendRedis  = "redis"
+)
+
+// New creates a QueryCache of the backend type. maxSize (in bytes) is only used by the lru backend,
+// redisURL only by the redis backend.
+func New(backend, redisURL string, ttl time.Duration, maxSize int64) (QueryCache, error) {
+	switch backend {
+	case BackendMemory, "":
+		return NewMemoryCache(), nil
+	case BackendLRU:
+		if maxSize <= 0 {
+			return nil, fmt.Errorf("query cache size limit should be positive, got %v", maxSize)
+		}
+		return NewLRUCache("query", maxSize, ttl), nil
+	case BackendRedis:
+		return NewRedisCache(redisURL, ttl)
+	}
+	return nil, fmt.Errorf("unknown query cache backend: %v", backend)
+}
+
 // memoryCache stores the cache in memory
 type memoryCache struct {
-	c *cache.Cache
+	c    *cache.Cache
+	tags *tagIndex
 }
 
 func NewMemoryCache() memoryCache {
-	return memoryCache{c: cache.New(5*time.Minute, 15*time.Minute)}
+	s := memoryCache{c: cache.New(5*time.Minute, 15*time.Minute), tags: newTagIndex()}
+	s.c.OnEvicted(func(key string, _ interface{}) { s.tags.remove(key) })
+	return s
 }
 
 // Save puts a response object into cache, making it available for a later retrieval by method and query params
-func (s memoryCache) Save(method string, params interface{}, r interface{}) {
+func (s memoryCache) Save(method string, params interface{}, r interface{}, ttl time.Duration, tags ...string) {
 	l := cacheLogger.WithFields(logrus.Fields{"method": method})
 	cacheKey, err := s.getKey(method, params)
 	if err != nil {
@@ -43,7 +75,11 @@ func (s memoryCache) Save(method string, params interface{}, r interface{}) {
 	} else {
 		l.Debug("saved query result")
 	}
-	s.c.Set(cacheKey, r, cache.DefaultExpiration)
+	if ttl == 0 {
+		ttl = cache.DefaultExpiration
+	}
+	s.c.Set(cacheKey, r, ttl)
+	s.tags.set(cacheKey, tags)
 }
 
 // Retrieve earlier saved server response by method and query params
@@ -61,26 +97,50 @@ func (s memoryCache) Retrieve(method string, params interface{}) interface{} {
 	return cachedResponse
 }
 
-func (s memoryCache) getKey(method string, params interface{}) (key string, err error) {
-	var paramsSuffix string
+func (s memoryCache) getKey(method string, params interface{}) (string, error) {
+	return Key(method, params)
+}
 
-	if params != nil {
-		h := sha256.New()
-		enc := gob.NewEncoder(h)
-		err = enc.Encode(fmt.Sprintf("%v", params))
-		if err != nil {
-			return "", err
+// Invalidate removes the response saved for method and params.
+func (s memoryCache) Invalidate(method string, params interface{}) int {
+	cacheKey, err := s.getKey(method, params)
+	if err != nil {
+		return 0
+	}
+	if _, ok := s.c.Get(cacheKey); !ok {
+		return 0
+	}
+	s.c.Delete(cacheKey)
+	return 1
+}
+
+// InvalidateMethod removes responses for all methods matching the pattern.
+func (s memoryCache) InvalidateMethod(pattern string) int {
+	var n int
+	for k := range s.c.Items() {
+		if keyMatchesMethod(k, pattern) {
+			s.c.Delete(k)
+			n++
 		}
-		paramsSuffix = hex.EncodeToString(h.Sum(nil))
-	} else {
-		paramsSuffix = "nil"
 	}
+	return n
+}
 
-	return fmt.Sprintf("%v|%v", method, paramsSuffix), err
+// InvalidateTag removes responses saved with the tag.
+func (s memoryCache) InvalidateTag(tag string) int {
+	var n int
+	for _, k := range s.tags.get(tag) {
+		if _, ok := s.c.Get(k); ok {
+			n++
+		}
+		s.c.Delete(k)
+	}
+	return n
 }
 
 func (s memoryCache) flush() {
 	s.c.Flush()
+	s.tags.flush()
 }
 
 // Count returns the total number of non-expired items stored in cache
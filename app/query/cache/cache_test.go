@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/ybbus/jsonrpc"
@@ -36,7 +37,7 @@ func TestCache(t *testing.T) {
 	c := NewMemoryCache()
 	c.flush()
 	assert.Nil(t, c.Retrieve("resolve", query.Params))
-	c.Save("resolve", query.Params, response.Result)
+	c.Save("resolve", query.Params, response.Result, 0)
 	assert.Equal(t, 1, c.Count())
 	assert.Equal(t, response.Result, c.Retrieve("resolve", query.Params))
 }
@@ -45,10 +46,63 @@ func TestCacheGetKey(t *testing.T) {
 	c := NewMemoryCache()
 	c.flush()
 	key, err := c.getKey("resolve", map[string]interface{}{"urls": "one"})
-	assert.Equal(t, "resolve|3600a4eed065d3ae3dd503cca56ce56ae6bd4778047fa1b17c999301681d3a1d", key)
+	assert.Equal(t, "v2:resolve|2555c7dbd8f2a88da89f9c29cf7270a23f69a07b1a15fc88895fbbd3f1923b03", key)
 	assert.NoError(t, err)
 
 	key, err = c.getKey("wallet_balance", nil)
-	assert.Equal(t, "wallet_balance|nil", key)
+	assert.Equal(t, "v2:wallet_balance|nil", key)
 	assert.NoError(t, err)
 }
+
+func testInvalidation(t *testing.T, c QueryCache) {
+	resolveParams := map[string]interface{}{"urls": []interface{}{"one", "two"}}
+	searchParams := map[string]interface{}{"channel_ids": []interface{}{"abc"}}
+
+	c.Save("resolve", resolveParams, "resolved", 0, "claim:one", "claim:two")
+	c.Save("claim_search", searchParams, "found", 0, "claim:abc", "claim:one")
+	c.Save("claim_list", nil, "listed", 0)
+	assert.Equal(t, 3, c.Count())
+
+	assert.Equal(t, 1, c.Invalidate("claim_list", nil))
+	assert.Equal(t, 0, c.Invalidate("claim_list", nil))
+	assert.Nil(t, c.Retrieve("claim_list", nil))
+
+	assert.Equal(t, 2, c.InvalidateTag("claim:one"))
+	assert.Nil(t, c.Retrieve("resolve", resolveParams))
+	assert.Nil(t, c.Retrieve("claim_search", searchParams))
+	assert.Equal(t, 0, c.InvalidateTag("claim:two"), "invalidated entries should not be counted again")
+
+	c.Save("resolve", resolveParams, "resolved", 0)
+	c.Save("claim_search", searchParams, "found", 0)
+	c.Save("claim_list", nil, "listed", 0)
+	assert.Equal(t, 2, c.InvalidateMethod("claim_*"))
+	assert.NotNil(t, c.Retrieve("resolve", resolveParams))
+	assert.Equal(t, 1, c.InvalidateMethod("*"))
+	assert.Equal(t, 0, c.Count())
+}
+
+func TestMemoryCacheInvalidation(t *testing.T) {
+	testInvalidation(t, NewMemoryCache())
+}
+
+func TestMemoryCacheTagsCleanedUp(t *testing.T) {
+	c := NewMemoryCache()
+	c.Save("resolve", nil, "resolved", time.Millisecond, "claim:one")
+	time.Sleep(5 * time.Millisecond)
+	c.c.DeleteExpired()
+	assert.Empty(t, c.tags.get("claim:one"))
+	assert.Empty(t, c.tags.keyTags)
+
+	c.Save("resolve", nil, "resolved", 0, "claim:one")
+	c.Save("resolve", nil, "resolved again", 0, "claim:two")
+	assert.Empty(t, c.tags.get("claim:one"), "tags should be replaced when the entry is overwritten")
+	assert.Len(t, c.tags.get("claim:two"), 1)
+}
+
+func TestKeyMatchesMethod(t *testing.T) {
+	assert.True(t, keyMatchesMethod("v2:claim_search|abcdef", "claim_*"))
+	assert.True(t, keyMatchesMethod("v2:claim_search|abcdef", "*"))
+	assert.True(t, keyMatchesMethod("v2:wallet_balance|nil", "wallet_balance"))
+	assert.False(t, keyMatchesMethod("v2:resolve|abcdef", "claim_*"))
+	assert.False(t, keyMatchesMethod("v2:claim_search|abcdef", "claim"))
+}
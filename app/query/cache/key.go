@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+)
+
+// KeyVersion is prepended to all cache keys. It should be bumped whenever the way keys are produced changes
+// so entries saved by older API instances in a shared cache are not picked up.
+const KeyVersion = "v2"
+
+// unorderedParams are list params whose order doesn't affect SDK response.
+var unorderedParams = map[string]bool{
+	"urls":            true,
+	"claim_ids":       true,
+	"channel_ids":     true,
+	"not_channel_ids": true,
+	"any_tags":        true,
+	"all_tags":        true,
+	"not_tags":        true,
+	"any_languages":   true,
+	"all_languages":   true,
+	"not_languages":   true,
+	"any_locations":   true,
+	"all_locations":   true,
+	"not_locations":   true,
+	"stream_types":    true,
+	"media_types":     true,
+	"claim_type":      true,
+}
+
+var (
+	ignoredParams     map[string]bool
+	ignoredParamsOnce sync.Once
+)
+
+// configuredIgnoredParams returns params excluded from cache keys, read from the config once.
+func configuredIgnoredParams() map[string]bool {
+	ignoredParamsOnce.Do(func() {
+		ignoredParams = map[string]bool{}
+		for _, p := range config.GetQueryCacheIgnoredParams() {
+			ignoredParams[p] = true
+		}
+	})
+	return ignoredParams
+}
+
+// Key produces a cache key for method and params, it's shared by all QueryCache implementations.
+// Equivalent params produce the same key regardless of map key order, order of unorderedParams items
+// or the way numbers are represented. Params set to be ignored in the config are left out.
+func Key(method string, params interface{}) (string, error) {
+	return canonicalKey(method, params, configuredIgnoredParams())
+}
+
+func canonicalKey(method string, params interface{}, ignored map[string]bool) (string, error) {
+	paramsSuffix := "nil"
+	p, err := canonicalParams(params, ignored)
+	if err != nil {
+		return "", err
+	}
+	if p != nil {
+		h := sha256.Sum256(p)
+		paramsSuffix = hex.EncodeToString(h[:])
+	}
+	return fmt.Sprintf("%v:%v|%v", KeyVersion, method, paramsSuffix), nil
+}
+
+// canonicalParams returns the canonical JSON representation of params, nil if there are no params.
+func canonicalParams(params interface{}, ignored map[string]bool) ([]byte, error) {
+	if params == nil {
+		return nil, nil
+	}
+
+	// Round-trip params through JSON to get rid of specific types
+	s, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(s))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, p := range m {
+			if ignored[k] {
+				delete(m, k)
+				continue
+			}
+			m[k] = normalize(p)
+			if l, ok := m[k].([]interface{}); ok && unorderedParams[k] {
+				if err := sortList(l); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(m) == 0 {
+			return nil, nil
+		}
+		v = m
+	} else {
+		v = normalize(v)
+	}
+
+	// Map keys are sorted by json.Marshal
+	return json.Marshal(v)
+}
+
+// normalize converts numbers to their shortest representation, recursively.
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if _, err := strconv.ParseInt(val.String(), 10, 64); err == nil {
+			return val
+		}
+		f, err := val.Float64()
+		if err != nil {
+			return val
+		}
+		if f == math.Trunc(f) && math.Abs(f) < math.MaxInt64 {
+			return json.Number(strconv.FormatInt(int64(f), 10))
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+	case []interface{}:
+		for i := range val {
+			val[i] = normalize(val[i])
+		}
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = normalize(val[k])
+		}
+	}
+	return v
+}
+
+// sortList sorts list items by their JSON representation.
+func sortList(l []interface{}) error {
+	encoded := make([]string, len(l))
+	for i, item := range l {
+		s, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		encoded[i] = string(s)
+	}
+	sort.Sort(byEncoding{l, encoded})
+	return nil
+}
+
+type byEncoding struct {
+	items   []interface{}
+	encoded []string
+}
+
+func (b byEncoding) Len() int           { return len(b.items) }
+func (b byEncoding) Less(i, j int) bool { return b.encoded[i] < b.encoded[j] }
+func (b byEncoding) Swap(i, j int) {
+	b.items[i], b.items[j] = b.items[j], b.items[i]
+	b.encoded[i], b.encoded[j] = b.encoded[j], b.encoded[i]
+}
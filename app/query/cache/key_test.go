@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustKey(t *testing.T, method string, params interface{}, ignored map[string]bool) string {
+	key, err := canonicalKey(method, params, ignored)
+	require.NoError(t, err)
+	return key
+}
+
+func TestKeyCanonical(t *testing.T) {
+	key := func(params interface{}) string { return mustKey(t, "claim_search", params, nil) }
+	assert.Equal(t,
+		key(map[string]interface{}{"page": 1, "page_size": 20, "any_tags": []string{"a", "b"}}),
+		key(map[string]interface{}{"page_size": 20.0, "page": json.Number("1.0"), "any_tags": []interface{}{"b", "a"}}),
+	)
+	assert.Equal(t, key(nil), key(map[string]interface{}{}))
+	assert.Equal(t, key(map[string]interface{}{"fee_amount": 1e6}), key(map[string]interface{}{"fee_amount": 1000000}))
+	assert.Equal(t, key(map[string]interface{}{"fee_amount": 0.5}), key(map[string]interface{}{"fee_amount": json.Number("5e-1")}))
+
+	assert.NotEqual(t, key(map[string]interface{}{"order_by": []string{"a", "b"}}), key(map[string]interface{}{"order_by": []string{"b", "a"}}),
+		"order of order_by matters")
+	assert.NotEqual(t, key(map[string]interface{}{"page": 1}), key(map[string]interface{}{"page": 2}))
+	assert.NotEqual(t, key(map[string]interface{}{"page": 1}), key(map[string]interface{}{"page": "1"}))
+	assert.NotEqual(t, mustKey(t, "resolve", nil, nil), mustKey(t, "claim_search", nil, nil))
+	assert.True(t, strings.HasPrefix(key(nil), KeyVersion+":claim_search|"))
+}
+
+func TestKeyIgnoredParams(t *testing.T) {
+	ignored := map[string]bool{"wallet_id": true}
+	assert.Equal(t,
+		mustKey(t, "resolve", map[string]interface{}{"urls": "one"}, ignored),
+		mustKey(t, "resolve", map[string]interface{}{"urls": "one", "wallet_id": "lbrytv-id.1.wallet"}, ignored),
+	)
+	assert.Equal(t,
+		mustKey(t, "wallet_balance", nil, ignored),
+		mustKey(t, "wallet_balance", map[string]interface{}{"wallet_id": "lbrytv-id.1.wallet"}, ignored),
+	)
+	assert.NotEqual(t,
+		mustKey(t, "resolve", map[string]interface{}{"urls": "one"}, nil),
+		mustKey(t, "resolve", map[string]interface{}{"urls": "one", "wallet_id": "lbrytv-id.1.wallet"}, nil),
+	)
+}
+
+func TestConfiguredIgnoredParams(t *testing.T) {
+	config.Override("QueryCacheIgnoredParams", []string{"wallet_id"})
+	defer config.RestoreOverridden()
+	assert.Equal(t, []string{"wallet_id"}, config.GetQueryCacheIgnoredParams())
+}
+
+// Property tests below check that equivalent queries always map to the same key.
+
+func shuffled(r *rand.Rand, items []string) []interface{} {
+	l := make([]interface{}, len(items))
+	for i, j := range r.Perm(len(items)) {
+		l[i] = items[j]
+	}
+	return l
+}
+
+func TestKeyUnorderedParamsProperty(t *testing.T) {
+	f := func(urls, tags []string, page uint8, seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		a := map[string]interface{}{"urls": urls, "any_tags": tags, "page": int(page)}
+		b := map[string]interface{}{"any_tags": shuffled(r, tags), "page": float64(page), "urls": shuffled(r, urls)}
+		return mustKey(t, "resolve", a, nil) == mustKey(t, "resolve", b, nil)
+	}
+	assert.NoError(t, quick.Check(f, nil))
+}
+
+func TestKeyIgnoredParamsProperty(t *testing.T) {
+	ignored := map[string]bool{"wallet_id": true}
+	f := func(params map[string]string, walletID string) bool {
+		withWallet := map[string]interface{}{"wallet_id": walletID}
+		without := map[string]interface{}{}
+		for k, v := range params {
+			if k == "wallet_id" {
+				continue
+			}
+			withWallet[k] = v
+			without[k] = v
+		}
+		return mustKey(t, "claim_search", withWallet, ignored) == mustKey(t, "claim_search", without, ignored)
+	}
+	assert.NoError(t, quick.Check(f, nil))
+}
+
+func TestKeyJSONRoundTripProperty(t *testing.T) {
+	f := func(strs map[string]string, nums map[string]float64, ints map[string]int32) bool {
+		params := map[string]interface{}{}
+		for k, v := range strs {
+			params["s"+k] = v
+		}
+		for k, v := range nums {
+			params["n"+k] = v
+		}
+		for k, v := range ints {
+			params["i"+k] = map[string]interface{}{"nested": []interface{}{v}}
+		}
+		s, err := json.Marshal(params)
+		if err != nil {
+			return false
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(s, &decoded); err != nil {
+			return false
+		}
+		return mustKey(t, "claim_search", params, nil) == mustKey(t, "claim_search", decoded, nil)
+	}
+	assert.NoError(t, quick.Check(f, nil))
+}
+
+func TestKeyDistinctProperty(t *testing.T) {
+	f := func(a, b []string) bool {
+		ka := mustKey(t, "resolve", map[string]interface{}{"urls": a}, nil)
+		kb := mustKey(t, "resolve", map[string]interface{}{"urls": b}, nil)
+		return (ka == kb) == sameItems(a, b)
+	}
+	assert.NoError(t, quick.Check(f, nil))
+}
+
+func sameItems(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := map[string]int{}
+	for _, i := range a {
+		counts[i]++
+	}
+	for _, i := range b {
+		counts[i]--
+		if counts[i] < 0 {
+			return false
+		}
+	}
+	return true
+}
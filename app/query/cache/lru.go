@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// lruCache stores the cache in memory, keeping total size of responses within the budget.
+// When the budget is exceeded, least recently used responses are evicted.
+// Responses are stored as JSON and returned as json.RawMessage, their size is the length of JSON.
+type lruCache struct {
+	mu       sync.Mutex
+	maxSize  int64
+	size     int64
+	ttl      time.Duration
+	items    map[string]*list.Element
+	eviction *list.List
+	tags     *tagIndex
+
+	sizeGauge    prometheus.Gauge
+	entriesGauge prometheus.Gauge
+}
+
+type lruEntry struct {
+	key     string
+	value   json.RawMessage
+	size    int64
+	expires time.Time
+}
+
+func (e *lruEntry) expired() bool {
+	return time.Now().After(e.expires)
+}
+
+// NewLRUCache creates a cache holding up to maxSize bytes of responses, each kept for ttl by default.
+// name identifies the cache in metrics.
+func NewLRUCache(name string, maxSize int64, ttl time.Duration) *lruCache {
+	return &lruCache{
+		maxSize:      maxSize,
+		ttl:          ttl,
+		items:        map[string]*list.Element{},
+		eviction:     list.New(),
+		tags:         newTagIndex(),
+		sizeGauge:    metrics.ProxyQueryCacheSize.WithLabelValues(name),
+		entriesGauge: metrics.ProxyQueryCacheEntries.WithLabelValues(name),
+	}
+}
+
+// Save puts a response object into cache, evicting least recently used responses if it doesn't fit.
+// Responses larger than the whole budget are not saved.
+func (s *lruCache) Save(method string, params interface{}, r interface{}, ttl time.Duration, tags ...string) {
+	l := cacheLogger.WithFields(logrus.Fields{"method": method})
+	cacheKey, err := s.getKey(method, params)
+	if err != nil {
+		l.Errorf("unable to produce key for params: %v", params)
+		return
+	}
+	serialized, err := json.Marshal(r)
+	if err != nil {
+		l.Errorf("unable to serialize query result: %v", err)
+		return
+	}
+	size := int64(len(cacheKey) + len(serialized))
+	if size > s.maxSize {
+		l.Warnf("query result of %d bytes exceeds cache size limit", size)
+		return
+	}
+	if ttl == 0 {
+		ttl = s.ttl
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(cacheKey)
+	e := &lruEntry{key: cacheKey, value: serialized, size: size, expires: time.Now().Add(ttl)}
+	s.items[cacheKey] = s.eviction.PushFront(e)
+	s.size += size
+	s.tags.set(cacheKey, tags)
+	for s.size > s.maxSize {
+		s.removeLocked(s.eviction.Back().Value.(*lruEntry).key)
+		metrics.ProxyQueryCacheEvictionCount.Inc()
+	}
+	s.updateMetricsLocked()
+	l.Debug("saved query result")
+}
+
+// Retrieve earlier saved server response by method and query params.
+// Responses are returned as json.RawMessage.
+func (s *lruCache) Retrieve(method string, params interface{}) interface{} {
+	l := cacheLogger.WithFields(logrus.Fields{"method": method})
+	cacheKey, err := s.getKey(method, params)
+	if err != nil {
+		l.Errorf("unable to produce key for params: %v", params)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[cacheKey]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*lruEntry)
+	if e.expired() {
+		s.removeLocked(cacheKey)
+		s.updateMetricsLocked()
+		return nil
+	}
+	s.eviction.MoveToFront(el)
+	l.Debug("query result found in cache")
+	return e.value
+}
+
+func (s *lruCache) getKey(method string, params interface{}) (string, error) {
+	return Key(method, params)
+}
+
+// Invalidate removes the response saved for method and params.
+func (s *lruCache) Invalidate(method string, params interface{}) int {
+	cacheKey, err := s.getKey(method, params)
+	if err != nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.invalidateLocked(cacheKey)
+	s.updateMetricsLocked()
+	return n
+}
+
+// InvalidateMethod removes responses for all methods matching the pattern.
+func (s *lruCache) InvalidateMethod(pattern string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for k := range s.items {
+		if keyMatchesMethod(k, pattern) {
+			n += s.invalidateLocked(k)
+		}
+	}
+	s.updateMetricsLocked()
+	return n
+}
+
+// InvalidateTag removes responses saved with the tag.
+func (s *lruCache) InvalidateTag(tag string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, k := range s.tags.get(tag) {
+		n += s.invalidateLocked(k)
+	}
+	s.updateMetricsLocked()
+	return n
+}
+
+// invalidateLocked removes the entry, returning 1 if it was still valid.
+func (s *lruCache) invalidateLocked(key string) int {
+	el, ok := s.items[key]
+	if !ok {
+		return 0
+	}
+	s.removeLocked(key)
+	if el.Value.(*lruEntry).expired() {
+		return 0
+	}
+	return 1
+}
+
+func (s *lruCache) removeLocked(key string) {
+	el, ok := s.items[key]
+	if !ok {
+		return
+	}
+	s.eviction.Remove(el)
+	delete(s.items, key)
+	s.size -= el.Value.(*lruEntry).size
+	s.tags.remove(key)
+}
+
+func (s *lruCache) updateMetricsLocked() {
+	s.sizeGauge.Set(float64(s.size))
+	s.entriesGauge.Set(float64(len(s.items)))
+}
+
+func (s *lruCache) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = map[string]*list.Element{}
+	s.eviction.Init()
+	s.size = 0
+	s.tags.flush()
+	s.updateMetricsLocked()
+}
+
+// Count returns the total number of items stored in cache.
+// Expired items are only removed when retrieved or evicted, so they are counted until then.
+func (s *lruCache) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// Size returns the total size of responses stored in cache, in bytes.
+func (s *lruCache) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
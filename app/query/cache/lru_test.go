@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// entrySize returns how much space a response takes in lruCache.
+func entrySize(t *testing.T, method string, params, r interface{}) int64 {
+	key, err := Key(method, params)
+	require.NoError(t, err)
+	s, err := json.Marshal(r)
+	require.NoError(t, err)
+	return int64(len(key) + len(s))
+}
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache("test", 1024*1024, time.Minute)
+	params := map[string]interface{}{"urls": "what"}
+	c.Save("resolve", params, map[string]string{"result": "ok"}, 0)
+	assert.Equal(t, json.RawMessage(`{"result":"ok"}`), c.Retrieve("resolve", params))
+	assert.Nil(t, c.Retrieve("resolve", nil))
+	assert.Equal(t, 1, c.Count())
+	assert.Equal(t, entrySize(t, "resolve", params, map[string]string{"result": "ok"}), c.Size())
+
+	c.Save("resolve", params, "replaced", 0)
+	assert.Equal(t, json.RawMessage(`"replaced"`), c.Retrieve("resolve", params))
+	assert.Equal(t, 1, c.Count())
+	assert.Equal(t, entrySize(t, "resolve", params, "replaced"), c.Size())
+	assert.Equal(t, float64(c.Size()), testutil.ToFloat64(metrics.ProxyQueryCacheSize.WithLabelValues("test")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ProxyQueryCacheEntries.WithLabelValues("test")))
+
+	other := NewLRUCache("other", 1024*1024, time.Minute)
+	other.Save("resolve", nil, "other", 0)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ProxyQueryCacheEntries.WithLabelValues("test")), "caches should have their own metrics")
+
+	c.flush()
+	assert.Equal(t, 0, c.Count())
+	assert.EqualValues(t, 0, c.Size())
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	size := entrySize(t, "claim_search", map[string]interface{}{"page": 0}, "result")
+	c := NewLRUCache("test", 3*size, time.Minute)
+	evictions := testutil.ToFloat64(metrics.ProxyQueryCacheEvictionCount)
+
+	for i := 0; i < 3; i++ {
+		c.Save("claim_search", map[string]interface{}{"page": i}, "result", 0)
+	}
+	assert.Equal(t, 3, c.Count())
+
+	// page 0 becomes the most recently used, so page 1 should be evicted first
+	assert.NotNil(t, c.Retrieve("claim_search", map[string]interface{}{"page": 0}))
+	c.Save("claim_search", map[string]interface{}{"page": 3}, "result", 0)
+	assert.Equal(t, 3, c.Count())
+	assert.LessOrEqual(t, c.Size(), 3*size)
+	assert.Nil(t, c.Retrieve("claim_search", map[string]interface{}{"page": 1}))
+	assert.NotNil(t, c.Retrieve("claim_search", map[string]interface{}{"page": 0}))
+	assert.NotNil(t, c.Retrieve("claim_search", map[string]interface{}{"page": 2}))
+	assert.NotNil(t, c.Retrieve("claim_search", map[string]interface{}{"page": 3}))
+	assert.Equal(t, evictions+1, testutil.ToFloat64(metrics.ProxyQueryCacheEvictionCount))
+
+	c.Save("claim_search", map[string]interface{}{"page": 4}, "a much larger result evicting two entries", 0)
+	assert.Equal(t, 2, c.Count())
+	assert.LessOrEqual(t, c.Size(), 3*size)
+	assert.Equal(t, evictions+3, testutil.ToFloat64(metrics.ProxyQueryCacheEvictionCount))
+}
+
+func TestLRUCacheTooLarge(t *testing.T) {
+	c := NewLRUCache("test", 100, time.Minute)
+	c.Save("claim_search", nil, "small", 0, "claim:abc")
+	c.Save("claim_search", map[string]interface{}{"page": 1}, fmt.Sprintf("%0200d", 0), 0)
+	assert.Equal(t, 1, c.Count())
+	assert.NotNil(t, c.Retrieve("claim_search", nil))
+}
+
+func TestLRUCacheExpiration(t *testing.T) {
+	c := NewLRUCache("test", 1024, 50*time.Millisecond)
+	c.Save("resolve", nil, "default ttl", 0)
+	c.Save("claim_search", nil, "longer ttl", time.Minute)
+	assert.Equal(t, 2, c.Count())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, c.Retrieve("resolve", nil))
+	assert.NotNil(t, c.Retrieve("claim_search", nil))
+	assert.Equal(t, 1, c.Count())
+	assert.Equal(t, entrySize(t, "claim_search", nil, "longer ttl"), c.Size())
+}
+
+func TestLRUCacheInvalidation(t *testing.T) {
+	c := NewLRUCache("test", 1024*1024, time.Minute)
+	testInvalidation(t, c)
+	assert.EqualValues(t, 0, c.Size())
+	assert.Empty(t, c.tags.keyTags)
+}
@@ -0,0 +1,323 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/internal/downtime"
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	redisKeyPrefix = "lbrytv:query:"
+	redisTagPrefix = "lbrytv:tag:"
+	// redisRetryAfter is how long the fallback cache is used after a Redis failure
+	// before the Redis backend is tried again.
+	redisRetryAfter = 10 * time.Second
+	redisTimeout    = 500 * time.Millisecond
+	// maxPendingInvalidations is how many invalidations are kept for replay while Redis is unavailable.
+	// Once there are more, the whole Redis cache is flushed on recovery instead.
+	maxPendingInvalidations = 1000
+)
+
+// extendTTLScript sets key expiry to ARGV[1] milliseconds unless the key already lives longer.
+// Tag sets hold keys with different TTLs, so a tag must not expire before the longest-lived of its keys.
+const extendTTLScript = `
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl >= 0 and ttl >= tonumber(ARGV[1]) then
+	return 0
+end
+return redis.call("PEXPIRE", KEYS[1], ARGV[1])
+`
+
+// redisCache stores the cache in Redis so it can be shared by multiple API instances.
+// When Redis is unavailable, it falls back to an in-memory cache.
+type redisCache struct {
+	client   *redis.Client
+	ttl      time.Duration
+	fallback memoryCache
+
+	// health keeps Redis marked as unavailable for redisRetryAfter after it fails
+	health *downtime.Tracker
+
+	// pending holds invalidations that could not reach Redis, they are replayed before Redis is used again
+	pendingMu sync.Mutex
+	pending   []invalidation
+	overflow  bool
+}
+
+// invalidation is a removal of Redis entries by either cache key, key pattern or tag.
+type invalidation struct {
+	key     string
+	pattern string
+	tag     string
+}
+
+// NewRedisCache creates a Redis-backed cache. url should be in the redis://[:password@]host[:port][/db] form.
+// Responses are stored as JSON and expire after ttl unless a different one is given to Save.
+func NewRedisCache(url string, ttl time.Duration) (*redisCache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	opts.DialTimeout = redisTimeout
+	opts.ReadTimeout = redisTimeout
+	opts.WriteTimeout = redisTimeout
+	opts.MaxRetries = 0
+
+	c := &redisCache{
+		client:   redis.NewClient(opts),
+		ttl:      ttl,
+		fallback: NewMemoryCache(),
+		health:   downtime.NewTracker(redisRetryAfter),
+	}
+	if err := c.client.Ping().Err(); err != nil {
+		cacheLogger.Log().Errorf("redis cache at %v is not available, using memory cache until it is: %v", opts.Addr, err)
+		c.health.MarkDown()
+	}
+	return c, nil
+}
+
+// Save puts a response object into cache, making it available for a later retrieval by method and query params
+func (s *redisCache) Save(method string, params interface{}, r interface{}, ttl time.Duration, tags ...string) {
+	l := cacheLogger.WithFields(logrus.Fields{"method": method})
+	cacheKey, err := s.getKey(method, params)
+	if err != nil {
+		l.Errorf("unable to produce key for params: %v", params)
+		return
+	}
+	if !s.up() {
+		metrics.ProxyQueryCacheFallbackCount.WithLabelValues("save").Inc()
+		s.fallback.Save(method, params, r, ttl, tags...)
+		return
+	}
+
+	v, err := json.Marshal(r)
+	if err != nil {
+		l.Errorf("unable to serialize query result: %v", err)
+		return
+	}
+	if ttl == 0 {
+		ttl = s.ttl
+	}
+	pipe := s.client.TxPipeline()
+	pipe.Set(redisKeyPrefix+cacheKey, v, ttl)
+	for _, tag := range tags {
+		pipe.SAdd(redisTagPrefix+tag, redisKeyPrefix+cacheKey)
+		pipe.Eval(extendTTLScript, []string{redisTagPrefix + tag}, ttl.Milliseconds())
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		l.Errorf("unable to save query result to redis: %v", err)
+		s.health.MarkDown()
+		metrics.ProxyQueryCacheFallbackCount.WithLabelValues("save").Inc()
+		s.fallback.Save(method, params, r, ttl, tags...)
+		return
+	}
+	l.Debug("saved query result")
+}
+
+// Retrieve earlier saved server response by method and query params.
+// Responses found in Redis are returned as json.RawMessage.
+func (s *redisCache) Retrieve(method string, params interface{}) interface{} {
+	l := cacheLogger.WithFields(logrus.Fields{"method": method})
+	cacheKey, err := s.getKey(method, params)
+	if err != nil {
+		l.Errorf("unable to produce key for params: %v", params)
+		return nil
+	}
+	if !s.up() {
+		metrics.ProxyQueryCacheFallbackCount.WithLabelValues("retrieve").Inc()
+		return s.fallback.Retrieve(method, params)
+	}
+
+	v, err := s.client.Get(redisKeyPrefix + cacheKey).Bytes()
+	if err == redis.Nil {
+		return nil
+	} else if err != nil {
+		l.Errorf("unable to retrieve query result from redis: %v", err)
+		s.health.MarkDown()
+		metrics.ProxyQueryCacheFallbackCount.WithLabelValues("retrieve").Inc()
+		return s.fallback.Retrieve(method, params)
+	}
+	l.Debug("query result found in cache")
+	return json.RawMessage(v)
+}
+
+// Count returns the total number of non-expired items stored in cache.
+// It scans the whole Redis keyspace so it should not be called often.
+func (s *redisCache) Count() int {
+	if !s.up() {
+		return s.fallback.Count()
+	}
+	var (
+		count  int
+		cursor uint64
+	)
+	for {
+		keys, next, err := s.client.Scan(cursor, redisKeyPrefix+KeyVersion+":*", 1000).Result()
+		if err != nil {
+			cacheLogger.Log().Errorf("unable to count redis keys: %v", err)
+			return s.fallback.Count()
+		}
+		count += len(keys)
+		if next == 0 {
+			return count
+		}
+		cursor = next
+	}
+}
+
+// Invalidate removes the response saved for method and params.
+func (s *redisCache) Invalidate(method string, params interface{}) int {
+	n := s.fallback.Invalidate(method, params)
+	cacheKey, err := s.getKey(method, params)
+	if err != nil {
+		return n
+	}
+	return n + s.invalidate(invalidation{key: redisKeyPrefix + cacheKey})
+}
+
+// InvalidateMethod removes responses for all methods matching the pattern.
+func (s *redisCache) InvalidateMethod(pattern string) int {
+	n := s.fallback.InvalidateMethod(pattern)
+	return n + s.invalidate(invalidation{pattern: redisKeyPrefix + KeyVersion + ":" + pattern + "|*"})
+}
+
+// InvalidateTag removes responses saved with the tag.
+func (s *redisCache) InvalidateTag(tag string) int {
+	n := s.fallback.InvalidateTag(tag)
+	return n + s.invalidate(invalidation{tag: tag})
+}
+
+// invalidate removes entries from Redis, queueing the removal for replay if Redis is not available.
+func (s *redisCache) invalidate(inv invalidation) int {
+	if !s.up() {
+		s.queue(inv)
+		return 0
+	}
+	n, err := s.apply(inv)
+	if err != nil {
+		cacheLogger.Log().Errorf("unable to invalidate redis entries: %v", err)
+		s.health.MarkDown()
+		s.queue(inv)
+	}
+	return n
+}
+
+func (s *redisCache) apply(inv invalidation) (int, error) {
+	switch {
+	case inv.key != "":
+		deleted, err := s.client.Del(inv.key).Result()
+		return int(deleted), err
+	case inv.pattern != "":
+		return s.deleteMatching(inv.pattern)
+	default:
+		return s.deleteTag(inv.tag)
+	}
+}
+
+// queue keeps an invalidation to be replayed once Redis is available again.
+func (s *redisCache) queue(inv invalidation) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.overflow {
+		return
+	}
+	if len(s.pending) >= maxPendingInvalidations {
+		s.pending = nil
+		s.overflow = true
+		return
+	}
+	s.pending = append(s.pending, inv)
+}
+
+// up returns true if Redis can be used. Invalidations queued while Redis was unavailable
+// are replayed first, so entries invalidated during an outage are never served after it.
+func (s *redisCache) up() bool {
+	if !s.health.IsUp() {
+		return false
+	}
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.overflow {
+		if err := s.flushRedis(); err != nil {
+			cacheLogger.Log().Errorf("unable to flush redis cache after an outage: %v", err)
+			s.health.MarkDown()
+			return false
+		}
+		cacheLogger.Log().Info("flushed redis cache after too many invalidations during an outage")
+		s.overflow = false
+	}
+	for len(s.pending) > 0 {
+		if _, err := s.apply(s.pending[0]); err != nil {
+			cacheLogger.Log().Errorf("unable to replay redis invalidations: %v", err)
+			s.health.MarkDown()
+			return false
+		}
+		s.pending = s.pending[1:]
+	}
+	return true
+}
+
+// deleteTag deletes the keys saved with the tag along with the tag set itself.
+// It returns the number of deleted cache entries.
+func (s *redisCache) deleteTag(tag string) (int, error) {
+	keys, err := s.client.SMembers(redisTagPrefix + tag).Result()
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	deleted, err := s.client.Del(append(keys, redisTagPrefix+tag)...).Result()
+	if err != nil {
+		return 0, err
+	}
+	// the tag set itself is not a cache entry
+	return int(deleted) - 1, nil
+}
+
+// deleteMatching deletes all keys matching the pattern and returns the number of deleted keys.
+func (s *redisCache) deleteMatching(pattern string) (int, error) {
+	var (
+		n      int
+		cursor uint64
+	)
+	for {
+		keys, next, err := s.client.Scan(cursor, pattern, 1000).Result()
+		if err != nil {
+			return n, err
+		}
+		if len(keys) > 0 {
+			deleted, err := s.client.Del(keys...).Result()
+			if err != nil {
+				return n, err
+			}
+			n += int(deleted)
+		}
+		if next == 0 {
+			return n, nil
+		}
+		cursor = next
+	}
+}
+
+func (s *redisCache) getKey(method string, params interface{}) (string, error) {
+	return Key(method, params)
+}
+
+func (s *redisCache) flush() {
+	s.fallback.flush()
+	s.flushRedis()
+}
+
+// flushRedis deletes all cache entries and tags from Redis.
+func (s *redisCache) flushRedis() error {
+	if _, err := s.deleteMatching(redisKeyPrefix + "*"); err != nil {
+		return err
+	}
+	_, err := s.deleteMatching(redisTagPrefix + "*")
+	return err
+}
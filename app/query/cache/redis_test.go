@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/internal/downtime"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func TestRedisCache(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := NewRedisCache("redis://"+srv.Addr(), 5*time.Minute)
+	require.NoError(t, err)
+
+	params := map[string]interface{}{"urls": []interface{}{"one", "two", "three"}}
+	response := &jsonrpc.RPCResponse{JSONRPC: "2.0", ID: 1, Result: map[string]interface{}{"one": "claim"}}
+
+	assert.Nil(t, c.Retrieve("resolve", params))
+	c.Save("resolve", params, response, 0)
+	assert.Equal(t, 1, c.Count())
+	assert.Equal(t, 0, c.fallback.Count())
+
+	cached := c.Retrieve("resolve", params)
+	require.IsType(t, json.RawMessage{}, cached)
+	var r jsonrpc.RPCResponse
+	require.NoError(t, json.Unmarshal(cached.(json.RawMessage), &r))
+	assert.Equal(t, response.Result, r.Result)
+
+	key, err := c.getKey("resolve", params)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, srv.TTL(redisKeyPrefix+key))
+
+	srv.FastForward(6 * time.Minute)
+	assert.Nil(t, c.Retrieve("resolve", params))
+}
+
+func TestRedisCacheShared(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c1, err := NewRedisCache("redis://"+srv.Addr(), time.Minute)
+	require.NoError(t, err)
+	c2, err := NewRedisCache("redis://"+srv.Addr(), time.Minute)
+	require.NoError(t, err)
+
+	params := map[string]interface{}{"page_size": 20}
+	c1.Save("claim_search", params, &jsonrpc.RPCResponse{Result: []interface{}{"a"}}, 0)
+	assert.NotNil(t, c2.Retrieve("claim_search", params))
+
+	c2.flush()
+	assert.Equal(t, 0, c1.Count())
+}
+
+func TestRedisCacheFallback(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+
+	c, err := NewRedisCache("redis://"+srv.Addr(), time.Minute)
+	require.NoError(t, err)
+	srv.Close()
+
+	params := map[string]interface{}{"page_size": 20}
+	response := &jsonrpc.RPCResponse{Result: []interface{}{"a"}}
+	c.Save("claim_search", params, response, 0)
+	assert.False(t, c.health.IsUp())
+	assert.Equal(t, 1, c.fallback.Count())
+	assert.Equal(t, response, c.Retrieve("claim_search", params))
+	assert.Equal(t, 1, c.Count())
+}
+
+func TestRedisCacheUnavailableOnStart(t *testing.T) {
+	c, err := NewRedisCache("redis://127.0.0.1:1", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, c.health.IsUp())
+
+	c.Save("claim_search", nil, "result", 0)
+	assert.Equal(t, "result", c.Retrieve("claim_search", nil))
+
+	_, err = NewRedisCache("http://wrong", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	c, err := New(BackendMemory, "", 0, 0)
+	require.NoError(t, err)
+	assert.IsType(t, memoryCache{}, c)
+
+	c, err = New(BackendLRU, "", time.Minute, 1024)
+	require.NoError(t, err)
+	assert.IsType(t, &lruCache{}, c)
+
+	_, err = New(BackendLRU, "", time.Minute, 0)
+	assert.Error(t, err)
+
+	_, err = New("memcached", "", 0, 0)
+	assert.EqualError(t, err, "unknown query cache backend: memcached")
+}
+
+func TestRedisCacheInvalidation(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := NewRedisCache("redis://"+srv.Addr(), time.Minute)
+	require.NoError(t, err)
+	testInvalidation(t, c)
+	assert.False(t, srv.Exists(redisTagPrefix+"claim:one"))
+}
+
+func TestRedisCacheTagTTL(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := NewRedisCache("redis://"+srv.Addr(), time.Minute)
+	require.NoError(t, err)
+
+	c.Save("resolve", map[string]interface{}{"urls": "one"}, "long", time.Hour, "claim:one")
+	c.Save("resolve", map[string]interface{}{"urls": "two"}, "short", time.Minute, "claim:one")
+	assert.Equal(t, time.Hour, srv.TTL(redisTagPrefix+"claim:one"), "tag ttl should not be shortened")
+
+	c.Save("resolve", map[string]interface{}{"urls": "three"}, "longer", 2*time.Hour, "claim:one")
+	assert.Equal(t, 2*time.Hour, srv.TTL(redisTagPrefix+"claim:one"), "tag ttl should be extended")
+}
+
+func TestRedisCacheInvalidationFallback(t *testing.T) {
+	c, err := NewRedisCache("redis://127.0.0.1:1", time.Minute)
+	require.NoError(t, err)
+	testInvalidation(t, c)
+}
+
+func TestRedisCacheInvalidationDuringOutage(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := NewRedisCache("redis://"+srv.Addr(), time.Minute)
+	require.NoError(t, err)
+	c.health = downtime.NewTracker(10 * time.Millisecond)
+
+	resolveParams := map[string]interface{}{"urls": "one"}
+	searchParams := map[string]interface{}{"channel_ids": "abc"}
+	c.Save("resolve", resolveParams, "resolved", 0, "claim:one")
+	c.Save("claim_search", searchParams, "found", 0)
+	c.Save("claim_list", nil, "listed", 0)
+	c.Save("wallet_balance", nil, "balance", 0)
+
+	srv.Close()
+	assert.Nil(t, c.Retrieve("claim_list", nil))
+	require.False(t, c.health.IsUp())
+	c.InvalidateTag("claim:one")
+	c.InvalidateMethod("claim_s*")
+	c.Invalidate("claim_list", nil)
+	assert.Len(t, c.pending, 3)
+
+	require.NoError(t, srv.Restart())
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, c.Retrieve("resolve", resolveParams))
+	assert.Nil(t, c.Retrieve("claim_search", searchParams))
+	assert.Nil(t, c.Retrieve("claim_list", nil))
+	assert.NotNil(t, c.Retrieve("wallet_balance", nil))
+	assert.Empty(t, c.pending)
+}
+
+func TestRedisCacheInvalidationOverflow(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := NewRedisCache("redis://"+srv.Addr(), time.Minute)
+	require.NoError(t, err)
+	c.health = downtime.NewTracker(10 * time.Millisecond)
+	c.Save("resolve", nil, "resolved", 0, "claim:one")
+	c.Save("claim_list", nil, "listed", 0)
+
+	c.health.MarkDown()
+	for i := 0; i <= maxPendingInvalidations; i++ {
+		c.InvalidateTag("claim:one")
+	}
+	assert.True(t, c.overflow)
+	assert.Empty(t, c.pending)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, c.Retrieve("claim_list", nil), "redis cache should be flushed")
+	assert.Equal(t, 0, c.Count())
+	assert.False(t, c.overflow)
+}
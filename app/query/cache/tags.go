@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// tagIndex keeps track of cache keys saved with each tag.
+type tagIndex struct {
+	mu      sync.Mutex
+	keys    map[string]map[string]struct{}
+	keyTags map[string][]string
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{keys: map[string]map[string]struct{}{}, keyTags: map[string][]string{}}
+}
+
+// set replaces tags of the key.
+func (t *tagIndex) set(key string, tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removeLocked(key)
+	if len(tags) == 0 {
+		return
+	}
+	for _, tag := range tags {
+		if t.keys[tag] == nil {
+			t.keys[tag] = map[string]struct{}{}
+		}
+		t.keys[tag][key] = struct{}{}
+	}
+	t.keyTags[key] = tags
+}
+
+func (t *tagIndex) remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removeLocked(key)
+}
+
+func (t *tagIndex) removeLocked(key string) {
+	for _, tag := range t.keyTags[key] {
+		delete(t.keys[tag], key)
+		if len(t.keys[tag]) == 0 {
+			delete(t.keys, tag)
+		}
+	}
+	delete(t.keyTags, key)
+}
+
+// get returns keys saved with the tag.
+func (t *tagIndex) get(tag string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]string, 0, len(t.keys[tag]))
+	for k := range t.keys[tag] {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (t *tagIndex) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys = map[string]map[string]struct{}{}
+	t.keyTags = map[string][]string{}
+}
+
+// keyMatchesMethod returns true if the cache key was produced for a method matching the pattern.
+func keyMatchesMethod(key, pattern string) bool {
+	method := strings.TrimPrefix(key, KeyVersion+":")
+	if i := strings.Index(method, "|"); i >= 0 {
+		method = method[:i]
+	}
+	ok, _ := path.Match(pattern, method)
+	return ok
+}
@@ -0,0 +1,124 @@
+package query
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+)
+
+const defaultCacheTTL = 5 * time.Minute
+
+// CacheRule describes a kind of queries that can be cached.
+type CacheRule struct {
+	// Name identifies the rule in metrics
+	Name   string
+	Method string
+	// TTL is for how long a cached response is fresh
+	TTL time.Duration
+	// StaleTTL is for how long after TTL a stale response can be served.
+	// Stale responses are refreshed in the background.
+	StaleTTL time.Duration
+	// MoreItemsThan requires list params to contain more than the given number of items,
+	// e.g. {"urls": 10} only caches resolve calls for more than 10 urls.
+	MoreItemsThan map[string]int
+	// WithoutParams prevents queries containing any of these params from being cached,
+	// e.g. ["wallet_id"] only caches queries made by anonymous users.
+	WithoutParams []string
+}
+
+// CachePolicy is a list of rules defining which queries are cached.
+// Rules are checked in order, the first one that matches a query is applied to it.
+type CachePolicy []CacheRule
+
+var (
+	defaultCachePolicy = CachePolicy{
+		{Name: MethodResolve, Method: MethodResolve, TTL: defaultCacheTTL, MoreItemsThan: map[string]int{ParamUrls: 10}},
+		{Name: MethodClaimSearch, Method: MethodClaimSearch, TTL: defaultCacheTTL},
+	}
+
+	configuredCachePolicy     CachePolicy
+	configuredCachePolicyOnce sync.Once
+)
+
+// NewCachePolicy converts caching rules from the config into a CachePolicy.
+func NewCachePolicy(rules []config.QueryCacheRule) (CachePolicy, error) {
+	p := make(CachePolicy, len(rules))
+	for i, r := range rules {
+		if r.Method == "" {
+			return nil, errors.Err("cache rule #%d has no method", i)
+		}
+		if r.TTL < 0 || r.StaleTTL < 0 {
+			return nil, errors.Err("cache rule #%d has negative ttl", i)
+		}
+		p[i] = CacheRule{
+			Name:          r.Name,
+			Method:        r.Method,
+			TTL:           time.Duration(r.TTL) * time.Second,
+			StaleTTL:      time.Duration(r.StaleTTL) * time.Second,
+			MoreItemsThan: r.MoreItemsThan,
+			WithoutParams: r.WithoutParams,
+		}
+		if p[i].Name == "" {
+			p[i].Name = r.Method
+		}
+		if p[i].TTL == 0 {
+			p[i].TTL = defaultCacheTTL
+		}
+	}
+	return p, nil
+}
+
+// ConfiguredCachePolicy returns the cache policy set by QueryCachePolicy in the config.
+// If it's not set or is invalid, the default policy is returned.
+func ConfiguredCachePolicy() CachePolicy {
+	configuredCachePolicyOnce.Do(func() {
+		configuredCachePolicy = defaultCachePolicy
+		rules, err := config.GetQueryCachePolicy()
+		if err != nil {
+			logger.Log().Errorf("cannot read query cache policy, using the default one: %v", err)
+			return
+		}
+		if len(rules) == 0 {
+			return
+		}
+		p, err := NewCachePolicy(rules)
+		if err != nil {
+			logger.Log().Errorf("invalid query cache policy, using the default one: %v", err)
+			return
+		}
+		configuredCachePolicy = p
+	})
+	return configuredCachePolicy
+}
+
+// Match returns the rule applying to the query, nil if the query should not be cached.
+func (p CachePolicy) Match(q *Query) *CacheRule {
+	for i := range p {
+		if p[i].Matches(q) {
+			return &p[i]
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the rule applies to the query.
+func (r CacheRule) Matches(q *Query) bool {
+	if q.Method() != r.Method {
+		return false
+	}
+	params := q.ParamsAsMap()
+	for _, p := range r.WithoutParams {
+		if _, ok := params[p]; ok {
+			return false
+		}
+	}
+	for p, n := range r.MoreItemsThan {
+		items, _ := params[p].([]interface{})
+		if len(items) <= n {
+			return false
+		}
+	}
+	return true
+}
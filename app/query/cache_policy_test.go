@@ -0,0 +1,116 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func newTestQuery(t *testing.T, method string, params map[string]interface{}, walletID string) *Query {
+	q, err := NewQuery(jsonrpc.NewRequest(method, params), walletID)
+	require.NoError(t, err)
+	return q
+}
+
+func urls(n int) []interface{} {
+	u := make([]interface{}, n)
+	for i := range u {
+		u[i] = "lbry://one"
+	}
+	return u
+}
+
+func TestDefaultCachePolicy(t *testing.T) {
+	p := defaultCachePolicy
+
+	assert.Nil(t, p.Match(newTestQuery(t, MethodResolve, map[string]interface{}{"urls": urls(10)}, "")))
+	assert.Nil(t, p.Match(newTestQuery(t, MethodResolve, map[string]interface{}{"urls": "lbry://one"}, "")))
+	r := p.Match(newTestQuery(t, MethodResolve, map[string]interface{}{"urls": urls(11)}, ""))
+	require.NotNil(t, r)
+	assert.Equal(t, MethodResolve, r.Name)
+	assert.Equal(t, 5*time.Minute, r.TTL)
+
+	r = p.Match(newTestQuery(t, MethodClaimSearch, map[string]interface{}{"channel": "@one"}, "lbrytv-id.1.wallet"))
+	require.NotNil(t, r)
+	assert.Equal(t, MethodClaimSearch, r.Name)
+
+	assert.Nil(t, p.Match(newTestQuery(t, MethodWalletBalance, nil, "lbrytv-id.1.wallet")))
+}
+
+func TestNewCachePolicy(t *testing.T) {
+	p, err := NewCachePolicy([]config.QueryCacheRule{
+		{Name: "claim_search_anonymous", Method: MethodClaimSearch, TTL: 60, WithoutParams: []string{ParamWalletID}},
+		{Method: MethodResolve, MoreItemsThan: map[string]int{ParamUrls: 2}},
+		{Method: "comment_list", TTL: 10},
+	})
+	require.NoError(t, err)
+
+	r := p.Match(newTestQuery(t, MethodClaimSearch, map[string]interface{}{"channel": "@one"}, ""))
+	require.NotNil(t, r)
+	assert.Equal(t, "claim_search_anonymous", r.Name)
+	assert.Equal(t, time.Minute, r.TTL)
+	assert.Nil(t, p.Match(newTestQuery(t, MethodClaimSearch, map[string]interface{}{"channel": "@one"}, "lbrytv-id.1.wallet")),
+		"queries with wallet_id should not be cached")
+
+	r = p.Match(newTestQuery(t, MethodResolve, map[string]interface{}{"urls": urls(3)}, ""))
+	require.NotNil(t, r)
+	assert.Equal(t, MethodResolve, r.Name)
+	assert.Equal(t, defaultCacheTTL, r.TTL)
+	assert.Nil(t, p.Match(newTestQuery(t, MethodResolve, map[string]interface{}{"urls": urls(2)}, "")))
+
+	r = p.Match(newTestQuery(t, "comment_list", map[string]interface{}{"claim_id": "abc"}, ""))
+	require.NotNil(t, r)
+	assert.Equal(t, 10*time.Second, r.TTL)
+
+	_, err = NewCachePolicy([]config.QueryCacheRule{{Name: "nomethod"}})
+	assert.EqualError(t, err, "cache rule #0 has no method")
+	_, err = NewCachePolicy([]config.QueryCacheRule{{Method: MethodResolve, TTL: -1}})
+	assert.EqualError(t, err, "cache rule #0 has negative ttl")
+}
+
+func TestCaller_CachePolicy(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	srv.QueueResponses(
+		`{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`,
+		`{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`,
+	)
+
+	qCache := cache.NewMemoryCache()
+	policy, err := NewCachePolicy([]config.QueryCacheRule{{Method: "comment_list", TTL: 60}})
+	require.NoError(t, err)
+
+	c := NewCaller(srv.URL, 0)
+	c.Cache = qCache
+	c.CachePolicy = policy
+
+	_, err = c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@one"}))
+	require.NoError(t, err)
+	assert.Equal(t, 0, qCache.Count(), "claim_search is not in the policy")
+
+	_, err = c.Call(jsonrpc.NewRequest("comment_list", map[string]interface{}{"claim_id": "abc"}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, qCache.Count())
+	assert.NotNil(t, qCache.Retrieve("comment_list", map[string]interface{}{"claim_id": "abc"}))
+}
+
+func TestCaller_DontCacheErrors(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "error": {"code": -32500, "message": "something went wrong"}}`
+
+	qCache := cache.NewMemoryCache()
+	c := NewCaller(srv.URL, 0)
+	c.Cache = qCache
+	res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@one"}))
+	require.NoError(t, err)
+	require.NotNil(t, res.Error)
+	assert.Equal(t, 0, qCache.Count())
+}
@@ -1,7 +1,7 @@
 package query
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -48,6 +48,16 @@ type HookContext struct {
 	Query    *Query
 	Response *jsonrpc.RPCResponse
 	logEntry *logrus.Entry
+	ctx      context.Context
+}
+
+// Context returns the context of the query being performed.
+// Hooks making queries of their own should pass it on so those are cancelled along with the original query.
+func (hc *HookContext) Context() context.Context {
+	if hc.ctx == nil {
+		return context.Background()
+	}
+	return hc.ctx
 }
 
 // AddLogField injects additional data into default post-query log entry
@@ -57,9 +67,26 @@ func (hc *HookContext) AddLogField(key string, value interface{}) {
 	}
 }
 
+// CallerOptions are optional query processing features, shared by all callers made for incoming requests.
+// Features that are not set are disabled.
+type CallerOptions struct {
+	// Hedging sends slow queries to another server as well, see Caller.AlternativeEndpoint
+	Hedging *Hedging
+	// Failover resends queries to other servers when sending them fails, see Caller.AlternativeEndpoint
+	Failover *Failover
+	// StreamCache keeps stream metadata and purchase receipts for `get` queries
+	StreamCache *StreamCache
+	// StreamURLBuilder makes URLs returned by `get`, the player layout under BaseContentURL is used if not set
+	StreamURLBuilder StreamURLBuilder
+	// Spending enforces spending limits on purchases and sends made for the user
+	Spending SpendingGuard
+}
+
 // Caller patches through JSON-RPC requests from clients, doing pre/post-processing,
 // account processing and validation.
 type Caller struct {
+	CallerOptions
+
 	// Preprocessor is applied to query before it's sent to the SDK.
 	Preprocessor    func(q *Query)
 	preflightHooks  []hookEntry
@@ -67,32 +94,43 @@ type Caller struct {
 
 	// Cache stores cachable queries to improve performance
 	Cache cache.QueryCache
+	// CachePolicy defines which queries are cached and for how long
+	CachePolicy CachePolicy
 
 	Duration float64
 
-	client   jsonrpc.RPCClient
-	userID   int
-	endpoint string
+	// AlternativeEndpoint returns an endpoint other than the excluded ones that queries made without a wallet
+	// can be sent to, or an empty string if there is none.
+	// Queries are only hedged and failed over when it is set, see Hedging and Failover.
+	AlternativeEndpoint func(exclude ...string) string
+
+	// Router limits the number of queries in flight to the endpoint and fails them fast while its circuit breaker
+	// is open, see sdkrouter.Router.AcquireSlot and AllowCall. Queries are not limited when it's not set.
+	Router *sdkrouter.Router
+
+	// CheckWalletReload is called before a wallet that is not loaded on the SDK is loaded automatically.
+	// If it returns an error, the wallet is not loaded and the query fails with it, e.g. if the user is being
+	// moved to another server. Wallets are always loaded when it's not set.
+	CheckWalletReload func(endpoint string, userID int) error
+
+	transport http.RoundTripper
+	userID    int
+	endpoint  string
 }
 
 func NewCaller(endpoint string, userID int) *Caller {
 	c := &Caller{
-		client: jsonrpc.NewClientWithOpts(endpoint, &jsonrpc.RPCClientOpts{
-			HTTPClient: &http.Client{
-				Timeout: sdkrouter.RPCTimeout,
-				Transport: &http.Transport{
-					Dial: (&net.Dialer{
-						Timeout:   120 * time.Second,
-						KeepAlive: 120 * time.Second,
-					}).Dial,
-					TLSHandshakeTimeout:   30 * time.Second,
-					ResponseHeaderTimeout: 600 * time.Second,
-					ExpectContinueTimeout: 1 * time.Second,
-				},
-			},
-		}),
-		endpoint: endpoint,
-		userID:   userID,
+		transport: &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout:   120 * time.Second,
+				KeepAlive: 120 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout:   30 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		endpoint:    endpoint,
+		userID:      userID,
+		CachePolicy: ConfiguredCachePolicy(),
 	}
 	c.addDefaultHooks()
 	return c
@@ -119,10 +157,20 @@ func (c *Caller) addDefaultHooks() {
 	c.AddPreflightHook("", fromCache, builtinHookName)
 	c.AddPreflightHook("status", getStatusResponse, builtinHookName)
 	c.AddPreflightHook("get", preflightHookGet, builtinHookName)
+	c.AddPreflightHook(MethodPurchaseCreate, preflightHookPurchaseCreate, builtinHookName)
+	c.AddPreflightHook(MethodWalletSend, preflightHookSend, builtinHookName)
+	c.AddPreflightHook(MethodAccountSend, preflightHookSend, builtinHookName)
+	for _, m := range invalidatingMethods {
+		c.AddPostflightHook(m, invalidateCache, builtinHookName)
+		c.AddPostflightHook(m, invalidateStreams, builtinHookName)
+	}
 }
 
 func (c *Caller) CloneWithoutHook(endpoint, method, name string) *Caller {
 	cc := NewCaller(endpoint, c.userID)
+	cc.CachePolicy = c.CachePolicy
+	cc.CallerOptions = c.CallerOptions
+	cc.Router = c.Router
 	for _, h := range c.postflightHooks {
 		if h.method == method && h.name == name {
 			continue
@@ -138,13 +186,34 @@ func (c *Caller) CloneWithoutHook(endpoint, method, name string) *Caller {
 	return cc
 }
 
+// clone returns a copy of the caller, so a query can be sent by it in another goroutine
+// without sharing fields written while sending, such as Duration.
+func (c *Caller) clone() *Caller {
+	cc := *c
+	return &cc
+}
+
 func (c *Caller) Endpoint() string {
 	return c.endpoint
 }
 
+// client returns a JSON-RPC client for the endpoint which aborts its calls once ctx is done.
+func (c *Caller) client(ctx context.Context) jsonrpc.RPCClient {
+	return jsonrpc.NewClientWithOpts(c.endpoint, &jsonrpc.RPCClientOpts{
+		// Queries are timed out by their context, see MethodTimeout
+		HTTPClient: &http.Client{Transport: contextTransport{ctx: ctx, base: c.transport}},
+	})
+}
+
 // Call method forwards a JSON-RPC request to the lbrynet server.
 // It returns a response that is ready to be sent back to the JSON-RPC client as is.
 func (c *Caller) Call(req *jsonrpc.RPCRequest) (*jsonrpc.RPCResponse, error) {
+	return c.CallContext(context.Background(), req)
+}
+
+// CallContext is Call which gives up on the query once ctx is done,
+// e.g. when the client that made the request has disconnected.
+func (c *Caller) CallContext(ctx context.Context, req *jsonrpc.RPCRequest) (*jsonrpc.RPCResponse, error) {
 	if c.endpoint == "" {
 		return nil, errors.Err("cannot call blank endpoint")
 	}
@@ -163,9 +232,9 @@ func (c *Caller) Call(req *jsonrpc.RPCRequest) (*jsonrpc.RPCResponse, error) {
 	var res *jsonrpc.RPCResponse
 	for _, hook := range c.preflightHooks {
 		if isMatchingHook(q.Method(), hook) {
-			res, err = hook.function(c, &HookContext{Query: q})
+			res, err = hook.function(c, &HookContext{Query: q, ctx: ctx})
 			if err != nil {
-				return nil, rpcerrors.NewSDKError(err)
+				return nil, sdkError(err)
 			}
 			if res != nil {
 				return res, nil
@@ -173,49 +242,121 @@ func (c *Caller) Call(req *jsonrpc.RPCRequest) (*jsonrpc.RPCResponse, error) {
 		}
 	}
 
-	if res == nil {
-		res, err = c.SendQuery(q)
+	rule := c.CachePolicy.Match(q)
+	if rule == nil {
+		res, err = c.SendQueryContext(ctx, q)
 		if err != nil {
-			return nil, rpcerrors.NewSDKError(err)
+			return nil, sdkError(err)
 		}
+		return res, nil
 	}
 
-	if isCacheable(q) {
-		c.Cache.Save(q.Method(), q.Params(), res)
+	// Identical cacheable queries are only sent to the SDK once and their response is shared
+	res, err = c.sendCoalesced(ctx, q, rule)
+	if err != nil {
+		return nil, sdkError(err)
 	}
 
 	return res, nil
 }
 
+// SendQuery sends the query to the SDK, bypassing preflight hooks.
 func (c *Caller) SendQuery(q *Query) (*jsonrpc.RPCResponse, error) {
+	return c.SendQueryContext(context.Background(), q)
+}
+
+// SendQueryContext is SendQuery which aborts the SDK call once ctx is done.
+func (c *Caller) SendQueryContext(ctx context.Context, q *Query) (*jsonrpc.RPCResponse, error) {
+	send := c.sendQuery
+	if c.shouldHedge(q) {
+		send = c.sendHedged
+	}
+	res, err := send(ctx, q)
+	if err != nil && c.canFailover(q, err) {
+		return c.failover(ctx, q, err)
+	}
+	return res, err
+}
+
+// sendQuery sends the query to the endpoint and applies postflight hooks to the response.
+func (c *Caller) sendQuery(ctx context.Context, q *Query) (*jsonrpc.RPCResponse, error) {
+	r, err := c.callSDK(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return c.postflight(ctx, q, r)
+}
+
+// callSDK sends the query to the endpoint, reloading the user wallet if the SDK doesn't have it loaded.
+func (c *Caller) callSDK(ctx context.Context, q *Query) (*jsonrpc.RPCResponse, error) {
 	var (
-		r   *jsonrpc.RPCResponse
-		err error
+		r       *jsonrpc.RPCResponse
+		err     error
+		latency time.Duration
 	)
 	op := metrics.StartOperation("sdk", "send_query")
 	defer op.End()
 
+	call, release, err := c.admit(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := MethodTimeout(q.Method())
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	client := c.client(callCtx)
+	// stop gives up on the query once callCtx is done
+	stop := func() error {
+		release()
+		if ctx.Err() == nil {
+			// The SDK taking too long counts against it, the client going away doesn't
+			call.Done(latency, context.DeadlineExceeded)
+			return c.timedOut(q, timeout)
+		}
+		call.Cancel()
+		return c.abandon(ctx, q)
+	}
+
 	for i := 0; i < walletLoadRetries; i++ {
 		start := time.Now()
 
-		r, err = c.client.CallRaw(q.Request)
+		r, err = client.CallRaw(q.Request)
 
-		c.Duration = time.Since(start).Seconds()
+		latency = time.Since(start)
+		c.Duration = latency.Seconds()
 		metrics.ProxyCallDurations.WithLabelValues(q.Method(), c.endpoint).Observe(c.Duration)
 		metrics.ProxyCallCounter.WithLabelValues(q.Method(), c.endpoint).Inc()
 
+		if err != nil && callCtx.Err() != nil {
+			return nil, stop()
+		}
+
 		// Generally a HTTP transport failure (connect error etc)
 		if err != nil {
+			release()
+			call.Done(latency, err)
 			logger.Log().Errorf("error sending query to %v: %v", c.endpoint, err)
 			metrics.ProxyCallFailedDurations.WithLabelValues(q.Method(), c.endpoint, metrics.FailureKindNet).Observe(c.Duration)
 			metrics.ProxyCallFailedCounter.WithLabelValues(q.Method(), c.endpoint, metrics.FailureKindNet).Inc()
-			return nil, errors.Err(err)
+			return nil, errors.Err(transportError{err})
 		}
 
 		// This checks if LbrynetServer responded with missing wallet error and tries to reload it,
 		// then repeats the request again
 		if isErrWalletNotLoaded(r) {
-			time.Sleep(walletLoadRetryWait)
+			if c.CheckWalletReload != nil {
+				if err := c.CheckWalletReload(c.endpoint, c.userID); err != nil {
+					release()
+					call.Done(latency, nil)
+					logger.WithFields(logrus.Fields{"user_id": c.userID, "endpoint": c.endpoint}).
+						Warnf("not reloading wallet: %v", err)
+					return nil, rpcerrors.NewServerBusyError(err)
+				}
+			}
+			if err := sleepContext(callCtx, walletLoadRetryWait); err != nil {
+				return nil, stop()
+			}
 			// Using LBRY JSON-RPC client here for easier request/response processing
 			err := wallet.LoadWallet(c.endpoint, c.userID)
 			// Alert sentry on the last failed wallet load attempt
@@ -237,7 +378,15 @@ func (c *Caller) SendQuery(q *Query) (*jsonrpc.RPCResponse, error) {
 			break
 		}
 	}
+	// Postflight hooks can send queries of their own so the slot is freed before they are run
+	release()
+	call.Done(latency, nil)
+	return r, nil
+}
 
+// postflight applies postflight hooks to the SDK response and logs the query.
+func (c *Caller) postflight(ctx context.Context, q *Query, r *jsonrpc.RPCResponse) (*jsonrpc.RPCResponse, error) {
+	var err error
 	logFields := logrus.Fields{
 		"method":   q.Method(),
 		"params":   q.Params(),
@@ -249,7 +398,7 @@ func (c *Caller) SendQuery(q *Query) (*jsonrpc.RPCResponse, error) {
 
 	// Applying postflight hooks
 	var hookResp *jsonrpc.RPCResponse
-	hctx := &HookContext{Query: q, Response: r, logEntry: logEntry}
+	hctx := &HookContext{Query: q, Response: r, logEntry: logEntry, ctx: ctx}
 	for _, hook := range c.postflightHooks {
 		if isMatchingHook(q.Method(), hook) {
 			hookResp, err = hook.function(c, hctx)
@@ -277,19 +426,56 @@ func (c *Caller) SendQuery(q *Query) (*jsonrpc.RPCResponse, error) {
 	return r, err
 }
 
-// isCacheable returns true if this query can be cached
-func isCacheable(q *Query) bool {
-	if q.Method() == MethodResolve && q.Params() != nil {
-		paramsMap := q.Params().(map[string]interface{})
-		if urls, ok := paramsMap[ParamUrls].([]interface{}); ok {
-			if len(urls) > cacheResolveLongerThan {
-				return true
-			}
+// admit lets the query through the circuit breaker of the endpoint and takes an in-flight slot of it.
+// Both the call outcome and the slot should be reported back once the query is done.
+func (c *Caller) admit(ctx context.Context, q *Query) (sdkrouter.BreakerCall, func(), error) {
+	if c.Router == nil {
+		return sdkrouter.BreakerCall{}, func() {}, nil
+	}
+	call, err := c.Router.AllowCall(c.endpoint)
+	if err != nil {
+		logger.Log().Warnf("not sending %v query to %v: %v", q.Method(), c.endpoint, err)
+		return call, nil, rpcerrors.NewCircuitOpenError(err)
+	}
+	release, err := c.Router.AcquireSlot(ctx, c.endpoint)
+	if err != nil {
+		call.Cancel()
+		if ctx.Err() != nil {
+			return call, nil, c.abandon(ctx, q)
 		}
-	} else if q.Method() == MethodClaimSearch {
-		return true
+		logger.Log().Warnf("not sending %v query to %v: %v", q.Method(), c.endpoint, err)
+		return call, nil, rpcerrors.NewServerBusyError(err)
 	}
-	return false
+	return call, release, nil
+}
+
+// abandon records a query given up on because its context is done.
+// Hedged queries cancelled because the other query has responded first are not counted as failures.
+func (c *Caller) abandon(ctx context.Context, q *Query) error {
+	ctxErr := ctx.Err()
+	if a, ok := ctx.Value(hedgeAttemptKey).(*hedgeAttempt); ok && a.isLost() {
+		logger.Log().Debugf("%v query to %v lost to the hedged one", q.Method(), c.endpoint)
+		return errors.Err(ctxErr)
+	}
+	kind := FailureKind(ctxErr)
+	logger.WithFields(logrus.Fields{
+		"method":   q.Method(),
+		"endpoint": c.endpoint,
+		"user_id":  c.userID,
+		"kind":     kind,
+	}).Infof("query abandoned: %v", ctxErr)
+	metrics.ProxyCallFailedDurations.WithLabelValues(q.Method(), c.endpoint, kind).Observe(c.Duration)
+	metrics.ProxyCallFailedCounter.WithLabelValues(q.Method(), c.endpoint, kind).Inc()
+	return errors.Err(ctxErr)
+}
+
+// sdkError wraps err into an SDK error unless it already is an RPC error with a more specific code.
+func sdkError(err error) error {
+	var rpcErr rpcerrors.RPCError
+	if errors.As(err, &rpcErr) {
+		return err
+	}
+	return rpcerrors.NewSDKError(err)
 }
 
 func getLogLevel(m string) logrus.Level {
@@ -303,36 +489,39 @@ func isMatchingHook(m string, hook hookEntry) bool {
 	return hook.method == "" || hook.method == m || strings.HasPrefix(m, hook.method)
 }
 
-// fromCache returns cached response or nil in case it's a miss
+// fromCache returns cached response or nil in case it's a miss.
+// Stale responses are returned as well but get refreshed in the background.
 func fromCache(c *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
-	if c.Cache == nil || !isCacheable(hctx.Query) {
+	if c.Cache == nil {
 		return nil, nil
 	}
-
-	cached := c.Cache.Retrieve(hctx.Query.Method(), hctx.Query.Params())
-	if cached == nil {
-		metrics.ProxyQueryCacheMissCount.WithLabelValues(hctx.Query.Method()).Inc()
+	q := hctx.Query
+	rule := c.CachePolicy.Match(q)
+	if rule == nil {
 		return nil, nil
 	}
 
-	s, err := json.Marshal(cached)
+	cached, err := c.retrieveFromCache(q)
 	if err != nil {
-		metrics.ProxyQueryCacheErrorCount.WithLabelValues(hctx.Query.Method()).Inc()
-		logger.Log().Errorf("error marshalling cached response")
+		metrics.ProxyQueryCacheErrorCount.WithLabelValues(q.Method(), rule.Name).Inc()
+		logger.Log().Error(err)
 		return nil, nil
 	}
-
-	response := hctx.Query.newResponse()
-
-	err = json.Unmarshal(s, &response)
-	if err != nil {
-		metrics.ProxyQueryCacheErrorCount.WithLabelValues(hctx.Query.Method()).Inc()
-		logger.Log().Errorf("error unmarshalling cached response")
+	if cached == nil {
+		metrics.ProxyQueryCacheMissCount.WithLabelValues(q.Method(), rule.Name).Inc()
 		return nil, nil
 	}
 
-	metrics.ProxyQueryCacheHitCount.WithLabelValues(hctx.Query.Method()).Inc()
-	logger.WithFields(logrus.Fields{"method": hctx.Query.Method()}).Debug("cached query")
+	state := metrics.CacheStateFresh
+	if cached.isStale() {
+		state = metrics.CacheStateStale
+		c.refreshInBackground(q, rule)
+	}
+
+	response := cached.Response
+	response.ID = q.Request.ID
+	metrics.ProxyQueryCacheHitCount.WithLabelValues(q.Method(), rule.Name, state).Inc()
+	logger.WithFields(logrus.Fields{"method": q.Method(), "state": state}).Debug("cached query")
 	return response, nil
 }
 
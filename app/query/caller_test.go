@@ -1,21 +1,26 @@
 package query
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"math/rand"
 	"testing"
 	"time"
 
 	"github.com/lbryio/lbrytv-player/pkg/paid"
+	"github.com/lbryio/lbrytv/app/query/cache"
 	"github.com/lbryio/lbrytv/app/rpcerrors"
 	"github.com/lbryio/lbrytv/app/sdkrouter"
 	"github.com/lbryio/lbrytv/app/wallet"
 	"github.com/lbryio/lbrytv/apps/lbrytv/config"
 	"github.com/lbryio/lbrytv/internal/errors"
 	"github.com/lbryio/lbrytv/internal/test"
+	"github.com/lbryio/lbrytv/models"
 
 	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/sirupsen/logrus"
 	logrusTest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
@@ -41,7 +46,8 @@ func TestCaller_CallBlankEndpoint(t *testing.T) {
 func TestCaller_CallRelaxedMethods(t *testing.T) {
 	config.Override("LbrynetXPercentage", 0)
 	defer config.RestoreOverridden()
-	for _, m := range relaxedMethods {
+	policy := CurrentMethodPolicy()
+	for _, m := range append(policy.MethodsWithWallet(WalletNone), policy.MethodsWithWallet(WalletOptional)...) {
 		if m == MethodStatus || m == MethodGet {
 			continue
 		}
@@ -52,7 +58,7 @@ func TestCaller_CallRelaxedMethods(t *testing.T) {
 			srv.NextResponse <- test.EmptyResponse()
 
 			caller := NewCaller(srv.URL, 0)
-			resp, err := caller.Call(jsonrpc.NewRequest(m))
+			resp, err := caller.Call(validRequest(m))
 			assert.Nil(t, resp)
 			assert.Error(t, err)                                       // empty response should be an error
 			assert.False(t, errors.Is(err, rpcerrors.ErrAuthRequired)) // but it should not be an auth error
@@ -60,7 +66,7 @@ func TestCaller_CallRelaxedMethods(t *testing.T) {
 			receivedRequest := <-reqChan
 			expectedRequest := test.ReqToStr(t, &jsonrpc.RPCRequest{
 				Method:  m,
-				Params:  nil,
+				Params:  validRequest(m).Params,
 				JSONRPC: "2.0",
 			})
 			assert.EqualValues(t, expectedRequest, receivedRequest.Body)
@@ -71,8 +77,8 @@ func TestCaller_CallRelaxedMethods(t *testing.T) {
 func TestCaller_CallAmbivalentMethodsWithoutWallet(t *testing.T) {
 	config.Override("LbrynetXPercentage", 0)
 	defer config.RestoreOverridden()
-	for _, m := range relaxedMethods {
-		if !methodInList(m, walletSpecificMethods) || m == MethodGet {
+	for _, m := range CurrentMethodPolicy().MethodsWithWallet(WalletOptional) {
+		if m == MethodGet {
 			continue
 		}
 		t.Run(m, func(t *testing.T) {
@@ -81,7 +87,7 @@ func TestCaller_CallAmbivalentMethodsWithoutWallet(t *testing.T) {
 			defer srv.Close()
 			caller := NewCaller(srv.URL, 0)
 			srv.NextResponse <- test.EmptyResponse()
-			resp, err := caller.Call(jsonrpc.NewRequest(m))
+			resp, err := caller.Call(validRequest(m))
 			assert.Nil(t, resp)
 			assert.Error(t, err) // empty response should be an error
 			assert.False(t, errors.Is(err, rpcerrors.ErrAuthRequired))
@@ -89,7 +95,7 @@ func TestCaller_CallAmbivalentMethodsWithoutWallet(t *testing.T) {
 			receivedRequest := <-reqChan
 			expectedRequest := test.ReqToStr(t, &jsonrpc.RPCRequest{
 				Method:  m,
-				Params:  nil,
+				Params:  validRequest(m).Params,
 				JSONRPC: "2.0",
 			})
 			assert.EqualValues(t, expectedRequest, receivedRequest.Body)
@@ -101,8 +107,8 @@ func TestCaller_CallAmbivalentMethodsWithWallet(t *testing.T) {
 	dummyUserID := 123321
 	var methodsTested int
 
-	for _, m := range relaxedMethods {
-		if !methodInList(m, walletSpecificMethods) || m == MethodGet {
+	for _, m := range CurrentMethodPolicy().MethodsWithWallet(WalletOptional) {
+		if m == MethodGet {
 			continue
 		}
 		methodsTested++
@@ -113,25 +119,26 @@ func TestCaller_CallAmbivalentMethodsWithWallet(t *testing.T) {
 			srv.NextResponse <- test.EmptyResponse()
 			authedCaller := NewCaller(srv.URL, dummyUserID)
 
-			resp, err := authedCaller.Call(jsonrpc.NewRequest(m))
+			resp, err := authedCaller.Call(validRequest(m))
 			assert.Nil(t, resp)
 			assert.Error(t, err) // empty response should be an error
 			assert.False(t, errors.Is(err, rpcerrors.ErrAuthRequired))
 
 			receivedRequest := <-reqChan
+			params := map[string]interface{}{}
+			if p, ok := validRequest(m).Params.(map[string]interface{}); ok {
+				params = p
+			}
+			params["wallet_id"] = sdkrouter.WalletID(dummyUserID)
 			expectedRequest := test.ReqToStr(t, &jsonrpc.RPCRequest{
-				Method: m,
-				Params: map[string]interface{}{
-					"wallet_id": sdkrouter.WalletID(dummyUserID),
-				},
+				Method:  m,
+				Params:  params,
 				JSONRPC: "2.0",
 			})
+			params["new_sdk_server"] = config.GetLbrynetXServer()
 			expectedRequestLbrynetX := test.ReqToStr(t, &jsonrpc.RPCRequest{
-				Method: m,
-				Params: map[string]interface{}{
-					"wallet_id":      sdkrouter.WalletID(dummyUserID),
-					"new_sdk_server": config.GetLbrynetXServer(),
-				},
+				Method:  m,
+				Params:  params,
 				JSONRPC: "2.0",
 			})
 
@@ -148,10 +155,7 @@ func TestCaller_CallAmbivalentMethodsWithWallet(t *testing.T) {
 }
 
 func TestCaller_CallNonRelaxedMethods(t *testing.T) {
-	for _, m := range walletSpecificMethods {
-		if methodInList(m, relaxedMethods) {
-			continue
-		}
+	for _, m := range CurrentMethodPolicy().MethodsWithWallet(WalletRequired) {
 		t.Run(m, func(t *testing.T) {
 			reqChan := test.ReqChan()
 			srv := test.MockHTTPServer(reqChan)
@@ -205,7 +209,7 @@ func TestCaller_AddPreflightHookAmendingQueryParams(t *testing.T) {
 
 	c := NewCaller(srv.URL, 0)
 
-	c.AddPreflightHook(relaxedMethods[0], func(_ *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
+	c.AddPreflightHook("blob_announce", func(_ *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
 		params := hctx.Query.ParamsAsMap()
 		if params == nil {
 			hctx.Query.Request.Params = map[string]string{"param": "123"}
@@ -218,7 +222,7 @@ func TestCaller_AddPreflightHookAmendingQueryParams(t *testing.T) {
 
 	srv.NextResponse <- test.EmptyResponse()
 
-	c.Call(jsonrpc.NewRequest(relaxedMethods[0]))
+	c.Call(jsonrpc.NewRequest("blob_announce"))
 	req := <-reqChan
 	lastRequest := test.StrToReq(t, req.Body)
 
@@ -234,13 +238,13 @@ func TestCaller_AddPreflightHookReturningEarlyResponse(t *testing.T) {
 
 	c := NewCaller(srv.URL, 0)
 
-	c.AddPreflightHook(relaxedMethods[0], func(_ *Caller, _ *HookContext) (*jsonrpc.RPCResponse, error) {
+	c.AddPreflightHook("blob_announce", func(_ *Caller, _ *HookContext) (*jsonrpc.RPCResponse, error) {
 		return &jsonrpc.RPCResponse{Result: map[string]string{"ok": "ok"}}, nil
 	}, "")
 
 	srv.NextResponse <- test.EmptyResponse()
 
-	res, err := c.Call(jsonrpc.NewRequest(relaxedMethods[0]))
+	res, err := c.Call(jsonrpc.NewRequest("blob_announce"))
 	require.NoError(t, err)
 
 	assert.Equal(t, map[string]string{"ok": "ok"}, res.Result)
@@ -253,13 +257,13 @@ func TestCaller_AddPreflightHookReturningError(t *testing.T) {
 
 	c := NewCaller(srv.URL, 0)
 
-	c.AddPreflightHook(relaxedMethods[0], func(_ *Caller, _ *HookContext) (*jsonrpc.RPCResponse, error) {
+	c.AddPreflightHook("blob_announce", func(_ *Caller, _ *HookContext) (*jsonrpc.RPCResponse, error) {
 		return &jsonrpc.RPCResponse{Result: map[string]string{"ok": "ok"}}, errors.Err("an error occured")
 	}, "")
 
 	srv.NextResponse <- test.EmptyResponse()
 
-	res, err := c.Call(jsonrpc.NewRequest(relaxedMethods[0]))
+	res, err := c.Call(jsonrpc.NewRequest("blob_announce"))
 	assert.EqualError(t, err, "an error occured")
 	assert.Nil(t, res)
 }
@@ -403,6 +407,55 @@ func TestCaller_CallSDKError(t *testing.T) {
 	assert.Equal(t, "resolve", hook.LastEntry().Data["method"])
 }
 
+func TestCaller_CallServerBusy(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	rt := sdkrouter.NewWithServers(&models.LbrynetServer{Name: "srv", Address: srv.URL})
+	rt.SetConcurrencyLimits(sdkrouter.ConcurrencyLimits{
+		srv.URL: {MaxConcurrent: 1, QueueTimeout: 10 * time.Millisecond},
+	})
+
+	release, err := rt.AcquireSlot(context.Background(), srv.URL)
+	require.NoError(t, err)
+
+	c := NewCaller(srv.URL, 0)
+	c.Router = rt
+	_, err = c.Call(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.Error(t, err)
+	var rpcErr rpcerrors.RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, -32087, rpcErr.Code())
+	assert.Equal(t, "server busy", err.Error())
+
+	release()
+	srv.NextResponse <- `{"jsonrpc": "2.0", "result": {}, "id": 0}`
+	_, err = c.Call(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.NoError(t, err)
+}
+
+func TestCaller_CallCircuitOpen(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	rt := sdkrouter.NewWithServers(&models.LbrynetServer{Name: "srv", Address: srv.URL})
+	rt.SetBreakerSettings(sdkrouter.BreakerSettings{
+		Window: time.Minute, MinRequests: 1, ErrorRate: 0.5, OpenDuration: time.Hour, HalfOpenRequests: 1,
+	})
+
+	c := NewCaller(srv.URL, 0)
+	c.Router = rt
+	srv.NextResponse <- `{"method":"version}`
+	_, err := c.Call(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.Error(t, err)
+	assert.True(t, rt.CircuitOpen(srv.URL))
+
+	_, err = c.Call(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.Error(t, err)
+	var rpcErr rpcerrors.RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, -32088, rpcErr.Code())
+	assert.True(t, errors.Is(err, sdkrouter.ErrCircuitOpen))
+}
+
 func TestCaller_ClientJSONError(t *testing.T) {
 	ts := test.MockHTTPServer(nil)
 	defer ts.Close()
@@ -559,6 +612,38 @@ func TestCaller_DontReloadWalletIfAlreadyLoaded(t *testing.T) {
 	require.Equal(t, `"99999.00"`, r.Result)
 }
 
+func TestCaller_CheckWalletReload(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	c := NewCaller(srv.URL, 123)
+	var checked []string
+	c.CheckWalletReload = func(endpoint string, userID int) error {
+		checked = append(checked, fmt.Sprintf("%v:%v", endpoint, userID))
+		return errors.Err("wallet is being migrated")
+	}
+	q, err := NewQuery(jsonrpc.NewRequest("wallet_balance"), sdkrouter.WalletID(123))
+	require.NoError(t, err)
+
+	srv.QueueResponses(test.ResToStr(t, &jsonrpc.RPCResponse{
+		JSONRPC: "2.0",
+		Error: &jsonrpc.RPCError{
+			Message: "Couldn't find wallet: //",
+		},
+	}))
+	_, err = c.SendQuery(q)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "wallet is being migrated")
+	var rpcErr rpcerrors.RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, rpcerrors.NewServerBusyError(nil).Code(), rpcErr.Code())
+
+	assert.Equal(t, []string{fmt.Sprintf("%v:123", srv.URL)}, checked)
+	<-reqChan
+	assert.Len(t, reqChan, 0, "wallet should not be reloaded")
+}
+
 func TestCaller_Status(t *testing.T) {
 	c := NewCaller(test.RandServerAddress(t), 0)
 	rpcResponse, err := c.Call(jsonrpc.NewRequest("status"))
@@ -713,11 +798,11 @@ func TestCaller_GetPaidResolveLag(t *testing.T) {
 	srv := test.MockHTTPServer(nil)
 	defer srv.Close()
 
-	srv.QueueResponses(
-		resolveResponseWithoutPurchase,
-		purchaseCreateResponse,
-		resolveResponseWithoutPurchase,
-	)
+	responses := []string{resolveResponseWithoutPurchase, purchaseCreateResponse}
+	for i := 0; i < receiptPollAttempts; i++ {
+		responses = append(responses, resolveResponseWithoutPurchase)
+	}
+	srv.QueueResponses(responses...)
 
 	request := jsonrpc.NewRequest(MethodGet, map[string]interface{}{"uri": uri})
 	_, err := NewCaller(srv.URL, dummyUserID).Call(request)
@@ -851,3 +936,25 @@ func TestCaller_LogLevels(t *testing.T) {
 	assert.Equal(t, "sync_apply", hook.LastEntry().Data["method"])
 	assert.Equal(t, logrus.DebugLevel, e.Level)
 }
+
+func TestCaller_CallCachedInRedis(t *testing.T) {
+	redisSrv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisSrv.Close()
+	qCache, err := cache.NewRedisCache("redis://"+redisSrv.Addr(), time.Minute)
+	require.NoError(t, err)
+
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [{"claim_id": "abc"}], "page": 1}}`
+
+	for i := 0; i < 2; i++ {
+		c := NewCaller(srv.URL, 0)
+		c.Cache = qCache
+		res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@veritasium"}))
+		require.NoError(t, err)
+		require.Nil(t, res.Error)
+		assert.Equal(t, "abc", res.Result.(map[string]interface{})["items"].([]interface{})[0].(map[string]interface{})["claim_id"])
+	}
+	assert.Equal(t, 1, qCache.Count())
+}
@@ -0,0 +1,130 @@
+package query
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/ybbus/jsonrpc"
+)
+
+// flight is a cacheable query being sent to the SDK on behalf of all callers waiting for it.
+type flight struct {
+	done     chan struct{}
+	res      *jsonrpc.RPCResponse
+	err      error
+	duration float64
+	cancel   context.CancelFunc
+	// waiters is guarded by flightGroup.mu
+	waiters int
+}
+
+// flightGroup holds cacheable queries currently being sent to the SDK.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// inflight holds cacheable queries currently being sent to the SDK, keyed by endpoint and QueryCache key.
+// Identical queries are only shared by callers of the same endpoint, so a query is never answered
+// by a server other than the one it was meant for.
+var inflight = flightGroup{flights: map[string]*flight{}}
+
+// join returns the flight for key, starting it with send if there is none.
+// leader is true if the flight has been started by this call.
+func (g *flightGroup) join(key string, send func(ctx context.Context) (*jsonrpc.RPCResponse, float64, error)) (f *flight, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		f.waiters++
+		return f, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f = &flight{done: make(chan struct{}), cancel: cancel, waiters: 1}
+	g.flights[key] = f
+	go func() {
+		f.res, f.duration, f.err = send(ctx)
+		g.forget(key, f)
+		cancel()
+		close(f.done)
+	}()
+	return f, true
+}
+
+// leave removes a caller that is no longer waiting for the flight.
+// The query is cancelled once nobody is waiting for it anymore.
+func (g *flightGroup) leave(key string, f *flight) {
+	g.mu.Lock()
+	f.waiters--
+	abandoned := f.waiters == 0
+	if abandoned {
+		g.forgetLocked(key, f)
+	}
+	g.mu.Unlock()
+	if abandoned {
+		f.cancel()
+	}
+}
+
+// forget removes the flight so that new callers start a flight of their own.
+func (g *flightGroup) forget(key string, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.forgetLocked(key, f)
+}
+
+func (g *flightGroup) forgetLocked(key string, f *flight) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
+
+// sendCoalesced sends a cacheable query to the SDK and saves the response to the cache.
+// If an identical query to the same endpoint is already in flight, it waits for that query to complete instead
+// and returns a copy of its response.
+// The shared query is not bound to ctx as other callers may be waiting for it, only waiting for it is.
+// It is cancelled once all callers waiting for it are done.
+// The shared query is sent by a clone of the caller as it can outlive the caller waiting for it.
+func (c *Caller) sendCoalesced(ctx context.Context, q *Query, rule *CacheRule) (*jsonrpc.RPCResponse, error) {
+	send := func(ctx context.Context) (*jsonrpc.RPCResponse, float64, error) {
+		cc := c.clone()
+		res, err := cc.SendQueryContext(ctx, q)
+		if err == nil && res.Error == nil && cc.Cache != nil {
+			cc.saveToCache(q, rule, res)
+		}
+		return res, cc.Duration, err
+	}
+
+	key, err := cache.Key(q.Method(), q.Params())
+	if err != nil {
+		logger.Log().Errorf("unable to produce key for params: %v", q.Params())
+		res, duration, err := send(ctx)
+		c.Duration = duration
+		return res, err
+	}
+	key = c.endpoint + " " + key
+
+	f, leader := inflight.join(key, send)
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		inflight.leave(key, f)
+		return nil, c.abandon(ctx, q)
+	}
+	if leader {
+		c.Duration = f.duration
+		return f.res, f.err
+	}
+
+	metrics.ProxyQueryCoalescedCount.WithLabelValues(q.Method()).Inc()
+	if f.err != nil {
+		return nil, f.err
+	}
+	// the response is shared by all waiting callers so it must not be modified in place
+	r := *f.res
+	r.ID = q.Request.ID
+	return &r, nil
+}
@@ -0,0 +1,171 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func TestCaller_CallCoalesced(t *testing.T) {
+	const callers = 20
+
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	qCache := cache.NewMemoryCache()
+	responses := make([]*jsonrpc.RPCResponse, callers)
+	errs := make([]error, callers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := NewCaller(srv.URL, 0)
+			c.Cache = qCache
+			req := jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@coalesced"})
+			req.ID = i
+			responses[i], errs[i] = c.Call(req)
+		}(i)
+	}
+
+	// hold the response until all callers have sent their queries
+	<-reqChan
+	time.Sleep(200 * time.Millisecond)
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [{"claim_id": "abc"}], "page": 1}}`
+	wg.Wait()
+
+	assert.Len(t, reqChan, 0, "only one query should reach the SDK")
+	ownIDs := 0
+	for i := 0; i < callers; i++ {
+		require.NoError(t, errs[i])
+		require.NotNil(t, responses[i])
+		require.Nil(t, responses[i].Error)
+		assert.NotNil(t, responses[i].Result)
+		if responses[i].ID == i {
+			ownIDs++
+		}
+	}
+	// the mock SDK responds with id 0 to the one query it receives, other callers get their own request ids
+	assert.GreaterOrEqual(t, ownIDs, callers-1)
+	assert.Equal(t, 1, qCache.Count())
+}
+
+func TestCaller_CallCoalescedError(t *testing.T) {
+	const callers = 5
+
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	errs := make([]error, callers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := NewCaller(srv.URL, 0)
+			c.Cache = cache.NewMemoryCache()
+			_, errs[i] = c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@coalescederror"}))
+		}(i)
+	}
+
+	<-reqChan
+	time.Sleep(200 * time.Millisecond)
+	srv.NextResponse <- test.EmptyResponse()
+	wg.Wait()
+
+	assert.Len(t, reqChan, 0)
+	for i := 0; i < callers; i++ {
+		assert.Error(t, errs[i])
+	}
+}
+
+func TestCaller_CallNotCoalescedDifferentParams(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+	srv.QueueResponses(
+		`{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`,
+		`{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 2}}`,
+	)
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := NewCaller(srv.URL, 0)
+			_, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"page": i}))
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, reqChan, 2)
+}
+
+func TestCaller_CallCoalescedAbandoned(t *testing.T) {
+	srv, abandoned := newHangingServer()
+	defer srv.Close()
+
+	call := func(ctx context.Context) error {
+		c := NewCaller(srv.URL, 0)
+		_, err := c.CallContext(ctx, jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@canceled"}))
+		return err
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { errs <- call(ctx1) }()
+	time.Sleep(100 * time.Millisecond)
+	go func() { errs <- call(ctx2) }()
+	time.Sleep(100 * time.Millisecond)
+
+	cancel1()
+	assert.True(t, errors.Is(<-errs, context.Canceled))
+	select {
+	case <-abandoned:
+		t.Fatal("shared query should not be cancelled while somebody is waiting for it")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel2()
+	assert.True(t, errors.Is(<-errs, context.Canceled))
+	select {
+	case <-abandoned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shared query wasn't cancelled after all callers left")
+	}
+}
+
+func TestCaller_CallNotCoalescedDifferentEndpoints(t *testing.T) {
+	reqChan1, reqChan2 := test.ReqChan(), test.ReqChan()
+	srv1, srv2 := test.MockHTTPServer(reqChan1), test.MockHTTPServer(reqChan2)
+	defer srv1.Close()
+	defer srv2.Close()
+
+	wg := sync.WaitGroup{}
+	for _, url := range []string{srv1.URL, srv2.URL} {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			c := NewCaller(url, 0)
+			_, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@endpoints"}))
+			assert.NoError(t, err)
+		}(url)
+	}
+	<-reqChan1
+	<-reqChan2
+	srv1.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`
+	srv2.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`
+	wg.Wait()
+}
@@ -0,0 +1,57 @@
+package query
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+)
+
+type ctxKey int
+
+const (
+	optionsKey ctxKey = iota
+	hedgeAttemptKey
+)
+
+// contextTransport binds outgoing HTTP requests to a context, so the JSON-RPC client,
+// which doesn't support contexts itself, aborts its calls once the context is done.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(r.WithContext(t.ctx))
+}
+
+// sleepContext pauses for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FailureKind returns the metrics failure kind for a query that failed with err:
+// metrics.FailureKindTimeout if the SDK didn't respond within the method timeout,
+// metrics.FailureKindCanceled if the client went away, metrics.FailureKindDeadline if the query ran out of time
+// and metrics.FailureKindNet otherwise.
+func FailureKind(err error) string {
+	switch {
+	case errors.Is(err, rpcerrors.ErrTimeout):
+		return metrics.FailureKindTimeout
+	case errors.Is(err, context.Canceled):
+		return metrics.FailureKindCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return metrics.FailureKindDeadline
+	}
+	return metrics.FailureKindNet
+}
@@ -0,0 +1,138 @@
+package query
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+// newHangingServer returns a server which doesn't respond until the request is abandoned by the client.
+func newHangingServer() (*httptest.Server, chan struct{}) {
+	abandoned := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Client disconnects are only noticed once the request body has been read
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			abandoned <- struct{}{}
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	return srv, abandoned
+}
+
+func TestCaller_CallContextCanceled(t *testing.T) {
+	srv, abandoned := newHangingServer()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := NewCaller(srv.URL, 0).CallContext(ctx, jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, metrics.FailureKindCanceled, FailureKind(err))
+
+	select {
+	case <-abandoned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("outbound call wasn't aborted")
+	}
+}
+
+func TestCaller_CallContextDeadline(t *testing.T) {
+	srv, abandoned := newHangingServer()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewCaller(srv.URL, 0).CallContext(ctx, jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, metrics.FailureKindDeadline, FailureKind(err))
+	<-abandoned
+}
+
+func TestCaller_CallContextCancelsWalletRetry(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), walletLoadRetryWait/2)
+	defer cancel()
+	go func() {
+		srv.NextResponse <- `{"jsonrpc": "2.0", "error": {"code": -32500, "message": "Couldn't find wallet: //"}, "id": 0}`
+	}()
+	_, err := NewCaller(srv.URL, 1).CallContext(ctx, jsonrpc.NewRequest(MethodWalletBalance))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Len(t, reqChan, 1, "wallet should not be loaded after the query deadline")
+}
+
+func TestCaller_HookContext(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	var preflight, postflight interface{}
+	c := NewCaller(srv.URL, 0)
+	c.AddPreflightHook(MethodResolve, func(_ *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
+		preflight = hctx.Context().Value(ctxKey{})
+		return nil, nil
+	}, "")
+	c.AddPostflightHook(MethodResolve, func(_ *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
+		postflight = hctx.Context().Value(ctxKey{})
+		return nil, nil
+	}, "")
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "result": {}, "id": 0}`
+	_, err := c.CallContext(ctx, jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.NoError(t, err)
+	assert.Equal(t, "value", preflight)
+	assert.Equal(t, "value", postflight)
+	assert.NotNil(t, (&HookContext{}).Context())
+}
+
+func TestCaller_CallCoalescedCanceled(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+	qCache := cache.NewMemoryCache()
+	req := func() *jsonrpc.RPCRequest {
+		return jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@coalesced_canceled"})
+	}
+
+	leaderDone := make(chan error)
+	go func() {
+		c := NewCaller(srv.URL, 0)
+		c.Cache = qCache
+		_, err := c.Call(req())
+		leaderDone <- err
+	}()
+	<-reqChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := NewCaller(srv.URL, 0)
+	c.Cache = qCache
+	_, err := c.CallContext(ctx, req())
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "waiting for a shared query should stop with the context")
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`
+	require.NoError(t, <-leaderDone, "shared query should not be affected")
+	assert.Equal(t, 1, qCache.Count())
+}
@@ -0,0 +1,104 @@
+package query
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ybbus/jsonrpc"
+)
+
+// transportError is a failure to get any response from the SDK, e.g. a refused connection.
+type transportError struct {
+	err error
+}
+
+func (e transportError) Error() string { return e.err.Error() }
+func (e transportError) Unwrap() error { return e.err }
+
+func isTransportError(err error) bool {
+	var e transportError
+	return errors.As(err, &e)
+}
+
+// Failover sets how queries that could not be delivered to the SDK are resent to other servers.
+type Failover struct {
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// NewFailover validates failover settings from the config. Failover is disabled if Retries is not set.
+func NewFailover(cfg config.Failover) (*Failover, error) {
+	f := &Failover{
+		retries:    cfg.Retries,
+		backoff:    time.Duration(cfg.Backoff) * time.Millisecond,
+		maxBackoff: time.Duration(cfg.MaxBackoff) * time.Millisecond,
+	}
+	if f.retries < 0 {
+		return nil, errors.Err("failover Retries should not be negative")
+	}
+	if f.retries > 0 && (f.backoff <= 0 || f.maxBackoff < f.backoff) {
+		return nil, errors.Err("failover Backoff should be positive and not greater than MaxBackoff")
+	}
+	return f, nil
+}
+
+// Backoff returns how long to wait before the attempt (counting from 0) to resend a query.
+// The wait doubles with every attempt up to MaxBackoff, a random half of it is jitter.
+func (f *Failover) Backoff(attempt int) time.Duration {
+	d := f.maxBackoff
+	if attempt < 30 && f.backoff<<attempt < f.maxBackoff {
+		d = f.backoff << attempt
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// canFailover returns true if the query which failed with err can be resent to another server,
+// which is only the case for transport failures of queries made without a wallet.
+func (c *Caller) canFailover(q *Query, err error) bool {
+	return c.AlternativeEndpoint != nil && q.WalletID == "" && c.Failover != nil && c.Failover.retries > 0 && isTransportError(err)
+}
+
+// failover resends the query which failed with err to other servers, one at a time,
+// until one of them responds or the retry budget is spent.
+func (c *Caller) failover(ctx context.Context, q *Query, err error) (*jsonrpc.RPCResponse, error) {
+	f := c.Failover
+	tried := []string{c.endpoint}
+	for attempt := 0; attempt < f.retries; attempt++ {
+		endpoint := c.AlternativeEndpoint(tried...)
+		if endpoint == "" {
+			logger.Log().Warnf("no servers left to fail %v query over to", q.Method())
+			break
+		}
+		if sleepErr := sleepContext(ctx, f.Backoff(attempt)); sleepErr != nil {
+			return nil, c.abandon(ctx, q)
+		}
+
+		logger.WithFields(logrus.Fields{
+			"method":  q.Method(),
+			"from":    tried[len(tried)-1],
+			"to":      endpoint,
+			"attempt": attempt + 1,
+		}).Warnf("failing over after error: %v", err)
+		tried = append(tried, endpoint)
+
+		req := *q.Request
+		var res *jsonrpc.RPCResponse
+		res, err = c.cloneTo(endpoint).sendQuery(ctx, &Query{Request: &req, WalletID: q.WalletID})
+		if err == nil {
+			metrics.ProxyFailoverCount.WithLabelValues(q.Method(), metrics.FailoverSucceeded).Inc()
+			return res, nil
+		}
+		metrics.ProxyFailoverCount.WithLabelValues(q.Method(), metrics.FailoverFailed).Inc()
+		if !isTransportError(err) {
+			break
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,152 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/metrics"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func newTestFailover(t *testing.T, retries int) *Failover {
+	f, err := NewFailover(config.Failover{Retries: retries, Backoff: 1, MaxBackoff: 4})
+	require.NoError(t, err)
+	return f
+}
+
+// newDeadServer returns an address nothing is listening on.
+func newDeadServer() string {
+	srv := test.MockHTTPServer(nil)
+	srv.Close()
+	return srv.URL
+}
+
+func TestNewFailover(t *testing.T) {
+	f, err := NewFailover(config.Failover{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, f.retries)
+
+	_, err = NewFailover(config.Failover{Retries: -1})
+	assert.EqualError(t, err, "failover Retries should not be negative")
+	_, err = NewFailover(config.Failover{Retries: 2})
+	assert.EqualError(t, err, "failover Backoff should be positive and not greater than MaxBackoff")
+	_, err = NewFailover(config.Failover{Retries: 2, Backoff: 100, MaxBackoff: 50})
+	assert.EqualError(t, err, "failover Backoff should be positive and not greater than MaxBackoff")
+}
+
+func TestFailoverBackoff(t *testing.T) {
+	f, err := NewFailover(config.Failover{Retries: 5, Backoff: 100, MaxBackoff: 300})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		for attempt, max := range []time.Duration{100, 200, 300, 300, 300} {
+			max *= time.Millisecond
+			b := f.Backoff(attempt)
+			assert.GreaterOrEqual(t, int64(b), int64(max/2))
+			assert.LessOrEqual(t, int64(b), int64(max))
+		}
+	}
+	assert.GreaterOrEqual(t, int64(f.Backoff(100)), int64(150*time.Millisecond), "backoff should not overflow")
+}
+
+func TestCaller_CallFailover(t *testing.T) {
+	f := newTestFailover(t, 2)
+
+	dead1, dead2 := newDeadServer(), newDeadServer()
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	go func() {
+		srv.NextResponse <- `{"jsonrpc": "2.0", "result": {"items": []}, "id": 0}`
+	}()
+
+	succeeded := testutil.ToFloat64(metrics.ProxyFailoverCount.WithLabelValues(MethodClaimSearch, metrics.FailoverSucceeded))
+	failed := testutil.ToFloat64(metrics.ProxyFailoverCount.WithLabelValues(MethodClaimSearch, metrics.FailoverFailed))
+
+	c := NewCaller(dead1, 0)
+	c.Failover = f
+	var excluded [][]string
+	c.AlternativeEndpoint = func(exclude ...string) string {
+		excluded = append(excluded, exclude)
+		if len(exclude) == 1 {
+			return dead2
+		}
+		return srv.URL
+	}
+	res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch))
+	require.NoError(t, err)
+	require.Nil(t, res.Error)
+	assert.Equal(t, [][]string{{dead1}, {dead1, dead2}}, excluded)
+	assert.Equal(t, succeeded+1, testutil.ToFloat64(metrics.ProxyFailoverCount.WithLabelValues(MethodClaimSearch, metrics.FailoverSucceeded)))
+	assert.Equal(t, failed+1, testutil.ToFloat64(metrics.ProxyFailoverCount.WithLabelValues(MethodClaimSearch, metrics.FailoverFailed)))
+}
+
+func TestCaller_CallFailoverBudget(t *testing.T) {
+	f := newTestFailover(t, 2)
+
+	var attempts int
+	c := NewCaller(newDeadServer(), 0)
+	c.Failover = f
+	c.AlternativeEndpoint = func(exclude ...string) string {
+		attempts++
+		return newDeadServer()
+	}
+	_, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch))
+	require.Error(t, err)
+	assert.True(t, isTransportError(err))
+	assert.Equal(t, 2, attempts)
+
+	attempts = 0
+	c.AlternativeEndpoint = func(exclude ...string) string {
+		attempts++
+		return ""
+	}
+	_, err = c.Call(jsonrpc.NewRequest(MethodClaimSearch))
+	require.Error(t, err)
+	assert.Equal(t, 1, attempts, "failover should stop when there are no servers left")
+}
+
+func TestCaller_CallNoFailover(t *testing.T) {
+	f := newTestFailover(t, 2)
+
+	alternative := func(exclude ...string) string {
+		t.Error("query should not be failed over")
+		return ""
+	}
+
+	// Queries with a wallet must go to the server holding it
+	c := NewCaller(newDeadServer(), 0)
+	c.Failover = f
+	c.AlternativeEndpoint = alternative
+	q, err := NewQuery(jsonrpc.NewRequest(MethodClaimSearch), "lbrytv-id.1.wallet")
+	require.NoError(t, err)
+	_, err = c.SendQuery(q)
+	require.Error(t, err)
+
+	// SDK errors mean the server is reachable
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	srv.NextResponse <- `{"jsonrpc": "2.0", "error": {"code": -32500, "message": "bad"}, "id": 0}`
+	c = NewCaller(srv.URL, 0)
+	c.Failover = f
+	c.AlternativeEndpoint = alternative
+	res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch))
+	require.NoError(t, err)
+	require.NotNil(t, res.Error)
+
+	// Neither are cancelled queries
+	slow, _ := newHangingServer()
+	defer slow.Close()
+	c = NewCaller(slow.URL, 0)
+	c.Failover = f
+	c.AlternativeEndpoint = alternative
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.CallContext(ctx, jsonrpc.NewRequest(MethodClaimSearch))
+	require.Error(t, err)
+}
@@ -0,0 +1,253 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/ybbus/jsonrpc"
+)
+
+const (
+	// latencySamples is the number of recent response times per method the hedging delay is calculated from.
+	latencySamples = 500
+	// minLatencySamples is the number of response times required before the percentile is used instead of MaxDelay.
+	minLatencySamples = 20
+	// delayRecalcInterval is the number of new samples after which the hedging delay is recalculated.
+	delayRecalcInterval = 50
+)
+
+// Hedging sends a query to a second server when the first one is slower than usual to respond,
+// using whichever response arrives first.
+type Hedging struct {
+	methods    map[string]bool
+	percentile float64
+	minDelay   time.Duration
+	maxDelay   time.Duration
+
+	mu        sync.Mutex
+	latencies map[string]*latencyWindow
+}
+
+// NewHedging validates hedging settings from the config. Hedging is disabled if no methods are set.
+func NewHedging(cfg config.Hedging) (*Hedging, error) {
+	h := &Hedging{
+		methods:    map[string]bool{},
+		percentile: cfg.Percentile,
+		minDelay:   time.Duration(cfg.MinDelay) * time.Millisecond,
+		maxDelay:   time.Duration(cfg.MaxDelay) * time.Millisecond,
+		latencies:  map[string]*latencyWindow{},
+	}
+	if len(cfg.Methods) == 0 {
+		return h, nil
+	}
+	if h.percentile <= 0 || h.percentile >= 100 {
+		return nil, errors.Err("hedging percentile should be between 0 and 100")
+	}
+	if h.minDelay < 0 || h.maxDelay <= 0 || h.maxDelay < h.minDelay {
+		return nil, errors.Err("hedging MaxDelay should be positive and not less than MinDelay")
+	}
+	for _, m := range cfg.Methods {
+		// Queries sent with a wallet must go to the server holding it
+		if CurrentMethodPolicy().RequiresWallet(m) {
+			return nil, errors.Err("%v requires a wallet and cannot be hedged", m)
+		}
+		h.methods[m] = true
+	}
+	return h, nil
+}
+
+// Hedges returns true if queries of the method are hedged.
+func (h *Hedging) Hedges(method string) bool {
+	return h.methods[method]
+}
+
+// Delay returns for how long a response to a query of the method is waited for before the query is hedged.
+func (h *Hedging) Delay(method string) time.Duration {
+	d, ok := h.window(method).percentile(h.percentile)
+	if !ok {
+		return h.maxDelay
+	}
+	if d < h.minDelay {
+		return h.minDelay
+	}
+	if d > h.maxDelay {
+		return h.maxDelay
+	}
+	return d
+}
+
+// observe records the response time of a successful query.
+func (h *Hedging) observe(method string, latency time.Duration) {
+	h.window(method).add(latency)
+}
+
+func (h *Hedging) window(method string) *latencyWindow {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.latencies[method]
+	if !ok {
+		w = &latencyWindow{}
+		h.latencies[method] = w
+	}
+	return w
+}
+
+// latencyWindow keeps recent response times of a method.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	// added is the number of samples added since cached percentile was calculated
+	added            int
+	cached           time.Duration
+	cachedPercentile float64
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % latencySamples
+	}
+	w.added++
+}
+
+// percentile returns the p-th percentile of recent response times, false if there are too few of them.
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < minLatencySamples {
+		return 0, false
+	}
+	if w.cachedPercentile != p || w.added >= delayRecalcInterval || w.cached == 0 {
+		sorted := make([]time.Duration, len(w.samples))
+		copy(sorted, w.samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		w.cached = sorted[int(float64(len(sorted)-1)*p/100)]
+		w.cachedPercentile = p
+		w.added = 0
+	}
+	return w.cached, true
+}
+
+// shouldHedge returns true if the query can be sent to another server, which is only the case
+// for hedged methods called without a wallet.
+func (c *Caller) shouldHedge(q *Query) bool {
+	return c.AlternativeEndpoint != nil && q.WalletID == "" && c.Hedging != nil && c.Hedging.Hedges(q.Method())
+}
+
+// hedgeAttempt is one of the queries sent by sendHedged.
+type hedgeAttempt struct {
+	caller *Caller
+	hedged bool
+	cancel context.CancelFunc
+	// lost is set once the attempt is no longer needed because the other one has responded
+	lost int32
+}
+
+// abort cancels the attempt if it is still in flight, without counting it as a failure.
+func (a *hedgeAttempt) abort() {
+	atomic.StoreInt32(&a.lost, 1)
+	a.cancel()
+}
+
+func (a *hedgeAttempt) isLost() bool {
+	return atomic.LoadInt32(&a.lost) == 1
+}
+
+type hedgedResult struct {
+	attempt  *hedgeAttempt
+	res      *jsonrpc.RPCResponse
+	err      error
+	duration float64
+}
+
+// sendHedged sends the query to the caller endpoint and, if no response arrives within the hedging delay,
+// to an alternative endpoint as well. The first successful response is returned and the other query is cancelled.
+// Postflight hooks are only applied to the returned response.
+// Every attempt is sent by a caller of its own, so attempts still in flight don't share fields with c.
+func (c *Caller) sendHedged(ctx context.Context, q *Query) (*jsonrpc.RPCResponse, error) {
+	h := c.Hedging
+	results := make(chan hedgedResult, 2)
+	var attempts []*hedgeAttempt
+	send := func(cc *Caller, hedged bool) {
+		a := &hedgeAttempt{caller: cc, hedged: hedged}
+		actx, cancel := context.WithCancel(context.WithValue(ctx, hedgeAttemptKey, a))
+		a.cancel = cancel
+		attempts = append(attempts, a)
+		go func() {
+			req := *q.Request
+			start := time.Now()
+			res, err := cc.callSDK(actx, &Query{Request: &req, WalletID: q.WalletID})
+			if err == nil {
+				h.observe(q.Method(), time.Since(start))
+			}
+			results <- hedgedResult{a, res, err, cc.Duration}
+		}()
+	}
+	defer func() {
+		for _, a := range attempts {
+			a.abort()
+		}
+	}()
+
+	send(c.clone(), false)
+	timer := time.NewTimer(h.Delay(q.Method()))
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return c.hedgedResponse(ctx, q, r)
+	case <-timer.C:
+	}
+
+	endpoint := c.AlternativeEndpoint(c.endpoint)
+	if endpoint == "" || endpoint == c.endpoint {
+		return c.hedgedResponse(ctx, q, <-results)
+	}
+	logger.Log().Debugf("%v is slow to respond to %v, hedging to %v", c.endpoint, q.Method(), endpoint)
+	metrics.ProxyHedgeSentCount.WithLabelValues(q.Method()).Inc()
+	send(c.cloneTo(endpoint), true)
+
+	r := <-results
+	if r.err != nil {
+		// The other query might still succeed
+		r = <-results
+	}
+	return c.hedgedResponse(ctx, q, r)
+}
+
+// hedgedResponse applies postflight hooks of the caller that made the winning attempt to its response.
+func (c *Caller) hedgedResponse(ctx context.Context, q *Query, r hedgedResult) (*jsonrpc.RPCResponse, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	c.Duration = r.duration
+	if r.attempt.hedged {
+		metrics.ProxyHedgeWonCount.WithLabelValues(q.Method()).Inc()
+	}
+	return r.attempt.caller.postflight(ctx, q, r.res)
+}
+
+// cloneTo returns a caller for another endpoint with the same hooks and cache settings.
+// Postflight hooks are copied too, so queries sent by the clone on its own are processed like the original ones.
+func (c *Caller) cloneTo(endpoint string) *Caller {
+	cc := NewCaller(endpoint, c.userID)
+	cc.Cache = c.Cache
+	cc.CachePolicy = c.CachePolicy
+	cc.Preprocessor = c.Preprocessor
+	cc.CallerOptions = c.CallerOptions
+	cc.Router = c.Router
+	cc.preflightHooks = c.preflightHooks
+	cc.postflightHooks = c.postflightHooks
+	return cc
+}
@@ -0,0 +1,148 @@
+package query
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/metrics"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func newTestHedging(t *testing.T) *Hedging {
+	h, err := NewHedging(config.Hedging{
+		Methods: []string{MethodResolve, MethodClaimSearch}, Percentile: 90, MinDelay: 10, MaxDelay: 50,
+	})
+	require.NoError(t, err)
+	return h
+}
+
+func TestNewHedging(t *testing.T) {
+	h, err := NewHedging(config.Hedging{})
+	require.NoError(t, err)
+	assert.False(t, h.Hedges(MethodResolve))
+
+	h = newTestHedging(t)
+	assert.True(t, h.Hedges(MethodResolve))
+	assert.False(t, h.Hedges(MethodGet))
+
+	_, err = NewHedging(config.Hedging{Methods: []string{MethodResolve}, Percentile: 100, MaxDelay: 50})
+	assert.EqualError(t, err, "hedging percentile should be between 0 and 100")
+	_, err = NewHedging(config.Hedging{Methods: []string{MethodResolve}, Percentile: 95, MinDelay: 100, MaxDelay: 50})
+	assert.EqualError(t, err, "hedging MaxDelay should be positive and not less than MinDelay")
+	_, err = NewHedging(config.Hedging{Methods: []string{MethodWalletBalance}, Percentile: 95, MaxDelay: 50})
+	assert.EqualError(t, err, "wallet_balance requires a wallet and cannot be hedged")
+}
+
+func TestHedgingDelay(t *testing.T) {
+	h := newTestHedging(t)
+	assert.Equal(t, 50*time.Millisecond, h.Delay(MethodResolve), "MaxDelay should be used until response times are known")
+
+	for i := 1; i <= 100; i++ {
+		h.observe(MethodResolve, time.Duration(i)*time.Millisecond/2)
+	}
+	assert.Equal(t, 45*time.Millisecond, h.Delay(MethodResolve))
+
+	for i := 0; i < 100; i++ {
+		h.observe(MethodClaimSearch, time.Millisecond)
+		h.observe(MethodGet, time.Second)
+	}
+	assert.Equal(t, 10*time.Millisecond, h.Delay(MethodClaimSearch))
+	assert.Equal(t, 50*time.Millisecond, h.Delay(MethodGet))
+}
+
+func TestLatencyWindow(t *testing.T) {
+	w := &latencyWindow{}
+	for i := 0; i < minLatencySamples-1; i++ {
+		w.add(time.Second)
+	}
+	_, ok := w.percentile(50)
+	assert.False(t, ok)
+
+	for i := 0; i < latencySamples; i++ {
+		w.add(time.Millisecond)
+	}
+	assert.Len(t, w.samples, latencySamples, "only recent samples should be kept")
+	d, ok := w.percentile(99)
+	assert.True(t, ok)
+	assert.Equal(t, time.Millisecond, d)
+}
+
+func TestCaller_CallHedged(t *testing.T) {
+	h := newTestHedging(t)
+
+	slow, abandoned := newHangingServer()
+	defer slow.Close()
+	fast := test.MockHTTPServer(nil)
+	defer fast.Close()
+	go func() {
+		fast.NextResponse <- `{"jsonrpc": "2.0", "result": {"what": {"claim_id": "abc"}}, "id": 0}`
+	}()
+
+	won := testutil.ToFloat64(metrics.ProxyHedgeWonCount.WithLabelValues(MethodResolve))
+	canceled := testutil.ToFloat64(metrics.ProxyCallFailedCounter.WithLabelValues(MethodResolve, slow.URL, metrics.FailureKindCanceled))
+	c := NewCaller(slow.URL, 0)
+	c.Hedging = h
+	c.AlternativeEndpoint = func(exclude ...string) string {
+		assert.Equal(t, []string{slow.URL}, exclude)
+		return fast.URL
+	}
+	var hookCalls int32
+	c.AddPostflightHook(MethodResolve, func(_ *Caller, _ *HookContext) (*jsonrpc.RPCResponse, error) {
+		atomic.AddInt32(&hookCalls, 1)
+		return nil, nil
+	}, "")
+	start := time.Now()
+	res, err := c.Call(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.NoError(t, err)
+	require.Nil(t, res.Error)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	assert.Equal(t, won+1, testutil.ToFloat64(metrics.ProxyHedgeWonCount.WithLabelValues(MethodResolve)))
+	assert.Greater(t, c.Duration, 0.0, "duration of the winning query should be recorded")
+
+	select {
+	case <-abandoned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow query wasn't cancelled")
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&hookCalls), "postflight hooks should only run for the winning query")
+	// let the cancelled query finish
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, canceled, testutil.ToFloat64(metrics.ProxyCallFailedCounter.WithLabelValues(MethodResolve, slow.URL, metrics.FailureKindCanceled)),
+		"cancelled hedges should not count as failures")
+}
+
+func TestCaller_CallNotHedged(t *testing.T) {
+	h := newTestHedging(t)
+
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	alternative := func(exclude ...string) string {
+		t.Error("query should not be hedged")
+		return ""
+	}
+
+	// Responding before the hedging delay
+	srv.NextResponse <- `{"jsonrpc": "2.0", "result": {}, "id": 0}`
+	c := NewCaller(srv.URL, 0)
+	c.Hedging = h
+	c.AlternativeEndpoint = alternative
+	_, err := c.Call(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.NoError(t, err)
+
+	// Queries with a wallet must go to the server holding it
+	slow, _ := newHangingServer()
+	defer slow.Close()
+	c = NewCaller(slow.URL, 0)
+	c.Hedging = h
+	c.AlternativeEndpoint = alternative
+	q, err := NewQuery(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}), "lbrytv-id.1.wallet")
+	require.NoError(t, err)
+	assert.False(t, c.shouldHedge(q))
+}
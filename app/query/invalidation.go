@@ -0,0 +1,103 @@
+package query
+
+import (
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ybbus/jsonrpc"
+)
+
+// maxTagDepth limits how deep into the response cacheTags looks for claims.
+// It's enough to reach signing channels of reposted claims in claim_search results.
+const maxTagDepth = 6
+
+// invalidatingMethods change claims, so cached responses containing those claims
+// are invalidated after these methods succeed.
+var invalidatingMethods = []string{
+	"stream_update",
+	"channel_update",
+	"support_create",
+}
+
+// claimIDParams are params referring to claims that queries should be tagged with.
+var claimIDParams = []string{"claim_id", "claim_ids", ParamChannelID, "channel_ids"}
+
+// ClaimTag is the cache tag for responses containing the claim, which can be a stream or a channel.
+func ClaimTag(claimID string) string {
+	return "claim:" + claimID
+}
+
+// cacheTags returns tags for all claims referred to by the query or contained in its response.
+func cacheTags(q *Query, res *jsonrpc.RPCResponse) []string {
+	ids := claimIDs(q, res)
+	tags := make([]string, 0, len(ids))
+	for _, id := range ids {
+		tags = append(tags, ClaimTag(id))
+	}
+	return tags
+}
+
+// claimIDs returns IDs of all claims referred to by the query or contained in its response.
+func claimIDs(q *Query, res *jsonrpc.RPCResponse) []string {
+	ids := map[string]struct{}{}
+	params := q.ParamsAsMap()
+	for _, p := range claimIDParams {
+		switch v := params[p].(type) {
+		case string:
+			ids[v] = struct{}{}
+		case []interface{}:
+			for _, id := range v {
+				if id, ok := id.(string); ok {
+					ids[id] = struct{}{}
+				}
+			}
+		}
+	}
+	if res != nil {
+		collectClaimIDs(res.Result, ids, 0)
+	}
+	delete(ids, "")
+
+	list := make([]string, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+	return list
+}
+
+// collectClaimIDs walks a decoded JSON response looking for claim objects.
+func collectClaimIDs(v interface{}, ids map[string]struct{}, depth int) {
+	if depth > maxTagDepth {
+		return
+	}
+	switch val := v.(type) {
+	case []interface{}:
+		for _, i := range val {
+			collectClaimIDs(i, ids, depth+1)
+		}
+	case map[string]interface{}:
+		if id, ok := val["claim_id"].(string); ok {
+			ids[id] = struct{}{}
+		}
+		for k, i := range val {
+			// claim metadata doesn't contain other claims
+			if k != "value" {
+				collectClaimIDs(i, ids, depth+1)
+			}
+		}
+	}
+}
+
+// invalidateCache is a postflight hook removing cached responses related to the claims changed by the query.
+func invalidateCache(c *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
+	if c.Cache == nil || hctx.Response == nil || hctx.Response.Error != nil {
+		return nil, nil
+	}
+	var n int
+	for _, tag := range cacheTags(hctx.Query, hctx.Response) {
+		n += c.Cache.InvalidateTag(tag)
+	}
+	metrics.ProxyQueryCacheInvalidatedCount.WithLabelValues(hctx.Query.Method()).Add(float64(n))
+	logger.WithFields(logrus.Fields{"method": hctx.Query.Method(), "invalidated": n}).Debug("cache invalidated")
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+package query
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func TestCacheTags(t *testing.T) {
+	q, err := NewQuery(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{
+		"channel_ids": []interface{}{"chan1", "chan2"},
+		"claim_id":    "claim1",
+	}), "")
+	require.NoError(t, err)
+	res := &jsonrpc.RPCResponse{Result: map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{
+				"claim_id":        "claim2",
+				"signing_channel": map[string]interface{}{"claim_id": "chan1"},
+				"value":           map[string]interface{}{"claim_id": "ignored"},
+			},
+			map[string]interface{}{
+				"claim_id": "repost",
+				"reposted_claim": map[string]interface{}{
+					"claim_id":        "claim3",
+					"signing_channel": map[string]interface{}{"claim_id": "chan3"},
+				},
+			},
+		},
+		"page": 1,
+	}}
+
+	tags := cacheTags(q, res)
+	sort.Strings(tags)
+	assert.Equal(t, []string{
+		ClaimTag("chan1"), ClaimTag("chan2"), ClaimTag("chan3"),
+		ClaimTag("claim1"), ClaimTag("claim2"), ClaimTag("claim3"), ClaimTag("repost"),
+	}, tags)
+
+	q, err = NewQuery(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@nothing"}), "")
+	require.NoError(t, err)
+	assert.Empty(t, cacheTags(q, &jsonrpc.RPCResponse{Result: map[string]interface{}{"items": []interface{}{}}}))
+}
+
+func TestCaller_CallInvalidatesCache(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	qCache := cache.NewMemoryCache()
+	search := func() {
+		c := NewCaller(srv.URL, 0)
+		c.Cache = qCache
+		res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@invalidated"}))
+		require.NoError(t, err)
+		require.Nil(t, res.Error)
+	}
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [{"claim_id": "abc"}], "page": 1}}`
+	search()
+	<-reqChan
+	search()
+	assert.Len(t, reqChan, 0, "second search should come from the cache")
+	assert.Equal(t, 1, qCache.Count())
+
+	c := NewCaller(srv.URL, 1)
+	c.Cache = qCache
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "error": {"code": -32500, "message": "update failed"}}`
+	_, err := c.Call(jsonrpc.NewRequest("stream_update", map[string]interface{}{"claim_id": "abc"}))
+	require.NoError(t, err)
+	<-reqChan
+	assert.Equal(t, 1, qCache.Count(), "failed update should not invalidate the cache")
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"outputs": [{"claim_id": "abc"}]}}`
+	_, err = c.Call(jsonrpc.NewRequest("stream_update", map[string]interface{}{"claim_id": "abc"}))
+	require.NoError(t, err)
+	<-reqChan
+	assert.Equal(t, 0, qCache.Count())
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [{"claim_id": "abc"}], "page": 1}}`
+	search()
+	<-reqChan
+}
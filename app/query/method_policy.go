@@ -0,0 +1,273 @@
+package query
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync/atomic"
+
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/internal/errors"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// WalletRequired methods can only be called by authenticated users, wallet_id is added to them.
+	WalletRequired = "required"
+	// WalletOptional methods can be called by anyone, wallet_id is added to them when the user is authenticated.
+	WalletOptional = "optional"
+	// WalletNone methods are called without wallet_id.
+	WalletNone = "none"
+)
+
+// MethodRule declares how an SDK method can be called.
+type MethodRule struct {
+	// Allowed can be set to false to forbid a listed method, listed methods are allowed by default.
+	Allowed *bool `yaml:"allowed"`
+	// Wallet is required (default), optional or none.
+	Wallet          string   `yaml:"wallet"`
+	ForbiddenParams []string `yaml:"forbidden_params"`
+	// MaxParamSize limits the length of string and list params and the number of keys in object params.
+	MaxParamSize map[string]int `yaml:"max_param_size"`
+}
+
+// MethodPolicy lists SDK methods that can be called through the proxy, methods not listed are forbidden.
+type MethodPolicy struct {
+	// ForbiddenParams are forbidden for all methods.
+	ForbiddenParams []string               `yaml:"forbidden_params"`
+	Methods         map[string]*MethodRule `yaml:"methods"`
+}
+
+// defaultMethodPolicy is used unless MethodPolicyFile is set in the config.
+const defaultMethodPolicy = `
+forbidden_params: [account_id, new_sdk_server]
+methods:
+  blob_announce: {wallet: none}
+  status: {wallet: none}
+  transaction_show: {wallet: none}
+  stream_cost_estimate: {wallet: none}
+  comment_list: {wallet: none}
+  version: {wallet: none}
+  routing_table_get: {wallet: none}
+
+  get: {wallet: optional}
+  resolve: {wallet: optional}
+  claim_search: {wallet: optional}
+  comment_react_list: {wallet: optional}
+
+  purchase_create: {}
+  publish: {}
+
+  address_unused: {}
+  address_list: {}
+  address_is_mine: {}
+
+  account_list: {}
+  account_balance: {}
+  account_send: {}
+  account_max_address_gap: {}
+
+  channel_abandon: {}
+  channel_create: {}
+  channel_list: {}
+  channel_update: {}
+  channel_export: {}
+  channel_import: {}
+
+  comment_abandon: {}
+  comment_create: {}
+  comment_hide: {}
+  comment_update: {}
+  comment_react: {}
+  comment_pin: {}
+
+  claim_list: {}
+
+  stream_abandon: {}
+  stream_create: {}
+  stream_list: {}
+  stream_update: {}
+  stream_repost: {}
+
+  support_abandon: {}
+  support_create: {}
+  support_list: {}
+
+  sync_apply: {}
+  sync_hash: {}
+
+  preference_get: {}
+  preference_set: {}
+
+  purchase_list: {}
+
+  transaction_list: {}
+
+  txo_list: {}
+  txo_sum: {}
+  txo_plot: {}
+
+  utxo_list: {}
+  utxo_release: {}
+
+  wallet_list: {}
+  wallet_send: {}
+  wallet_balance: {}
+  wallet_encrypt: {}
+  wallet_decrypt: {}
+  wallet_lock: {}
+  wallet_unlock: {}
+  wallet_status: {}
+`
+
+var currentMethodPolicy atomic.Value
+
+func init() {
+	p, err := ParseMethodPolicy([]byte(defaultMethodPolicy))
+	if err != nil {
+		panic(err)
+	}
+	currentMethodPolicy.Store(p)
+}
+
+// ParseMethodPolicy reads a YAML method policy, returning an error if it's malformed.
+func ParseMethodPolicy(data []byte) (*MethodPolicy, error) {
+	p := &MethodPolicy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, errors.Prefix("error parsing method policy", err)
+	}
+	if len(p.Methods) == 0 {
+		return nil, errors.Err("method policy allows no methods")
+	}
+	for m, r := range p.Methods {
+		if r == nil {
+			r = &MethodRule{}
+			p.Methods[m] = r
+		}
+		switch r.Wallet {
+		case "":
+			r.Wallet = WalletRequired
+		case WalletRequired, WalletOptional, WalletNone:
+		default:
+			return nil, errors.Err("method %v has invalid wallet value: %v", m, r.Wallet)
+		}
+		for param, size := range r.MaxParamSize {
+			if size <= 0 {
+				return nil, errors.Err("method %v has non-positive max size for %v", m, param)
+			}
+		}
+	}
+	return p, nil
+}
+
+// LoadMethodPolicy replaces the current method policy with the one from the file at path.
+// The default policy is restored if path is empty.
+func LoadMethodPolicy(path string) error {
+	data := []byte(defaultMethodPolicy)
+	if path != "" {
+		var err error
+		data, err = ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Err(err)
+		}
+	}
+	p, err := ParseMethodPolicy(data)
+	if err != nil {
+		return err
+	}
+	currentMethodPolicy.Store(p)
+	return nil
+}
+
+// WatchMethodPolicy reloads the method policy from the file at path on every signal received from sigs,
+// returning when sigs is closed. The current policy is kept if the file cannot be loaded.
+func WatchMethodPolicy(path string, sigs <-chan os.Signal) {
+	for range sigs {
+		if err := LoadMethodPolicy(path); err != nil {
+			logger.Log().Errorf("method policy was not reloaded: %v", err)
+			continue
+		}
+		logger.Log().Infof("method policy reloaded from %v", path)
+	}
+}
+
+// CurrentMethodPolicy returns the method policy in effect.
+func CurrentMethodPolicy() *MethodPolicy {
+	return currentMethodPolicy.Load().(*MethodPolicy)
+}
+
+// rule returns the rule for the method, nil if the method is not allowed.
+func (p *MethodPolicy) rule(method string) *MethodRule {
+	r, ok := p.Methods[method]
+	if !ok || (r.Allowed != nil && !*r.Allowed) {
+		return nil
+	}
+	return r
+}
+
+// RequiresWallet returns true for methods that can only be called by authenticated users.
+// Methods that are not allowed require a wallet too.
+func (p *MethodPolicy) RequiresWallet(method string) bool {
+	r := p.rule(method)
+	return r == nil || r.Wallet == WalletRequired
+}
+
+// AcceptsWallet returns true for methods that wallet_id should be added to.
+func (p *MethodPolicy) AcceptsWallet(method string) bool {
+	r := p.rule(method)
+	return r != nil && r.Wallet != WalletNone
+}
+
+// MethodsWithWallet returns sorted names of allowed methods with the wallet value.
+func (p *MethodPolicy) MethodsWithWallet(wallet string) []string {
+	methods := []string{}
+	for m := range p.Methods {
+		if r := p.rule(m); r != nil && r.Wallet == wallet {
+			methods = append(methods, m)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Check returns an error if the query is not allowed by the policy.
+func (p *MethodPolicy) Check(q *Query) error {
+	r := p.rule(q.Method())
+	if r == nil {
+		return rpcerrors.NewMethodNotAllowedError(errors.Err("forbidden method"))
+	}
+
+	params := q.ParamsAsMap()
+	if params == nil {
+		return nil
+	}
+	for _, forbidden := range [][]string{p.ForbiddenParams, r.ForbiddenParams} {
+		for _, param := range forbidden {
+			if _, ok := params[param]; ok {
+				return rpcerrors.NewInvalidParamsError(fmt.Errorf("forbidden parameter supplied: %v", param))
+			}
+		}
+	}
+	for param, max := range r.MaxParamSize {
+		if size := paramSize(params[param]); size > max {
+			return rpcerrors.NewInvalidParamsError(fmt.Errorf("parameter %v is too large: %v > %v", param, size, max))
+		}
+	}
+	return nil
+}
+
+func paramSize(v interface{}) int {
+	switch val := v.(type) {
+	case string:
+		return len(val)
+	case []interface{}:
+		return len(val)
+	case []string:
+		return len(val)
+	case map[string]interface{}:
+		return len(val)
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package query
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/internal/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+const testMethodPolicy = `
+forbidden_params: [account_id]
+methods:
+  status: {wallet: none}
+  resolve:
+    wallet: optional
+    max_param_size: {urls: 2}
+  claim_search:
+    wallet: optional
+    forbidden_params: [include_is_my_output]
+  wallet_balance: {}
+  wallet_send: {allowed: false}
+`
+
+func writePolicy(t *testing.T, policy string) string {
+	path := filepath.Join(t.TempDir(), "method_policy.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(policy), 0644))
+	return path
+}
+
+func loadTestPolicy(t *testing.T, policy string) {
+	require.NoError(t, LoadMethodPolicy(writePolicy(t, policy)))
+	t.Cleanup(func() { require.NoError(t, LoadMethodPolicy("")) })
+}
+
+func TestParseMethodPolicy(t *testing.T) {
+	p, err := ParseMethodPolicy([]byte(defaultMethodPolicy))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"claim_search", "comment_react_list", "get", "resolve"}, p.MethodsWithWallet(WalletOptional))
+	assert.Contains(t, p.MethodsWithWallet(WalletNone), MethodStatus)
+	assert.Contains(t, p.MethodsWithWallet(WalletRequired), MethodWalletBalance)
+
+	_, err = ParseMethodPolicy([]byte("methods:\n  status: {wallet: sometimes}\n"))
+	assert.EqualError(t, err, "method status has invalid wallet value: sometimes")
+	_, err = ParseMethodPolicy([]byte("methods:\n  resolve: {max_param_size: {urls: 0}}\n"))
+	assert.EqualError(t, err, "method resolve has non-positive max size for urls")
+	_, err = ParseMethodPolicy([]byte("methods:\n  status: {walet: none}\n"))
+	assert.Error(t, err, "unknown fields should not be accepted")
+	_, err = ParseMethodPolicy([]byte("forbidden_params: []\n"))
+	assert.EqualError(t, err, "method policy allows no methods")
+}
+
+func TestNewQueryMethodPolicy(t *testing.T) {
+	loadTestPolicy(t, testMethodPolicy)
+
+	newQuery := func(method string, params interface{}, walletID string) error {
+		_, err := NewQuery(jsonrpc.NewRequest(method, params), walletID)
+		return err
+	}
+
+	assert.NoError(t, newQuery(MethodStatus, nil, ""))
+	assert.NoError(t, newQuery(MethodResolve, map[string]interface{}{"urls": []interface{}{"one", "two"}}, ""))
+	assert.NoError(t, newQuery(MethodWalletBalance, nil, "wallet"))
+
+	err := newQuery(MethodWalletSend, nil, "wallet")
+	assert.EqualError(t, err, "forbidden method")
+	var rpcErr rpcerrors.RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, rpcerrors.NewMethodNotAllowedError(nil).Code(), rpcErr.Code())
+	assert.EqualError(t, newQuery("stream_list", nil, "wallet"), "forbidden method")
+
+	assert.True(t, errors.Is(newQuery(MethodWalletBalance, nil, ""), rpcerrors.ErrAuthRequired))
+
+	assert.EqualError(t,
+		newQuery(MethodResolve, map[string]interface{}{"urls": []interface{}{"one", "two", "three"}}, ""),
+		"parameter urls is too large: 3 > 2")
+	assert.EqualError(t,
+		newQuery(MethodClaimSearch, map[string]interface{}{"include_is_my_output": true}, ""),
+		"forbidden parameter supplied: include_is_my_output")
+	assert.EqualError(t,
+		newQuery(MethodWalletBalance, map[string]interface{}{"account_id": "abc"}, "wallet"),
+		"forbidden parameter supplied: account_id")
+
+	q, err := NewQuery(jsonrpc.NewRequest(MethodStatus), "wallet")
+	require.NoError(t, err)
+	assert.Nil(t, q.Params(), "wallet_id should not be added to methods with wallet: none")
+}
+
+func TestWatchMethodPolicy(t *testing.T) {
+	path := writePolicy(t, testMethodPolicy)
+	require.NoError(t, LoadMethodPolicy(path))
+	defer LoadMethodPolicy("")
+
+	sigs := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		WatchMethodPolicy(path, sigs)
+		close(done)
+	}()
+
+	assert.True(t, MethodRequiresWallet(MethodWalletSend, nil))
+	require.NoError(t, ioutil.WriteFile(path, []byte("methods:\n  wallet_send: {wallet: optional}\n"), 0644))
+	sigs <- syscall.SIGHUP
+	assert.Eventually(t, func() bool { return !MethodRequiresWallet(MethodWalletSend, nil) }, time.Second, 10*time.Millisecond)
+
+	// broken policy should not replace the current one
+	require.NoError(t, ioutil.WriteFile(path, []byte("methods: ["), 0644))
+	sigs <- syscall.SIGHUP
+	close(sigs)
+	<-done
+	assert.False(t, MethodRequiresWallet(MethodWalletSend, nil))
+}
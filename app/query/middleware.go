@@ -0,0 +1,29 @@
+package query
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// OptionsFromRequest returns caller options added to the request by Middleware,
+// options with all features disabled if there are none.
+func OptionsFromRequest(r *http.Request) CallerOptions {
+	if v := r.Context().Value(optionsKey); v != nil {
+		return v.(CallerOptions)
+	}
+	return CallerOptions{}
+}
+
+func AddToRequest(opts CallerOptions, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fn(w, r.Clone(context.WithValue(r.Context(), optionsKey, opts)))
+	}
+}
+
+func Middleware(opts CallerOptions) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return AddToRequest(opts, next.ServeHTTP)
+	}
+}
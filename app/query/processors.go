@@ -1,14 +1,13 @@
 package query
 
 import (
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"fmt"
 	"regexp"
 	"time"
 
-	"github.com/lbryio/lbrytv-player/pkg/paid"
-	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/ip"
 	"github.com/lbryio/lbrytv/internal/metrics"
 
 	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
@@ -19,14 +18,20 @@ import (
 var reAlreadyPurchased = regexp.MustCompile(`(?i)you already have a purchase`)
 var rePurchaseFree = regexp.MustCompile(`(?i)does not have a purchase price`)
 
+// Receipts of new purchases take a moment to show up in resolve responses,
+// so resolve is retried with a growing interval until they do.
+const (
+	receiptPollInterval    = 250 * time.Millisecond
+	receiptPollMaxInterval = 2 * time.Second
+	receiptPollAttempts    = 4
+)
+
 // preflightHookGet will completely replace `get` request from the client with `purchase_create` + `resolve`.
 // This workaround is due to stability issues in the lbrynet SDK `get` method implementation.
 // Only `ParamStreamingUrl` will be returned, plus `purchase_receipt` if stream has been paid for.
+// Stream metadata and purchase receipts are kept in StreamCache so repeat views don't need any SDK queries.
+// Streaming URLs are made by the StreamURLBuilder of the caller.
 func preflightHookGet(caller *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
-	var (
-		urlSuffix, metricLabel string
-		isPaidStream           bool
-	)
 	query := hctx.Query
 
 	response := &jsonrpc.RPCResponse{
@@ -41,16 +46,72 @@ func preflightHookGet(caller *Caller, hctx *HookContext) (*jsonrpc.RPCResponse,
 	url := query.ParamsAsMap()["uri"].(string)
 	log := logger.Log().WithField("url", url)
 
-	claim, err := resolve(caller, query, url)
+	var claim *ljsonrpc.Claim
+	streams := caller.StreamCache
+	stream := streams.stream(url)
+	if stream == nil {
+		var err error
+		claim, err = resolve(hctx.Context(), caller, query, url)
+		if err != nil {
+			return nil, err
+		}
+		stream, err = newStreamInfo(claim)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		streams.saveStream(url, stream)
+	}
+
+	s := Stream{
+		Name:     stream.name,
+		ClaimID:  stream.claimID,
+		SdHash:   stream.sdHash,
+		Size:     stream.size,
+		RemoteIP: ip.FromContext(hctx.Context()),
+	}
+	if stream.fee.GetAmount() == 0 {
+		// Free streams don't need purchases so there's nothing else to query
+		metrics.LbrytvStreamRequests.WithLabelValues(metrics.LabelValueFree).Inc()
+	} else {
+		metrics.LbrytvStreamRequests.WithLabelValues(metrics.LabelValuePaid).Inc()
+		receipt, err := getPurchaseReceipt(caller, hctx, url, stream, claim)
+		if err != nil {
+			return nil, err
+		}
+		s.Txid = receipt.Txid
+		responseResult[ParamPurchaseReceipt] = receipt
+	}
+
+	streamURL, err := caller.streamURLBuilder().StreamURL(s)
 	if err != nil {
 		return nil, err
 	}
-	stream := claim.Value.GetStream()
+	responseResult[ParamStreamingUrl] = streamURL
+
+	response.Result = responseResult
+	return response, nil
+}
 
-	feeAmount := stream.GetFee().GetAmount()
-	if feeAmount > 0 {
-		isPaidStream = true
+// getPurchaseReceipt returns the receipt of the paid stream, purchasing it first if the user hasn't yet.
+// claim is the stream resolved for the current query, nil if its metadata came from StreamCache.
+func getPurchaseReceipt(caller *Caller, hctx *HookContext, url string, stream *streamInfo, claim *ljsonrpc.Claim) (*ljsonrpc.PurchaseReceipt, error) {
+	query := hctx.Query
+	log := logger.Log().WithField("url", url)
+	streams := caller.StreamCache
 
+	if receipt := streams.purchase(query.WalletID, stream.claimID); receipt != nil {
+		return receipt, nil
+	}
+	if claim == nil {
+		var err error
+		claim, err = resolve(hctx.Context(), caller, query, url)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if claim.PurchaseReceipt == nil {
 		purchaseQuery, err := NewQuery(jsonrpc.NewRequest(
 			MethodPurchaseCreate,
 			map[string]interface{}{
@@ -61,7 +122,7 @@ func preflightHookGet(caller *Caller, hctx *HookContext) (*jsonrpc.RPCResponse,
 		if err != nil {
 			return nil, err
 		}
-		purchaseRes, err := caller.SendQuery(purchaseQuery)
+		purchaseRes, err := caller.sendPurchase(hctx.Context(), purchaseQuery, url, stream.fee)
 		if err != nil {
 			return nil, err
 		}
@@ -74,58 +135,50 @@ func preflightHookGet(caller *Caller, hctx *HookContext) (*jsonrpc.RPCResponse,
 				return nil, fmt.Errorf("purchase error: %v", purchaseRes.Error.Message)
 			}
 		} else {
-			// Assuming the stream is of a paid variety and we have just paid for the stream
 			metrics.LbrytvPurchases.Inc()
-			metrics.LbrytvPurchaseAmounts.Observe(float64(feeAmount))
-			log.Infof("made a purchase for %d LBC", feeAmount)
-			// This is needed so changes can propagate for the subsequent resolve
-			time.Sleep(1 * time.Second)
-			claim, err = resolve(caller, query, url)
+			metrics.LbrytvPurchaseAmounts.Observe(float64(stream.fee.GetAmount()))
+			log.Infof("made a purchase for %d %v", stream.fee.GetAmount(), stream.fee.GetCurrency())
+			claim, err = pollPurchaseReceipt(hctx.Context(), caller, query, url)
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
 
-	if isPaidStream {
-		metricLabel = metrics.LabelValuePaid
-	} else {
-		metricLabel = metrics.LabelValueFree
-	}
-	metrics.LbrytvStreamRequests.WithLabelValues(metricLabel).Inc()
-
-	src := stream.GetSource()
-	if src == nil {
-		m := "stream doesn't have source data"
-		log.Error(m)
-		return nil, fmt.Errorf(m)
-	}
-	sdHash := hex.EncodeToString(src.SdHash)[:6]
-	if isPaidStream {
-		size := src.GetSize()
-		if claim.PurchaseReceipt == nil {
-			log.Error("stream was paid for but receipt not found in the resolve response")
-			return nil, fmt.Errorf("couldn't find purchase receipt for paid stream")
-		}
+	if claim.PurchaseReceipt == nil {
+		log.Error("stream was paid for but receipt not found in the resolve response")
+		return nil, fmt.Errorf("couldn't find purchase receipt for paid stream")
+	}
+	streams.savePurchase(query.WalletID, stream.claimID, claim.PurchaseReceipt)
+	return claim.PurchaseReceipt, nil
+}
 
-		log.Debugf("creating stream token with stream id=%s, txid=%s, size=%v", claim.Name+"/"+claim.ClaimID, claim.PurchaseReceipt.Txid, size)
-		token, err := paid.CreateToken(claim.Name+"/"+claim.ClaimID, claim.PurchaseReceipt.Txid, size, paid.ExpTenSecPer100MB)
+// pollPurchaseReceipt resolves a just purchased stream until its purchase receipt shows up
+// or receiptPollAttempts run out, returning the last resolved claim.
+func pollPurchaseReceipt(ctx context.Context, c *Caller, q *Query, url string) (*ljsonrpc.Claim, error) {
+	var claim *ljsonrpc.Claim
+	interval := receiptPollInterval
+	for i := 0; i < receiptPollAttempts; i++ {
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+		var err error
+		claim, err = resolve(ctx, c, q, url)
 		if err != nil {
 			return nil, err
 		}
-		urlSuffix = fmt.Sprintf("paid/%s/%s/%s/%s", claim.Name, claim.ClaimID, sdHash, token)
-		responseResult[ParamPurchaseReceipt] = claim.PurchaseReceipt
-	} else {
-		urlSuffix = fmt.Sprintf("free/%s/%s/%s", claim.Name, claim.ClaimID, sdHash)
+		if claim.PurchaseReceipt != nil {
+			break
+		}
+		interval *= 2
+		if interval > receiptPollMaxInterval {
+			interval = receiptPollMaxInterval
+		}
 	}
-
-	responseResult[ParamStreamingUrl] = config.Config.Viper.GetString("BaseContentURL") + urlSuffix
-
-	response.Result = responseResult
-	return response, nil
+	return claim, nil
 }
 
-func resolve(c *Caller, q *Query, url string) (*ljsonrpc.Claim, error) {
+func resolve(ctx context.Context, c *Caller, q *Query, url string) (*ljsonrpc.Claim, error) {
 	resolveQuery, err := NewQuery(jsonrpc.NewRequest(
 		MethodResolve,
 		map[string]interface{}{
@@ -138,7 +191,7 @@ func resolve(c *Caller, q *Query, url string) (*ljsonrpc.Claim, error) {
 		return nil, err
 	}
 
-	rawResolveResponse, err := c.SendQuery(resolveQuery)
+	rawResolveResponse, err := c.SendQueryContext(ctx, resolveQuery)
 	if err != nil {
 		return nil, err
 	}
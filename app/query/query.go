@@ -1,7 +1,6 @@
 package query
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/lbryio/lbrytv/app/rpcerrors"
@@ -22,6 +21,7 @@ type Query struct {
 // NewQuery initializes Query object with JSON-RPC request.
 // The object is immediately usable and returns an error in case request parsing fails.
 // If walletID is not empty, it will be added as a param to the query when the Caller calls it.
+// The query is checked against the current method policy and its params are validated against the method schema.
 func NewQuery(req *jsonrpc.RPCRequest, walletID string) (*Query, error) {
 	if strings.TrimSpace(req.Method) == "" {
 		return nil, errors.Err("no method in request")
@@ -29,19 +29,12 @@ func NewQuery(req *jsonrpc.RPCRequest, walletID string) (*Query, error) {
 
 	q := &Query{Request: req, WalletID: walletID}
 
-	if !methodInList(q.Method(), relaxedMethods) && !methodInList(q.Method(), walletSpecificMethods) {
-		return nil, rpcerrors.NewMethodNotAllowedError(errors.Err("forbidden method"))
+	policy := CurrentMethodPolicy()
+	if err := policy.Check(q); err != nil {
+		return nil, err
 	}
 
-	if q.ParamsAsMap() != nil {
-		for _, p := range forbiddenParams {
-			if _, ok := q.ParamsAsMap()[p]; ok {
-				return nil, rpcerrors.NewInvalidParamsError(fmt.Errorf("forbidden parameter supplied: %v", p))
-			}
-		}
-	}
-
-	if MethodAcceptsWallet(q.Method()) {
+	if policy.AcceptsWallet(q.Method()) {
 		if q.IsAuthenticated() {
 			if p := q.ParamsAsMap(); p != nil {
 				p[ParamWalletID] = q.WalletID
@@ -49,11 +42,15 @@ func NewQuery(req *jsonrpc.RPCRequest, walletID string) (*Query, error) {
 			} else {
 				q.Request.Params = map[string]interface{}{ParamWalletID: q.WalletID}
 			}
-		} else if MethodRequiresWallet(q.Method(), q.Params()) {
+		} else if policy.RequiresWallet(q.Method()) {
 			return nil, rpcerrors.NewAuthRequiredError()
 		}
 	}
 
+	if err := validateParams(q); err != nil {
+		return nil, err
+	}
+
 	return q, nil
 }
 
@@ -100,14 +97,14 @@ func (q *Query) newResponse() *jsonrpc.RPCResponse {
 	}
 }
 
-// MethodRequiresWallet returns true for methods that require wallet_id
+// MethodRequiresWallet returns true for methods that require wallet_id according to the current method policy.
 func MethodRequiresWallet(method string, params interface{}) bool {
-	return !methodInList(method, relaxedMethods)
+	return CurrentMethodPolicy().RequiresWallet(method)
 }
 
-// MethodAcceptsWallet returns true for methods that can accept wallet_id
+// MethodAcceptsWallet returns true for methods that can accept wallet_id according to the current method policy.
 func MethodAcceptsWallet(method string) bool {
-	return methodInList(method, walletSpecificMethods)
+	return CurrentMethodPolicy().AcceptsWallet(method)
 }
 
 func methodInList(method string, checkMethods []string) bool {
@@ -49,27 +49,33 @@ func TestQueryCopyParamsAsMap(t *testing.T) {
 }
 
 func TestQueryIsAuthenticated(t *testing.T) {
-	q, err := NewQuery(jsonrpc.NewRequest("resolve"), "12345")
+	q, err := NewQuery(validRequest(MethodResolve), "12345")
 	require.NoError(t, err)
 	assert.True(t, q.IsAuthenticated())
 
-	q, err = NewQuery(jsonrpc.NewRequest("resolve"), "")
+	q, err = NewQuery(validRequest(MethodResolve), "")
 	require.NoError(t, err)
 	assert.False(t, q.IsAuthenticated())
 }
 
 func TestMethodRequiresWallet(t *testing.T) {
-	for _, m := range walletSpecificMethods {
-		if methodInList(m, relaxedMethods) {
-			assert.False(t, MethodRequiresWallet(m, nil), m)
-		} else {
-			assert.True(t, MethodRequiresWallet(m, nil), m)
-		}
+	policy := CurrentMethodPolicy()
+	for _, m := range policy.MethodsWithWallet(WalletRequired) {
+		assert.True(t, MethodRequiresWallet(m, nil), m)
 	}
+	for _, m := range append(policy.MethodsWithWallet(WalletOptional), policy.MethodsWithWallet(WalletNone)...) {
+		assert.False(t, MethodRequiresWallet(m, nil), m)
+	}
+	assert.True(t, MethodRequiresWallet("unknown_method", nil))
 }
 
 func TestMethodAcceptsWallet(t *testing.T) {
-	for _, m := range walletSpecificMethods {
+	policy := CurrentMethodPolicy()
+	for _, m := range append(policy.MethodsWithWallet(WalletRequired), policy.MethodsWithWallet(WalletOptional)...) {
 		assert.True(t, MethodAcceptsWallet(m), m)
 	}
+	for _, m := range policy.MethodsWithWallet(WalletNone) {
+		assert.False(t, MethodAcceptsWallet(m), m)
+	}
+	assert.False(t, MethodAcceptsWallet("unknown_method"))
 }
@@ -0,0 +1,163 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const (
+	maxResolveURLs     = 1000
+	maxSearchPageSize  = 50
+	maxSearchListItems = 500
+)
+
+var claimTypes = []interface{}{"stream", "channel", "repost", "collection"}
+
+// paramSchemas describe params of SDK methods, queries with params not matching them are not sent to the SDK.
+// Only params known to be used by clients are described, other params are passed to the SDK as is.
+var paramSchemas = map[string]*openapi3.Schema{
+	MethodResolve: paramsSchema([]string{ParamUrls}, map[string]*openapi3.Schema{
+		ParamUrls:                  stringOrList(maxResolveURLs),
+		"include_purchase_receipt": openapi3.NewBoolSchema(),
+		"include_is_my_output":     openapi3.NewBoolSchema(),
+		"include_sent_supports":    openapi3.NewBoolSchema(),
+		"include_sent_tips":        openapi3.NewBoolSchema(),
+		"include_received_tips":    openapi3.NewBoolSchema(),
+		"include_protobuf":         openapi3.NewBoolSchema(),
+		"include_signing_channels": openapi3.NewBoolSchema(),
+	}),
+	MethodClaimSearch: paramsSchema(nil, map[string]*openapi3.Schema{
+		"page":                     openapi3.NewIntegerSchema().WithMin(1),
+		"page_size":                openapi3.NewIntegerSchema().WithMin(1).WithMax(maxSearchPageSize),
+		"claim_type":               enumOrList(claimTypes...),
+		"claim_id":                 openapi3.NewStringSchema(),
+		"claim_ids":                list(maxSearchListItems),
+		"channel":                  openapi3.NewStringSchema(),
+		ParamChannelID:             openapi3.NewStringSchema(),
+		"channel_ids":              list(maxSearchListItems),
+		"not_channel_ids":          list(maxSearchListItems),
+		"any_tags":                 list(maxSearchListItems),
+		"all_tags":                 list(maxSearchListItems),
+		"not_tags":                 list(maxSearchListItems),
+		"any_languages":            list(maxSearchListItems),
+		"order_by":                 stringOrList(maxSearchListItems),
+		"no_totals":                openapi3.NewBoolSchema(),
+		"has_source":               openapi3.NewBoolSchema(),
+		"include_purchase_receipt": openapi3.NewBoolSchema(),
+		"include_is_my_output":     openapi3.NewBoolSchema(),
+	}),
+	MethodGet: paramsSchema([]string{"uri"}, map[string]*openapi3.Schema{
+		"uri":       openapi3.NewStringSchema().WithMinLength(1),
+		"save_file": openapi3.NewBoolSchema(),
+		"timeout":   openapi3.NewIntegerSchema().WithMin(0),
+	}),
+	"stream_cost_estimate": paramsSchema([]string{"uri"}, map[string]*openapi3.Schema{
+		"uri": openapi3.NewStringSchema().WithMinLength(1),
+	}),
+	"transaction_show": paramsSchema([]string{"txid"}, map[string]*openapi3.Schema{
+		"txid": openapi3.NewStringSchema().WithMinLength(1),
+	}),
+	"comment_list": paramsSchema(nil, map[string]*openapi3.Schema{
+		"claim_id":  openapi3.NewStringSchema(),
+		"page":      openapi3.NewIntegerSchema().WithMin(1),
+		"page_size": openapi3.NewIntegerSchema().WithMin(1).WithMax(maxSearchPageSize),
+	}),
+	MethodWalletSend: paramsSchema([]string{"amount", "addresses"}, map[string]*openapi3.Schema{
+		"amount":    openapi3.NewStringSchema().WithMinLength(1),
+		"addresses": stringOrList(maxSearchListItems),
+	}),
+	MethodAccountSend: paramsSchema([]string{"amount", "addresses"}, map[string]*openapi3.Schema{
+		"amount":    openapi3.NewStringSchema().WithMinLength(1),
+		"addresses": stringOrList(maxSearchListItems),
+	}),
+	"support_create": paramsSchema([]string{"claim_id", "amount"}, map[string]*openapi3.Schema{
+		"claim_id": openapi3.NewStringSchema().WithMinLength(1),
+		"amount":   openapi3.NewStringSchema().WithMinLength(1),
+		"tip":      openapi3.NewBoolSchema(),
+	}),
+	"stream_update": paramsSchema([]string{"claim_id"}, map[string]*openapi3.Schema{
+		"claim_id": openapi3.NewStringSchema().WithMinLength(1),
+		"tags":     stringOrList(maxSearchListItems),
+	}),
+	"channel_update": paramsSchema([]string{"claim_id"}, map[string]*openapi3.Schema{
+		"claim_id": openapi3.NewStringSchema().WithMinLength(1),
+		"tags":     stringOrList(maxSearchListItems),
+	}),
+	"channel_create": paramsSchema([]string{"name", "bid"}, map[string]*openapi3.Schema{
+		"name": openapi3.NewStringSchema().WithMinLength(1),
+		"bid":  openapi3.NewStringSchema().WithMinLength(1),
+		"tags": stringOrList(maxSearchListItems),
+	}),
+}
+
+func paramsSchema(required []string, properties map[string]*openapi3.Schema) *openapi3.Schema {
+	s := openapi3.NewObjectSchema().WithProperties(properties)
+	s.Required = required
+	return s
+}
+
+func list(maxItems int64) *openapi3.Schema {
+	return openapi3.NewArraySchema().WithItems(openapi3.NewStringSchema()).WithMaxItems(maxItems)
+}
+
+// stringOrList is for params accepting a single string as well as a list of them.
+func stringOrList(maxItems int64) *openapi3.Schema {
+	s := openapi3.NewAnyOfSchema(openapi3.NewStringSchema(), list(maxItems))
+	s.Description = fmt.Sprintf("a string or a list of at most %v strings", maxItems)
+	return s
+}
+
+// enumOrList is for params accepting one or more of enumerated values.
+func enumOrList(values ...interface{}) *openapi3.Schema {
+	item := openapi3.NewStringSchema().WithEnum(values...)
+	s := openapi3.NewAnyOfSchema(item, openapi3.NewArraySchema().WithItems(item))
+	s.Description = fmt.Sprintf("one or a list of %v", values)
+	return s
+}
+
+// validateParams checks query params against the schema of its method, if there is one.
+func validateParams(q *Query) error {
+	schema, ok := paramSchemas[q.Method()]
+	if !ok {
+		return nil
+	}
+
+	// Params are validated in their JSON form so they look the same regardless of where the query came from
+	var params interface{} = map[string]interface{}{}
+	if q.Params() != nil {
+		s, err := json.Marshal(q.Params())
+		if err != nil {
+			return rpcerrors.NewInvalidParamsError(err)
+		}
+		if err := json.Unmarshal(s, &params); err != nil {
+			return rpcerrors.NewInvalidParamsError(err)
+		}
+	}
+	if _, ok := params.(map[string]interface{}); !ok {
+		return rpcerrors.NewInvalidParamsError(fmt.Errorf("params of %v should be an object", q.Method()))
+	}
+
+	err := schema.VisitJSON(params)
+	if err == nil {
+		return nil
+	}
+	schemaErr, ok := err.(*openapi3.SchemaError)
+	if !ok {
+		return rpcerrors.NewInvalidParamsError(err)
+	}
+	field := strings.Join(schemaErr.JSONPointer(), ".")
+	switch {
+	case schemaErr.SchemaField == "required":
+		return rpcerrors.NewInvalidParamsError(fmt.Errorf("missing required parameter: %v", field))
+	case schemaErr.Schema != nil && schemaErr.Schema.Description != "":
+		return rpcerrors.NewInvalidParamsError(fmt.Errorf("invalid parameter %v: should be %v", field, schemaErr.Schema.Description))
+	case schemaErr.Reason != "":
+		return rpcerrors.NewInvalidParamsError(fmt.Errorf("invalid parameter %v: %v", field, strings.ToLower(schemaErr.Reason[:1])+schemaErr.Reason[1:]))
+	}
+	return rpcerrors.NewInvalidParamsError(fmt.Errorf("invalid parameter %v: doesn't match %v", field, schemaErr.SchemaField))
+}
@@ -0,0 +1,86 @@
+package query
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/internal/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+// validRequest returns a request with minimal params passing validation for methods with required params
+// and a request without params for other methods.
+func validRequest(method string) *jsonrpc.RPCRequest {
+	switch method {
+	case MethodResolve:
+		return jsonrpc.NewRequest(method, map[string]interface{}{ParamUrls: "what"})
+	case MethodGet, "stream_cost_estimate":
+		return jsonrpc.NewRequest(method, map[string]interface{}{"uri": "what"})
+	case "transaction_show":
+		return jsonrpc.NewRequest(method, map[string]interface{}{"txid": "abcdef"})
+	}
+	return jsonrpc.NewRequest(method)
+}
+
+func TestValidateParams(t *testing.T) {
+	urls := make([]interface{}, maxResolveURLs+1)
+	for i := range urls {
+		urls[i] = "lbry://what"
+	}
+
+	cases := []struct {
+		method string
+		params interface{}
+		err    string
+	}{
+		{MethodResolve, map[string]interface{}{ParamUrls: "what"}, ""},
+		{MethodResolve, map[string]interface{}{ParamUrls: []string{"one", "two"}, "include_is_my_output": true}, ""},
+		{MethodResolve, nil, "missing required parameter: urls"},
+		{MethodResolve, []string{"one"}, "params of resolve should be an object"},
+		{MethodResolve, map[string]interface{}{ParamUrls: 1}, "invalid parameter urls: should be a string or a list of at most 1000 strings"},
+		{MethodResolve, map[string]interface{}{ParamUrls: urls}, "invalid parameter urls: should be a string or a list of at most 1000 strings"},
+		{MethodResolve, map[string]interface{}{ParamUrls: "what", "include_protobuf": "yes"}, "invalid parameter include_protobuf: field must be set to boolean or not be present"},
+
+		{MethodClaimSearch, nil, ""},
+		{MethodClaimSearch, map[string]interface{}{"page": 2, "page_size": maxSearchPageSize, "claim_type": []string{"stream", "repost"}}, ""},
+		{MethodClaimSearch, map[string]interface{}{"page_size": maxSearchPageSize + 1}, "invalid parameter page_size: number must be most 50"},
+		{MethodClaimSearch, map[string]interface{}{"page": 1.5}, "invalid parameter page: value must be an integer"},
+		{MethodClaimSearch, map[string]interface{}{"claim_type": "video"}, "invalid parameter claim_type: should be one or a list of [stream channel repost collection]"},
+		{MethodClaimSearch, map[string]interface{}{"any_tags": []interface{}{"one", 2}}, "invalid parameter any_tags.1: field must be set to string or not be present"},
+
+		{MethodGet, map[string]interface{}{"uri": ""}, "invalid parameter uri: minimum string length is 1"},
+		{"support_create", map[string]interface{}{"claim_id": "abc"}, "missing required parameter: amount"},
+		{"version", []string{"anything"}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.method, func(t *testing.T) {
+			req := jsonrpc.NewRequest(c.method)
+			if c.params != nil {
+				req = jsonrpc.NewRequest(c.method, c.params)
+			}
+			err := validateParams(&Query{Request: req})
+			if c.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, c.err, err.Error())
+			var rpcErr rpcerrors.RPCError
+			require.True(t, errors.As(err, &rpcErr))
+			assert.Equal(t, rpcerrors.NewInvalidParamsError(nil).Code(), rpcErr.Code())
+		})
+	}
+}
+
+func TestNewQueryValidatesParams(t *testing.T) {
+	_, err := NewQuery(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{ParamUrls: []string{"what", ""}}), "")
+	assert.NoError(t, err)
+
+	_, err = NewQuery(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"page_size": "twenty"}), "")
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "invalid parameter page_size"))
+}
@@ -0,0 +1,204 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+	pb "github.com/lbryio/types/v2/go"
+	"github.com/ybbus/jsonrpc"
+)
+
+// SpendingGuard enforces spending limits of users, see the spending package.
+type SpendingGuard interface {
+	// Reserve records a spending of amount deweys by the user with the method,
+	// returning an error instead if it would take the user over one of their limits.
+	// release undoes the spending if the SDK hasn't gone through with it.
+	Reserve(ctx context.Context, userID int, method string, amount uint64) (release func(), err error)
+}
+
+// guarded returns true if queries of the caller are checked against spending limits,
+// which is when it has a guard and queries are made for a user.
+func (c *Caller) guarded() bool {
+	return c.Spending != nil && c.userID != 0
+}
+
+// reserveSpending reserves a spending with the guard, if the caller is guarded.
+func (c *Caller) reserveSpending(ctx context.Context, method string, amount uint64) (func(), error) {
+	if !c.guarded() || amount == 0 {
+		return func() {}, nil
+	}
+	return c.Spending.Reserve(ctx, c.userID, method, amount)
+}
+
+// sendSpending sends the query spending amount once the spending is reserved.
+// The spending is released if the SDK refuses the query or it doesn't reach the SDK at all,
+// but not if it's unknown whether the SDK went through with it, e.g. when the query times out.
+func (c *Caller) sendSpending(ctx context.Context, q *Query, amount uint64) (*jsonrpc.RPCResponse, error) {
+	release, err := c.reserveSpending(ctx, q.Method(), amount)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.SendQueryContext(ctx, q)
+	if (err == nil && res.Error != nil) || isTransportError(err) {
+		release()
+	}
+	return res, err
+}
+
+// sendPurchase sends the purchase_create query for the stream at url with the fee,
+// reserving the LBC price of the stream first if the caller is guarded.
+func (c *Caller) sendPurchase(ctx context.Context, q *Query, url string, fee *pb.Fee) (*jsonrpc.RPCResponse, error) {
+	var price uint64
+	if c.guarded() {
+		var err error
+		price, err = c.purchasePrice(ctx, q, url, fee)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.sendSpending(ctx, q, price)
+}
+
+// purchasePrice returns the amount of deweys the SDK charges for purchasing the stream at url with the fee.
+// LBC fees are stored in deweys already. USD (in cents) and BTC fees are converted by the SDK
+// at its exchange rates, so their price is requested with stream_cost_estimate.
+// Fees in other currencies are rejected as their price is not known.
+func (c *Caller) purchasePrice(ctx context.Context, q *Query, url string, fee *pb.Fee) (uint64, error) {
+	if fee.GetAmount() == 0 {
+		return 0, nil
+	}
+	switch fee.GetCurrency() {
+	case pb.Fee_LBC:
+		return fee.GetAmount(), nil
+	case pb.Fee_USD, pb.Fee_BTC:
+		return c.estimateStreamCost(ctx, q, url)
+	default:
+		return 0, fmt.Errorf("unsupported fee currency %v", fee.GetCurrency())
+	}
+}
+
+// estimateStreamCost asks the SDK how many deweys the stream at url costs.
+func (c *Caller) estimateStreamCost(ctx context.Context, q *Query, url string) (uint64, error) {
+	estimateQuery, err := NewQuery(jsonrpc.NewRequest("stream_cost_estimate", map[string]interface{}{"uri": url}), q.WalletID)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.SendQueryContext(ctx, estimateQuery)
+	if err != nil {
+		return 0, err
+	}
+	if res.Error != nil {
+		return 0, fmt.Errorf("couldn't estimate stream cost: %v", res.Error.Message)
+	}
+	estimate, ok := res.Result.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("unexpected stream cost estimate %v", res.Result)
+	}
+	v, err := estimate.Float64()
+	if err != nil || v < 0 || v*100000000 >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid stream cost estimate %v", estimate)
+	}
+	return uint64(math.Round(v * 100000000)), nil
+}
+
+// preflightHookSend checks wallet_send and account_send queries against spending limits before sending them.
+func preflightHookSend(c *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
+	if c.Spending == nil {
+		return nil, nil
+	}
+	// amount is validated to be a string by the wallet_send and account_send schemas
+	amount, _ := hctx.Query.ParamsAsMap()["amount"].(string)
+	deweys, err := parseLBC(amount)
+	if err != nil {
+		return nil, err
+	}
+	return c.sendSpending(hctx.Context(), hctx.Query, deweys)
+}
+
+// preflightHookPurchaseCreate checks purchase_create queries against spending limits before sending them.
+// The purchase amount is the price of the claim, which is resolved first.
+func preflightHookPurchaseCreate(c *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
+	if c.Spending == nil {
+		return nil, nil
+	}
+	claim, err := purchasedClaim(hctx.Context(), c, hctx.Query)
+	if err != nil {
+		return nil, err
+	}
+	url, _ := hctx.Query.ParamsAsMap()["url"].(string)
+	if url == "" {
+		url = claim.PermanentURL
+	}
+	return c.sendPurchase(hctx.Context(), hctx.Query, url, claim.Value.GetStream().GetFee())
+}
+
+// purchasedClaim finds the claim purchase_create is called for, by either its url or claim_id param.
+func purchasedClaim(ctx context.Context, c *Caller, q *Query) (*ljsonrpc.Claim, error) {
+	params := q.ParamsAsMap()
+	if url, ok := params["url"].(string); ok && url != "" {
+		return resolve(ctx, c, q, url)
+	}
+	claimID, ok := params["claim_id"].(string)
+	if !ok || claimID == "" {
+		return nil, fmt.Errorf("either url or claim_id should be provided")
+	}
+	searchQuery, err := NewQuery(jsonrpc.NewRequest(
+		MethodClaimSearch,
+		map[string]interface{}{
+			"claim_id":         claimID,
+			"include_protobuf": true,
+		},
+	), q.WalletID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.SendQueryContext(ctx, searchQuery)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("couldn't find claim: %v", res.Error.Message)
+	}
+	var search ljsonrpc.ClaimSearchResponse
+	if err := ljsonrpc.Decode(res.Result, &search); err != nil {
+		return nil, err
+	}
+	if len(search.Claims) == 0 {
+		return nil, fmt.Errorf("couldn't find claim")
+	}
+	return &search.Claims[0], nil
+}
+
+// parseLBC converts an LBC amount like "1.5" into deweys.
+func parseLBC(amount string) (uint64, error) {
+	whole, frac := amount, ""
+	if i := strings.IndexByte(amount, '.'); i >= 0 {
+		whole, frac = amount[:i], amount[i+1:]
+	}
+	if len(frac) > 8 {
+		return 0, fmt.Errorf("invalid amount %v: too many decimal places", amount)
+	}
+	frac += strings.Repeat("0", 8-len(frac))
+	if whole == "" && frac == "00000000" {
+		return 0, fmt.Errorf("invalid amount %v", amount)
+	} else if whole == "" {
+		whole = "0"
+	}
+	w, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %v", amount)
+	}
+	f, err := strconv.ParseUint(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %v", amount)
+	}
+	if w > (1<<64-1-f)/100000000 {
+		return 0, fmt.Errorf("invalid amount %v", amount)
+	}
+	return w*100000000 + f, nil
+}
@@ -0,0 +1,220 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	pb "github.com/lbryio/types/v2/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+var errTestLimit = errors.Base("limit reached")
+
+// testGuard allows spendings until their total reaches the limit.
+type testGuard struct {
+	mu       sync.Mutex
+	limit    uint64
+	spent    uint64
+	reserved []string
+	released int
+}
+
+func (g *testGuard) Reserve(_ context.Context, userID int, method string, amount uint64) (func(), error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.spent+amount > g.limit {
+		return nil, rpcerrors.NewSpendingLimitError(errTestLimit)
+	}
+	g.spent += amount
+	g.reserved = append(g.reserved, method)
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.spent -= amount
+		g.released++
+	}, nil
+}
+
+func TestParseLBC(t *testing.T) {
+	for amount, deweys := range map[string]uint64{
+		"1":          100000000,
+		"6.49999000": 649999000,
+		"0.00000001": 1,
+		".5":         50000000,
+		"250.0":      25000000000,
+	} {
+		d, err := parseLBC(amount)
+		require.NoError(t, err, amount)
+		assert.Equal(t, deweys, d, amount)
+	}
+	for _, amount := range []string{"", "-1", "1.000000001", "abc", "1.2.3", "184467440738"} {
+		_, err := parseLBC(amount)
+		assert.Error(t, err, amount)
+	}
+}
+
+func TestCaller_WalletSendSpendingLimit(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	g := &testGuard{limit: 10 * 100000000}
+	c := NewCaller(srv.URL, 123)
+	c.Spending = g
+	send := func(amount string) (*jsonrpc.RPCResponse, error) {
+		return c.Call(jsonrpc.NewRequest(MethodWalletSend, map[string]interface{}{"addresses": []string{"bXyz"}, "amount": amount}))
+	}
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "result": {"txid": "abc"}, "id": 0}`
+	res, err := send("6.5")
+	require.NoError(t, err)
+	require.Nil(t, res.Error)
+	<-reqChan
+	assert.Equal(t, uint64(650000000), g.spent)
+
+	_, err = send("4")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errTestLimit))
+	assert.Equal(t, -32090, rpcerrors.ToResponse(err, 0).Error.Code)
+	assert.Len(t, reqChan, 0, "queries over the limit should not reach the SDK")
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "error": {"code": -32500, "message": "Not enough funds"}, "id": 0}`
+	res, err = send("3.5")
+	require.NoError(t, err)
+	require.NotNil(t, res.Error)
+	<-reqChan
+	assert.Equal(t, uint64(650000000), g.spent, "spendings refused by the SDK should be released")
+	assert.Equal(t, 1, g.released)
+
+	assert.Equal(t, []string{MethodWalletSend, MethodWalletSend}, g.reserved)
+}
+
+func TestCaller_AccountSendSpendingLimit(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	g := &testGuard{limit: 10 * 100000000}
+	c := NewCaller(srv.URL, 123)
+	c.Spending = g
+	send := func(amount string) (*jsonrpc.RPCResponse, error) {
+		return c.Call(jsonrpc.NewRequest(MethodAccountSend, map[string]interface{}{"addresses": []string{"bXyz"}, "amount": amount}))
+	}
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "result": {"txid": "abc"}, "id": 0}`
+	res, err := send("6.5")
+	require.NoError(t, err)
+	require.Nil(t, res.Error)
+	<-reqChan
+	assert.Equal(t, uint64(650000000), g.spent)
+
+	_, err = send("4")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errTestLimit))
+	assert.Len(t, reqChan, 0, "queries over the limit should not reach the SDK")
+	assert.Equal(t, []string{MethodAccountSend}, g.reserved)
+}
+
+func TestCaller_WalletSendSpendingReleasedOnNetworkError(t *testing.T) {
+	g := &testGuard{limit: 10 * 100000000}
+	c := NewCaller(newDeadServer(), 123)
+	c.Spending = g
+	_, err := c.Call(jsonrpc.NewRequest(MethodWalletSend, map[string]interface{}{"addresses": []string{"bXyz"}, "amount": "1"}))
+	require.Error(t, err)
+	assert.Equal(t, 1, g.released)
+	assert.Equal(t, uint64(0), g.spent)
+}
+
+func TestCaller_PurchaseCreateSpendingLimit(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	uri := "Body-Language---Robert-F.-Kennedy-Assassination---Hypnosis#d66f8ba85c85ca48daba9183bd349307fe30cb43"
+	g := &testGuard{limit: 300 * 100000000}
+	c := NewCaller(srv.URL, 123)
+	c.Spending = g
+	purchase := func() (*jsonrpc.RPCResponse, error) {
+		return c.Call(jsonrpc.NewRequest(MethodPurchaseCreate, map[string]interface{}{"url": uri}))
+	}
+
+	srv.QueueResponses(resolveResponseWithoutPurchase, purchaseCreateResponse)
+	res, err := purchase()
+	require.NoError(t, err)
+	require.Nil(t, res.Error)
+	assert.Equal(t, MethodResolve, test.StrToReq(t, (<-reqChan).Body).Method)
+	assert.Equal(t, MethodPurchaseCreate, test.StrToReq(t, (<-reqChan).Body).Method)
+	assert.Equal(t, uint64(250*100000000), g.spent)
+
+	srv.QueueResponses(resolveResponseWithoutPurchase)
+	_, err = purchase()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errTestLimit))
+	<-reqChan
+	assert.Len(t, reqChan, 0, "purchases over the limit should not reach the SDK")
+}
+
+func TestCaller_GetSpendingLimit(t *testing.T) {
+	config.Override("BaseContentURL", "https://cdn.lbryplayer.xyz/api/v3/streams/")
+	defer config.RestoreOverridden()
+
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+	srv.QueueResponses(resolveResponseWithoutPurchase)
+
+	uri := "Body-Language---Robert-F.-Kennedy-Assassination---Hypnosis#d66f8ba85c85ca48daba9183bd349307fe30cb43"
+	c := NewCaller(srv.URL, 123)
+	c.Spending = &testGuard{limit: 100 * 100000000}
+	_, err := c.Call(jsonrpc.NewRequest(MethodGet, map[string]interface{}{"uri": uri}))
+	require.Error(t, err)
+	assert.Equal(t, -32090, rpcerrors.ToResponse(err, 0).Error.Code)
+	assert.Equal(t, MethodResolve, test.StrToReq(t, (<-reqChan).Body).Method)
+	assert.Len(t, reqChan, 0, "streams over the limit should not be purchased")
+}
+
+func TestCaller_PurchasePrice(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	uri := "lbry://what#abc"
+	c := NewCaller(srv.URL, 123)
+	q, err := NewQuery(jsonrpc.NewRequest(MethodPurchaseCreate, map[string]interface{}{"url": uri}), sdkrouter.WalletID(123))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	price, err := c.purchasePrice(ctx, q, uri, &pb.Fee{Currency: pb.Fee_LBC, Amount: 250000000})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(250000000), price)
+	price, err = c.purchasePrice(ctx, q, uri, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), price)
+	assert.Len(t, reqChan, 0, "LBC fees should not be estimated")
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "result": 61.52413407, "id": 0}`
+	price, err = c.purchasePrice(ctx, q, uri, &pb.Fee{Currency: pb.Fee_USD, Amount: 199})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6152413407), price)
+	req := test.StrToReq(t, (<-reqChan).Body)
+	assert.Equal(t, "stream_cost_estimate", req.Method)
+	assert.Equal(t, map[string]interface{}{"uri": uri}, req.Params)
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "error": {"code": -32500, "message": "exchange rate unavailable"}, "id": 0}`
+	_, err = c.purchasePrice(ctx, q, uri, &pb.Fee{Currency: pb.Fee_BTC, Amount: 1000})
+	assert.EqualError(t, err, "couldn't estimate stream cost: exchange rate unavailable")
+	<-reqChan
+
+	_, err = c.purchasePrice(ctx, q, uri, &pb.Fee{Currency: pb.Fee_UNKNOWN_CURRENCY, Amount: 1000})
+	assert.EqualError(t, err, "unsupported fee currency UNKNOWN_CURRENCY")
+	assert.Len(t, reqChan, 0)
+}
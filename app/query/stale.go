@@ -0,0 +1,119 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/internal/errors"
+
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/ybbus/jsonrpc"
+)
+
+// staleRefreshBackoff is how long to wait before refreshing a stale response again after a refresh was started.
+const staleRefreshBackoff = 10 * time.Second
+
+var (
+	// staleRefreshes marks cache keys with the time their refresh was last started.
+	// A mark is removed once the refresh succeeds and expires after staleRefreshBackoff otherwise.
+	staleRefreshes = gocache.New(staleRefreshBackoff, time.Minute)
+
+	// staleRefreshTransport is shared by all background refreshes so they reuse connections to the SDK.
+	staleRefreshTransport = &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout:   120 * time.Second,
+			KeepAlive: 120 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+)
+
+// cachedResponse is what gets stored in QueryCache. The entry expires from the cache after TTL + StaleTTL
+// of its rule but is only fresh until FreshUntil.
+type cachedResponse struct {
+	Response   *jsonrpc.RPCResponse `json:"response"`
+	FreshUntil time.Time            `json:"fresh_until"`
+}
+
+func (r cachedResponse) isStale() bool {
+	return time.Now().After(r.FreshUntil)
+}
+
+// saveToCache stores the response in the cache according to the rule.
+func (c *Caller) saveToCache(q *Query, rule *CacheRule, res *jsonrpc.RPCResponse) {
+	entry := cachedResponse{Response: res, FreshUntil: time.Now().Add(rule.TTL)}
+	c.Cache.Save(q.Method(), q.Params(), entry, rule.TTL+rule.StaleTTL, cacheTags(q, res)...)
+}
+
+// retrieveFromCache returns the response stored in the cache, nil if there is none.
+// The memory backend returns stored entries as they are, backends serializing entries return them as JSON.
+// Anything else is converted to JSON and back.
+// The returned response is a copy, so it can be modified without affecting the stored one.
+func (c *Caller) retrieveFromCache(q *Query) (*cachedResponse, error) {
+	cached := c.Cache.Retrieve(q.Method(), q.Params())
+	if cached == nil {
+		return nil, nil
+	}
+
+	var entry cachedResponse
+	switch v := cached.(type) {
+	case cachedResponse:
+		entry = v
+	case *cachedResponse:
+		entry = *v
+	case json.RawMessage:
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return nil, errors.Prefix("error unmarshalling cached response", err)
+		}
+	default:
+		s, err := json.Marshal(cached)
+		if err != nil {
+			return nil, errors.Prefix("error marshalling cached response", err)
+		}
+		if err := json.Unmarshal(s, &entry); err != nil {
+			return nil, errors.Prefix("error unmarshalling cached response", err)
+		}
+	}
+	if entry.Response == nil {
+		return nil, errors.Err("cached response is empty")
+	}
+	r := *entry.Response
+	entry.Response = &r
+	return &entry, nil
+}
+
+// refreshInBackground re-sends the query to the SDK to replace a stale cached response.
+// Concurrent refreshes of the same query are coalesced and failed refreshes aren't retried
+// for staleRefreshBackoff, so stale hits don't turn into a stream of SDK queries.
+func (c *Caller) refreshInBackground(q *Query, rule *CacheRule) {
+	key, err := cache.Key(q.Method(), q.Params())
+	if err != nil {
+		logger.Log().Errorf("unable to produce key for params: %v", q.Params())
+		return
+	}
+	// Add only succeeds if there is no unexpired mark for the key
+	if err := staleRefreshes.Add(key, time.Now(), staleRefreshBackoff); err != nil {
+		return
+	}
+
+	rc := c.cloneTo(c.endpoint)
+	rc.transport = staleRefreshTransport
+	req := *q.Request
+	rq := &Query{Request: &req, WalletID: q.WalletID}
+
+	go func() {
+		res, err := rc.sendCoalesced(context.Background(), rq, rule)
+		if err != nil {
+			logger.Log().Errorf("error refreshing stale %v response: %v", rq.Method(), err)
+		} else if res.Error != nil {
+			logger.Log().Errorf("error refreshing stale %v response: %v", rq.Method(), res.Error.Message)
+		} else {
+			staleRefreshes.Delete(key)
+		}
+	}()
+}
@@ -0,0 +1,176 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/query/cache"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func TestCaller_CallStaleWhileRevalidate(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	qCache := cache.NewMemoryCache()
+	policy := CachePolicy{{Name: "test", Method: MethodClaimSearch, TTL: 100 * time.Millisecond, StaleTTL: time.Minute}}
+	call := func() *jsonrpc.RPCResponse {
+		c := NewCaller(srv.URL, 0)
+		c.Cache = qCache
+		c.CachePolicy = policy
+		res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@stale"}))
+		require.NoError(t, err)
+		require.Nil(t, res.Error)
+		return res
+	}
+	page := func(res *jsonrpc.RPCResponse) string {
+		return fmt.Sprint(res.Result.(map[string]interface{})["page"])
+	}
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`
+	assert.Equal(t, "1", page(call()))
+	<-reqChan
+	assert.Equal(t, "1", page(call()), "fresh response should come from the cache")
+	assert.Len(t, reqChan, 0)
+
+	time.Sleep(150 * time.Millisecond)
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 2}}`
+	assert.Equal(t, "1", page(call()), "stale response should be returned right away")
+
+	select {
+	case <-reqChan:
+	case <-time.After(time.Second):
+		t.Fatal("stale response was not refreshed")
+	}
+	assert.Eventually(t, func() bool {
+		c := NewCaller(srv.URL, 0)
+		c.Cache = qCache
+		c.CachePolicy = policy
+		res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@stale"}))
+		return err == nil && page(res) == "2"
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, reqChan, 0)
+}
+
+func TestCaller_CallStaleRefreshBackoff(t *testing.T) {
+	staleRefreshes.Flush()
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	qCache := cache.NewMemoryCache()
+	policy := CachePolicy{{Name: "test", Method: MethodClaimSearch, TTL: 50 * time.Millisecond, StaleTTL: time.Minute}}
+	call := func() {
+		c := NewCaller(srv.URL, 0)
+		c.Cache = qCache
+		c.CachePolicy = policy
+		res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@backoff"}))
+		require.NoError(t, err)
+		require.Nil(t, res.Error)
+	}
+
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`
+	call()
+	<-reqChan
+
+	time.Sleep(100 * time.Millisecond)
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 0, "error": {"code": -32500, "message": "sdk is down"}}`
+	call()
+	select {
+	case <-reqChan:
+	case <-time.After(time.Second):
+		t.Fatal("stale response was not refreshed")
+	}
+
+	for i := 0; i < 5; i++ {
+		call()
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, reqChan, 0, "failed refresh should not be retried right away")
+}
+
+func TestCaller_CallExpired(t *testing.T) {
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+
+	qCache := cache.NewMemoryCache()
+	c := NewCaller(srv.URL, 0)
+	c.Cache = qCache
+	c.CachePolicy = CachePolicy{{Name: "test", Method: MethodClaimSearch, TTL: 50 * time.Millisecond}}
+
+	srv.QueueResponses(
+		`{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 1}}`,
+		`{"jsonrpc": "2.0", "id": 0, "result": {"items": [], "page": 2}}`,
+	)
+	_, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@expired"}))
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	res, err := c.Call(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@expired"}))
+	require.NoError(t, err)
+	assert.Equal(t, "2", fmt.Sprint(res.Result.(map[string]interface{})["page"]), "expired response should not be served")
+	assert.Len(t, reqChan, 2)
+}
+
+func TestCaller_CallCachedResponseID(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	srv.NextResponse <- `{"jsonrpc": "2.0", "id": 1, "result": {"items": [], "page": 1}}`
+
+	c := NewCaller(srv.URL, 0)
+	c.Cache = cache.NewMemoryCache()
+	for i := 1; i <= 3; i++ {
+		req := jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@ids"})
+		req.ID = i
+		res, err := c.Call(req)
+		require.NoError(t, err)
+		assert.Equal(t, i, res.ID)
+	}
+}
+
+func TestCaller_RetrieveFromCache(t *testing.T) {
+	q, err := NewQuery(jsonrpc.NewRequest(MethodClaimSearch, map[string]interface{}{"channel": "@ids"}), "")
+	require.NoError(t, err)
+	freshUntil := time.Now().Add(time.Minute).UTC().Round(time.Second)
+	stored := cachedResponse{Response: &jsonrpc.RPCResponse{ID: 1, Result: "ok"}, FreshUntil: freshUntil}
+
+	for name, qCache := range map[string]cache.QueryCache{
+		"memory": cache.NewMemoryCache(),
+		"lru":    cache.NewLRUCache("test", 1024*1024, time.Minute),
+	} {
+		c := NewCaller("", 0)
+		c.Cache = qCache
+		c.saveToCache(q, &CacheRule{TTL: time.Minute}, stored.Response)
+		entry, err := c.retrieveFromCache(q)
+		require.NoError(t, err, name)
+		require.NotNil(t, entry, name)
+		assert.Equal(t, "ok", entry.Response.Result, name)
+		entry.Response.ID = 2
+		entry, err = c.retrieveFromCache(q)
+		require.NoError(t, err, name)
+		assert.Equal(t, 1, entry.Response.ID, "%v: stored responses should not be modified", name)
+	}
+
+	// backends returning generic values go through JSON
+	c := NewCaller("", 0)
+	c.Cache = cache.NewMemoryCache()
+	c.Cache.Save(q.Method(), q.Params(), map[string]interface{}{
+		"response":    map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": "ok"},
+		"fresh_until": freshUntil,
+	}, time.Minute)
+	entry, err := c.retrieveFromCache(q)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", entry.Response.Result)
+	assert.True(t, freshUntil.Equal(entry.FreshUntil))
+
+	c.Cache.Save(q.Method(), q.Params(), map[string]interface{}{}, time.Minute)
+	_, err = c.retrieveFromCache(q)
+	assert.EqualError(t, err, "cached response is empty")
+}
@@ -0,0 +1,164 @@
+package query
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/lbryio/lbrytv-player/pkg/paid"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+)
+
+const (
+	StreamURLBuilderPlayer    = "player"
+	StreamURLBuilderSignedCDN = "signed_cdn"
+	StreamURLBuilderRegional  = "regional"
+)
+
+// Stream is a stream requested with `get`.
+type Stream struct {
+	Name    string
+	ClaimID string
+	SdHash  string
+	Size    uint64
+	// Txid is the purchase transaction of a paid stream, empty for free streams.
+	Txid string
+	// RemoteIP is the address of the user requesting the stream.
+	RemoteIP string
+}
+
+// Paid returns true if the stream has been paid for.
+func (s Stream) Paid() bool {
+	return s.Txid != ""
+}
+
+// path returns the stream location common to all layouts, e.g. free/name/claim_id/sd_hash.
+func (s Stream) path() string {
+	kind := "free"
+	if s.Paid() {
+		kind = "paid"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", kind, s.Name, s.ClaimID, s.SdHash[:6])
+}
+
+// StreamURLBuilder builds URLs streams returned by `get` are played from.
+type StreamURLBuilder interface {
+	StreamURL(s Stream) (string, error)
+}
+
+// PlayerURLBuilder builds URLs in the lbrytv player layout, paid streams get a player token appended.
+type PlayerURLBuilder struct {
+	BaseURL string
+}
+
+func (b PlayerURLBuilder) StreamURL(s Stream) (string, error) {
+	if !s.Paid() {
+		return b.BaseURL + s.path(), nil
+	}
+	logger.Log().Debugf("creating stream token with stream id=%s, txid=%s, size=%v", s.Name+"/"+s.ClaimID, s.Txid, s.Size)
+	token, err := paid.CreateToken(s.Name+"/"+s.ClaimID, s.Txid, s.Size, paid.ExpTenSecPer100MB)
+	if err != nil {
+		return "", err
+	}
+	return b.BaseURL + s.path() + "/" + token, nil
+}
+
+// SignedCDNURLBuilder builds CDN URLs which expire after ExpiresIn, signed with an HMAC of the path and expiry time.
+type SignedCDNURLBuilder struct {
+	BaseURL   string
+	Key       []byte
+	ExpiresIn time.Duration
+
+	now func() time.Time
+}
+
+func (b SignedCDNURLBuilder) StreamURL(s Stream) (string, error) {
+	now := time.Now
+	if b.now != nil {
+		now = b.now
+	}
+	path := fmt.Sprintf("%s?expires=%d", s.path(), now().Add(b.ExpiresIn).Unix())
+	mac := hmac.New(sha256.New, b.Key)
+	mac.Write([]byte(path))
+	return fmt.Sprintf("%s%s&signature=%s", b.BaseURL, path, hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+// PlayerRegion is a player serving users from certain networks.
+type PlayerRegion struct {
+	Name     string
+	Networks []*net.IPNet
+	Player   PlayerURLBuilder
+}
+
+// RegionalURLBuilder builds player URLs for the region the user's IP belongs to, Default is used outside of them.
+type RegionalURLBuilder struct {
+	Regions []PlayerRegion
+	Default PlayerURLBuilder
+}
+
+func (b RegionalURLBuilder) StreamURL(s Stream) (string, error) {
+	return b.pick(s.RemoteIP).StreamURL(s)
+}
+
+func (b RegionalURLBuilder) pick(remoteIP string) PlayerURLBuilder {
+	addr := net.ParseIP(remoteIP)
+	if addr == nil {
+		return b.Default
+	}
+	for _, r := range b.Regions {
+		for _, n := range r.Networks {
+			if n.Contains(addr) {
+				return r.Player
+			}
+		}
+	}
+	return b.Default
+}
+
+// NewStreamURLBuilder creates the builder set in the config, serving streams from baseURL by default.
+func NewStreamURLBuilder(baseURL string, cfg config.StreamURLs) (StreamURLBuilder, error) {
+	switch cfg.Builder {
+	case StreamURLBuilderPlayer, "":
+		return PlayerURLBuilder{BaseURL: baseURL}, nil
+	case StreamURLBuilderSignedCDN:
+		if cfg.SigningKey == "" || cfg.ExpiresIn <= 0 {
+			return nil, errors.Err("signed stream URLs require SigningKey and a positive ExpiresIn")
+		}
+		return SignedCDNURLBuilder{
+			BaseURL:   baseURL,
+			Key:       []byte(cfg.SigningKey),
+			ExpiresIn: time.Duration(cfg.ExpiresIn) * time.Second,
+		}, nil
+	case StreamURLBuilderRegional:
+		b := RegionalURLBuilder{Default: PlayerURLBuilder{BaseURL: baseURL}}
+		for _, rc := range cfg.Regions {
+			if rc.BaseURL == "" {
+				return nil, errors.Err("region %v should have BaseURL set", rc.Name)
+			}
+			r := PlayerRegion{Name: rc.Name, Player: PlayerURLBuilder{BaseURL: rc.BaseURL}}
+			for _, cidr := range rc.Networks {
+				_, n, err := net.ParseCIDR(cidr)
+				if err != nil {
+					return nil, errors.Prefix("region "+rc.Name, err)
+				}
+				r.Networks = append(r.Networks, n)
+			}
+			b.Regions = append(b.Regions, r)
+		}
+		return b, nil
+	}
+	return nil, errors.Err("unknown stream URL builder: %v", cfg.Builder)
+}
+
+// streamURLBuilder returns the builder of URLs returned by `get`,
+// the player layout under BaseContentURL if the caller has none set.
+func (c *Caller) streamURLBuilder() StreamURLBuilder {
+	if c.StreamURLBuilder == nil {
+		return PlayerURLBuilder{BaseURL: config.Config.Viper.GetString("BaseContentURL")}
+	}
+	return c.StreamURLBuilder
+}
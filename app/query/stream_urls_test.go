@@ -0,0 +1,142 @@
+package query
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv-player/pkg/paid"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testFreeStream = Stream{Name: "what", ClaimID: "19b9c243bea0c45175e6a6027911abbad53e983e", SdHash: "d51692bd2a"}
+	testPaidStream = Stream{
+		Name: "paid-what", ClaimID: "d66f8ba85c85ca48daba9183bd349307fe30cb43", SdHash: "51ee25a93f",
+		Size: 585600621, Txid: "ff990688df370072f408e2db9d217d2cf331d92ac594d5e6e8391143e9d38160",
+	}
+)
+
+func TestPlayerURLBuilder(t *testing.T) {
+	require.NoError(t, paid.GeneratePrivateKey())
+	b := PlayerURLBuilder{BaseURL: "https://cdn.lbryplayer.xyz/api/v3/streams/"}
+
+	u, err := b.StreamURL(testFreeStream)
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.lbryplayer.xyz/api/v3/streams/free/what/19b9c243bea0c45175e6a6027911abbad53e983e/d51692", u)
+
+	u, err = b.StreamURL(testPaidStream)
+	require.NoError(t, err)
+	token, err := paid.CreateToken("paid-what/d66f8ba85c85ca48daba9183bd349307fe30cb43", testPaidStream.Txid, testPaidStream.Size, paid.ExpTenSecPer100MB)
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.lbryplayer.xyz/api/v3/streams/paid/paid-what/d66f8ba85c85ca48daba9183bd349307fe30cb43/51ee25/"+token, u)
+}
+
+func TestSignedCDNURLBuilder(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	b := SignedCDNURLBuilder{
+		BaseURL: "https://cdn.example.com/", Key: []byte("secret"), ExpiresIn: time.Hour,
+		now: func() time.Time { return now },
+	}
+	sign := func(path string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(path))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	u, err := b.StreamURL(testFreeStream)
+	require.NoError(t, err)
+	path := "free/what/19b9c243bea0c45175e6a6027911abbad53e983e/d51692?expires=1600003600"
+	assert.Equal(t, "https://cdn.example.com/"+path+"&signature="+sign(path), u)
+
+	u, err = b.StreamURL(testPaidStream)
+	require.NoError(t, err)
+	path = "paid/paid-what/d66f8ba85c85ca48daba9183bd349307fe30cb43/51ee25?expires=1600003600"
+	assert.Equal(t, "https://cdn.example.com/"+path+"&signature="+sign(path), u)
+
+	b.Key = []byte("other")
+	u2, err := b.StreamURL(testPaidStream)
+	require.NoError(t, err)
+	assert.NotEqual(t, u, u2, "signatures should depend on the key")
+}
+
+func TestRegionalURLBuilder(t *testing.T) {
+	_, eu, _ := net.ParseCIDR("2.16.0.0/13")
+	_, asia, _ := net.ParseCIDR("1.0.0.0/8")
+	b := RegionalURLBuilder{
+		Regions: []PlayerRegion{
+			{Name: "eu", Networks: []*net.IPNet{eu}, Player: PlayerURLBuilder{BaseURL: "https://eu.player/"}},
+			{Name: "asia", Networks: []*net.IPNet{asia}, Player: PlayerURLBuilder{BaseURL: "https://asia.player/"}},
+		},
+		Default: PlayerURLBuilder{BaseURL: "https://player/"},
+	}
+
+	cases := map[string]string{
+		"2.17.1.1":  "https://eu.player/",
+		"1.2.3.4":   "https://asia.player/",
+		"8.8.8.8":   "https://player/",
+		"":          "https://player/",
+		"localhost": "https://player/",
+	}
+	for remoteIP, base := range cases {
+		s := testFreeStream
+		s.RemoteIP = remoteIP
+		u, err := b.StreamURL(s)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(u, base+"free/"), "%v should be served by %v, got %v", remoteIP, base, u)
+	}
+}
+
+func TestNewStreamURLBuilder(t *testing.T) {
+	b, err := NewStreamURLBuilder("https://player/", config.StreamURLs{})
+	require.NoError(t, err)
+	assert.Equal(t, PlayerURLBuilder{BaseURL: "https://player/"}, b)
+
+	b, err = NewStreamURLBuilder("https://cdn/", config.StreamURLs{Builder: StreamURLBuilderSignedCDN, SigningKey: "secret", ExpiresIn: 60})
+	require.NoError(t, err)
+	assert.Equal(t, SignedCDNURLBuilder{BaseURL: "https://cdn/", Key: []byte("secret"), ExpiresIn: time.Minute}, b)
+	_, err = NewStreamURLBuilder("https://cdn/", config.StreamURLs{Builder: StreamURLBuilderSignedCDN, ExpiresIn: 60})
+	assert.EqualError(t, err, "signed stream URLs require SigningKey and a positive ExpiresIn")
+
+	b, err = NewStreamURLBuilder("https://player/", config.StreamURLs{
+		Builder: StreamURLBuilderRegional,
+		Regions: []config.StreamURLRegion{{Name: "eu", Networks: []string{"2.16.0.0/13"}, BaseURL: "https://eu.player/"}},
+	})
+	require.NoError(t, err)
+	rb := b.(RegionalURLBuilder)
+	assert.Equal(t, "https://eu.player/", rb.pick("2.17.1.1").BaseURL)
+	assert.Equal(t, "https://player/", rb.pick("8.8.8.8").BaseURL)
+
+	_, err = NewStreamURLBuilder("https://player/", config.StreamURLs{
+		Builder: StreamURLBuilderRegional,
+		Regions: []config.StreamURLRegion{{Name: "eu", Networks: []string{"2.16.0.0"}, BaseURL: "https://eu.player/"}},
+	})
+	assert.EqualError(t, err, "region eu: invalid CIDR address: 2.16.0.0")
+	_, err = NewStreamURLBuilder("https://player/", config.StreamURLs{
+		Builder: StreamURLBuilderRegional,
+		Regions: []config.StreamURLRegion{{Name: "eu", Networks: []string{"2.16.0.0/13"}}},
+	})
+	assert.EqualError(t, err, "region eu should have BaseURL set")
+
+	_, err = NewStreamURLBuilder("https://player/", config.StreamURLs{Builder: "ftp"})
+	assert.EqualError(t, err, "unknown stream URL builder: ftp")
+}
+
+func TestCaller_GetStreamURLBuilder(t *testing.T) {
+	srv := test.MockHTTPServer(nil)
+	defer srv.Close()
+	srv.QueueResponses(resolveResponseFree)
+
+	c := NewCaller(srv.URL, 123321)
+	c.StreamURLBuilder = SignedCDNURLBuilder{BaseURL: "https://cdn.example.com/", Key: []byte("secret"), ExpiresIn: time.Hour}
+	u := getStreamingURL(t, c, "what")
+	assert.Regexp(t, `^https://cdn\.example\.com/free/what/19b9c243bea0c45175e6a6027911abbad53e983e/d51692\?expires=\d+&signature=[0-9a-f]{64}$`, u)
+}
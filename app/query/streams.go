@@ -0,0 +1,162 @@
+package query
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+	pb "github.com/lbryio/types/v2/go"
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"github.com/ybbus/jsonrpc"
+)
+
+// streamInfo is the part of resolved stream metadata needed to build streaming URLs.
+type streamInfo struct {
+	name    string
+	claimID string
+	sdHash  string
+	size    uint64
+	fee     *pb.Fee
+}
+
+func newStreamInfo(claim *ljsonrpc.Claim) (*streamInfo, error) {
+	stream := claim.Value.GetStream()
+	src := stream.GetSource()
+	if src == nil {
+		return nil, fmt.Errorf("stream doesn't have source data")
+	}
+	return &streamInfo{
+		name:    claim.Name,
+		claimID: claim.ClaimID,
+		sdHash:  hex.EncodeToString(src.SdHash),
+		size:    src.GetSize(),
+		fee:     stream.GetFee(),
+	}, nil
+}
+
+// StreamCache keeps stream metadata and purchase receipts of users so repeated `get` queries
+// for the same stream don't have to resolve and purchase it again.
+type StreamCache struct {
+	streams   *gocache.Cache
+	purchases *gocache.Cache
+
+	// urls indexes cached streams by claim ID, so all URLs of a claim can be dropped when it changes
+	mu   sync.Mutex
+	urls map[string]map[string]bool
+}
+
+// NewStreamCache creates a StreamCache. Streams or purchases are not cached if their TTL is not set.
+// Methods of a nil StreamCache don't cache anything.
+func NewStreamCache(cfg config.StreamCache) *StreamCache {
+	c := &StreamCache{}
+	if cfg.TTL > 0 {
+		ttl := time.Duration(cfg.TTL) * time.Second
+		c.streams = gocache.New(ttl, 2*ttl)
+		c.urls = map[string]map[string]bool{}
+		c.streams.OnEvicted(c.unindex)
+	}
+	if cfg.PurchaseTTL > 0 {
+		ttl := time.Duration(cfg.PurchaseTTL) * time.Second
+		c.purchases = gocache.New(ttl, 2*ttl)
+	}
+	return c
+}
+
+func (c *StreamCache) stream(url string) *streamInfo {
+	if c == nil || c.streams == nil {
+		return nil
+	}
+	v, ok := c.streams.Get(url)
+	if !ok {
+		metrics.LbrytvStreamCacheCount.WithLabelValues(metrics.StreamCacheStreams, metrics.StreamCacheMiss).Inc()
+		return nil
+	}
+	metrics.LbrytvStreamCacheCount.WithLabelValues(metrics.StreamCacheStreams, metrics.StreamCacheHit).Inc()
+	return v.(*streamInfo)
+}
+
+func (c *StreamCache) saveStream(url string, info *streamInfo) {
+	if c == nil || c.streams == nil {
+		return
+	}
+	// The URL might have pointed to another claim before, deleting unindexes it
+	c.streams.Delete(url)
+	c.streams.SetDefault(url, info)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.urls[info.claimID] == nil {
+		c.urls[info.claimID] = map[string]bool{}
+	}
+	c.urls[info.claimID][url] = true
+}
+
+// unindex is called by the cache when a stream expires or is deleted.
+func (c *StreamCache) unindex(url string, v interface{}) {
+	claimID := v.(*streamInfo).claimID
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.urls[claimID], url)
+	if len(c.urls[claimID]) == 0 {
+		delete(c.urls, claimID)
+	}
+}
+
+func purchaseKey(walletID, claimID string) string {
+	return walletID + "/" + claimID
+}
+
+func (c *StreamCache) purchase(walletID, claimID string) *ljsonrpc.PurchaseReceipt {
+	if c == nil || c.purchases == nil {
+		return nil
+	}
+	v, ok := c.purchases.Get(purchaseKey(walletID, claimID))
+	if !ok {
+		metrics.LbrytvStreamCacheCount.WithLabelValues(metrics.StreamCachePurchases, metrics.StreamCacheMiss).Inc()
+		return nil
+	}
+	metrics.LbrytvStreamCacheCount.WithLabelValues(metrics.StreamCachePurchases, metrics.StreamCacheHit).Inc()
+	return v.(*ljsonrpc.PurchaseReceipt)
+}
+
+func (c *StreamCache) savePurchase(walletID, claimID string, receipt *ljsonrpc.PurchaseReceipt) {
+	if c != nil && c.purchases != nil {
+		c.purchases.SetDefault(purchaseKey(walletID, claimID), receipt)
+	}
+}
+
+// InvalidateClaim removes metadata of the stream with claimID, returning the number of removed entries.
+func (c *StreamCache) InvalidateClaim(claimID string) int {
+	if c == nil || c.streams == nil {
+		return 0
+	}
+	c.mu.Lock()
+	urls := c.urls[claimID]
+	delete(c.urls, claimID)
+	c.mu.Unlock()
+
+	for url := range urls {
+		c.streams.Delete(url)
+	}
+	return len(urls)
+}
+
+// invalidateStreams is a postflight hook removing cached metadata of the streams changed by the query.
+func invalidateStreams(c *Caller, hctx *HookContext) (*jsonrpc.RPCResponse, error) {
+	if hctx.Response == nil || hctx.Response.Error != nil {
+		return nil, nil
+	}
+	var n int
+	for _, id := range claimIDs(hctx.Query, hctx.Response) {
+		n += c.StreamCache.InvalidateClaim(id)
+	}
+	if n > 0 {
+		logger.WithFields(logrus.Fields{"method": hctx.Query.Method(), "invalidated": n}).Debug("stream cache invalidated")
+	}
+	return nil, nil
+}
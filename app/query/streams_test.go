@@ -0,0 +1,114 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/lbryio/lbrytv-player/pkg/paid"
+	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/test"
+
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func newStreamCaller(endpoint string, userID int, streams *StreamCache) *Caller {
+	c := NewCaller(endpoint, userID)
+	c.StreamCache = streams
+	return c
+}
+
+func getStreamingURL(t *testing.T, c *Caller, uri string) string {
+	t.Helper()
+	resp, err := c.Call(jsonrpc.NewRequest(MethodGet, map[string]interface{}{"uri": uri}))
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	getResponse := &ljsonrpc.GetResponse{}
+	require.NoError(t, resp.GetObject(&getResponse))
+	return getResponse.StreamingURL
+}
+
+func TestStreamCacheDisabled(t *testing.T) {
+	c := NewStreamCache(config.StreamCache{})
+	c.saveStream("what", &streamInfo{claimID: "abc"})
+	c.savePurchase("wallet", "abc", &ljsonrpc.PurchaseReceipt{Txid: "123"})
+	assert.Nil(t, c.stream("what"))
+	assert.Nil(t, c.purchase("wallet", "abc"))
+	assert.Equal(t, 0, c.InvalidateClaim("abc"))
+}
+
+func TestStreamCacheInvalidateClaim(t *testing.T) {
+	c := NewStreamCache(config.StreamCache{TTL: 60, PurchaseTTL: 60})
+	c.saveStream("what", &streamInfo{claimID: "abc"})
+	c.saveStream("what#a", &streamInfo{claimID: "abc"})
+	c.saveStream("other", &streamInfo{claimID: "def"})
+	c.savePurchase("wallet", "abc", &ljsonrpc.PurchaseReceipt{Txid: "123"})
+
+	assert.Equal(t, 2, c.InvalidateClaim("abc"))
+	assert.Nil(t, c.stream("what"))
+	assert.Nil(t, c.stream("what#a"))
+	assert.NotNil(t, c.stream("other"))
+	assert.Equal(t, "123", c.purchase("wallet", "abc").Txid, "purchases should outlive claim updates")
+	assert.NotContains(t, c.urls, "abc")
+
+	// URLs pointing to another claim now are only invalidated along with it
+	c.saveStream("other", &streamInfo{claimID: "ghi"})
+	assert.Equal(t, 0, c.InvalidateClaim("def"))
+	assert.NotNil(t, c.stream("other"))
+
+	c.streams.Delete("other")
+	assert.Empty(t, c.urls, "deleted streams should be removed from the index")
+}
+
+func TestCaller_GetFreeCached(t *testing.T) {
+	config.Override("BaseContentURL", "https://cdn.lbryplayer.xyz/api/v3/streams/")
+	defer config.RestoreOverridden()
+	streams := NewStreamCache(config.StreamCache{TTL: 60, PurchaseTTL: 60})
+
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+	srv.QueueResponses(resolveResponseFree)
+
+	expected := "https://cdn.lbryplayer.xyz/api/v3/streams/free/what/19b9c243bea0c45175e6a6027911abbad53e983e/d51692"
+	assert.Equal(t, expected, getStreamingURL(t, newStreamCaller(srv.URL, 123321, streams), "what"))
+	<-reqChan
+	assert.Equal(t, expected, getStreamingURL(t, newStreamCaller(srv.URL, 123322, streams), "what"))
+	assert.Len(t, reqChan, 0, "cached free streams should not be queried again")
+}
+
+func TestCaller_GetPaidCached(t *testing.T) {
+	config.Override("BaseContentURL", "https://cdn.lbryplayer.xyz/api/v3/streams/")
+	defer config.RestoreOverridden()
+	streams := NewStreamCache(config.StreamCache{TTL: 60, PurchaseTTL: 60})
+	require.NoError(t, paid.GeneratePrivateKey())
+
+	uri := "Body-Language---Robert-F.-Kennedy-Assassination---Hypnosis#d66f8ba85c85ca48daba9183bd349307fe30cb43"
+	reqChan := test.ReqChan()
+	srv := test.MockHTTPServer(reqChan)
+	defer srv.Close()
+	srv.QueueResponses(
+		resolveResponseWithoutPurchase,
+		purchaseCreateResponse,
+		resolveResponseWithPurchase,
+	)
+
+	url := getStreamingURL(t, newStreamCaller(srv.URL, 123321, streams), uri)
+	assert.Contains(t, url, "/paid/")
+	for _, m := range []string{MethodResolve, MethodPurchaseCreate, MethodResolve} {
+		assert.Equal(t, m, test.StrToReq(t, (<-reqChan).Body).Method)
+	}
+
+	assert.Contains(t, getStreamingURL(t, newStreamCaller(srv.URL, 123321, streams), uri), "/paid/")
+	assert.Len(t, reqChan, 0, "streams already purchased by the user should not be queried again")
+
+	// Other users still need their own purchase, but not the stream metadata
+	srv.QueueResponses(resolveResponseWithPurchase)
+	assert.Contains(t, getStreamingURL(t, newStreamCaller(srv.URL, 123322, streams), uri), "/paid/")
+	req := test.StrToReq(t, (<-reqChan).Body)
+	assert.Equal(t, MethodResolve, req.Method)
+	assert.Equal(t, sdkrouter.WalletID(123322), req.Params.(map[string]interface{})["wallet_id"])
+	assert.Len(t, reqChan, 0)
+}
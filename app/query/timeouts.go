@@ -0,0 +1,104 @@
+package query
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMethodTimeout applies to methods that have no timeout of their own.
+	defaultMethodTimeout = sdkrouter.RPCTimeout
+	// anyMethodTimeout is the config key for overriding defaultMethodTimeout.
+	anyMethodTimeout = "*"
+)
+
+// defaultMethodTimeouts are for how long the SDK is waited on for a response, by method.
+// Lookups and reads should be quick while publishing and purchases can take a while.
+var defaultMethodTimeouts = map[string]time.Duration{
+	MethodResolve:          30 * time.Second,
+	MethodClaimSearch:      30 * time.Second,
+	MethodStatus:           10 * time.Second,
+	"version":              10 * time.Second,
+	MethodFileList:         30 * time.Second,
+	MethodAccountList:      30 * time.Second,
+	MethodWalletBalance:    30 * time.Second,
+	MethodCommentReactList: 30 * time.Second,
+	"comment_list":         30 * time.Second,
+	"transaction_list":     60 * time.Second,
+	"txo_list":             60 * time.Second,
+	MethodPurchaseCreate:   120 * time.Second,
+	MethodWalletSend:       120 * time.Second,
+	MethodAccountSend:      120 * time.Second,
+	"support_create":       120 * time.Second,
+	MethodSyncApply:        120 * time.Second,
+	"publish":              600 * time.Second,
+	"stream_create":        600 * time.Second,
+	"stream_update":        600 * time.Second,
+}
+
+var (
+	methodTimeouts     map[string]time.Duration
+	methodTimeoutsOnce sync.Once
+)
+
+// configuredMethodTimeouts returns defaultMethodTimeouts with timeouts from the config applied, read from the config once.
+func configuredMethodTimeouts() map[string]time.Duration {
+	methodTimeoutsOnce.Do(func() {
+		cfg, err := config.GetMethodTimeouts()
+		if err != nil {
+			logger.Log().Errorf("invalid method timeouts in the config, using defaults: %v", err)
+		}
+		methodTimeouts = mergeMethodTimeouts(defaultMethodTimeouts, cfg)
+	})
+	return methodTimeouts
+}
+
+// mergeMethodTimeouts overrides default timeouts with configured ones, set in seconds. Non-positive ones are ignored.
+func mergeMethodTimeouts(defaults map[string]time.Duration, cfg map[string]int) map[string]time.Duration {
+	timeouts := map[string]time.Duration{}
+	for m, t := range defaults {
+		timeouts[m] = t
+	}
+	for m, secs := range cfg {
+		if secs <= 0 {
+			logger.Log().Warnf("ignoring non-positive timeout for %v: %v", m, secs)
+			continue
+		}
+		timeouts[m] = time.Duration(secs) * time.Second
+	}
+	return timeouts
+}
+
+// MethodTimeout returns for how long a query of the method is waited on for an SDK response.
+func MethodTimeout(method string) time.Duration {
+	timeouts := configuredMethodTimeouts()
+	if t, ok := timeouts[method]; ok {
+		return t
+	}
+	if t, ok := timeouts[anyMethodTimeout]; ok {
+		return t
+	}
+	return defaultMethodTimeout
+}
+
+// timedOut records a query that got no SDK response within its timeout.
+func (c *Caller) timedOut(q *Query, timeout time.Duration) error {
+	logger.WithFields(logrus.Fields{
+		"method":   q.Method(),
+		"endpoint": c.endpoint,
+		"user_id":  c.userID,
+		"timeout":  timeout.Seconds(),
+	}).Error("query timed out")
+	metrics.ProxyCallFailedDurations.WithLabelValues(q.Method(), c.endpoint, metrics.FailureKindTimeout).Observe(c.Duration)
+	metrics.ProxyCallFailedCounter.WithLabelValues(q.Method(), c.endpoint, metrics.FailureKindTimeout).Inc()
+	return rpcerrors.NewTimeoutError(errors.Prefix(fmt.Sprintf("%v (%v)", q.Method(), timeout), rpcerrors.ErrTimeout))
+}
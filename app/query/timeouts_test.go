@@ -0,0 +1,61 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func TestMethodTimeout(t *testing.T) {
+	assert.Equal(t, 30*time.Second, MethodTimeout(MethodResolve))
+	assert.Equal(t, 600*time.Second, MethodTimeout("publish"))
+	assert.Equal(t, sdkrouter.RPCTimeout, MethodTimeout("blob_announce"))
+}
+
+func TestMergeMethodTimeouts(t *testing.T) {
+	timeouts := mergeMethodTimeouts(defaultMethodTimeouts, map[string]int{
+		MethodResolve:    5,
+		"*":              90,
+		MethodWalletSend: 0,
+	})
+	assert.Equal(t, 5*time.Second, timeouts[MethodResolve])
+	assert.Equal(t, 90*time.Second, timeouts[anyMethodTimeout])
+	assert.Equal(t, defaultMethodTimeouts[MethodWalletSend], timeouts[MethodWalletSend], "non-positive timeouts should be ignored")
+	assert.Equal(t, 30*time.Second, defaultMethodTimeouts[MethodResolve], "defaults should not be modified")
+}
+
+func TestGetMethodTimeouts(t *testing.T) {
+	config.Override("MethodTimeouts", map[string]interface{}{"resolve": 5, "publish": 900})
+	defer config.RestoreOverridden()
+	timeouts, err := config.GetMethodTimeouts()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"resolve": 5, "publish": 900}, timeouts)
+}
+
+func TestCaller_CallTimeout(t *testing.T) {
+	srv, abandoned := newHangingServer()
+	defer srv.Close()
+
+	timeouts := configuredMethodTimeouts()
+	timeouts[MethodResolve] = 50 * time.Millisecond
+	defer func() { timeouts[MethodResolve] = defaultMethodTimeouts[MethodResolve] }()
+
+	_, err := NewCaller(srv.URL, 0).Call(jsonrpc.NewRequest(MethodResolve, map[string]interface{}{"urls": "what"}))
+	require.Error(t, err)
+	var rpcErr rpcerrors.RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, -32089, rpcErr.Code())
+	assert.True(t, errors.Is(err, rpcerrors.ErrTimeout))
+	assert.Equal(t, "resolve (50ms): sdk query timed out", err.Error())
+	assert.Equal(t, metrics.FailureKindTimeout, FailureKind(err))
+	<-abandoned
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often full buckets are removed from memory.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// memoryBackend keeps token buckets of a single API instance.
+type memoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryBackend() *memoryBackend {
+	return &memoryBackend{buckets: map[string]*bucket{}, lastSweep: time.Now(), now: time.Now}
+}
+
+func (b *memoryBackend) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.sweep(now)
+
+	bk, ok := b.buckets[key]
+	if !ok {
+		bk = &bucket{tokens: float64(burst), updated: now}
+		b.buckets[key] = bk
+	}
+	bk.tokens = refill(bk.tokens, now.Sub(bk.updated), rate, burst)
+	bk.updated = now
+	if bk.tokens < 1 {
+		return false, waitTime(bk.tokens, rate), nil
+	}
+	bk.tokens--
+	bk.full = now.Add(time.Duration((float64(burst) - bk.tokens) / rate * float64(time.Second)))
+	return true, 0, nil
+}
+
+func (b *memoryBackend) Refund(key string, rate float64, burst int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bk, ok := b.buckets[key]
+	if !ok {
+		// swept buckets are full
+		return nil
+	}
+	now := b.now()
+	bk.tokens = math.Min(float64(burst), refill(bk.tokens, now.Sub(bk.updated), rate, burst)+1)
+	bk.updated = now
+	bk.full = now.Add(time.Duration((float64(burst) - bk.tokens) / rate * float64(time.Second)))
+	return nil
+}
+
+// sweep removes buckets that have been refilled since they were last used, they are the same as new ones.
+func (b *memoryBackend) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < sweepInterval {
+		return
+	}
+	for k, bk := range b.buckets {
+		if now.After(bk.full) {
+			delete(b.buckets, k)
+		}
+	}
+	b.lastSweep = now
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lbryio/lbrytv/app/auth"
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/internal/ip"
+	"github.com/lbryio/lbrytv/internal/metrics"
+	"github.com/lbryio/lbrytv/internal/responses"
+
+	"github.com/gorilla/mux"
+	"github.com/ybbus/jsonrpc"
+)
+
+type rpcQuery struct {
+	// ID is whatever the client sent, JSON-RPC allows strings and numbers
+	ID     interface{} `json:"id"`
+	Method string      `json:"method"`
+}
+
+// rpcResponse is a JSON-RPC error response carrying the query ID as is.
+type rpcResponse struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Error   *jsonrpc.RPCError `json:"error"`
+	ID      interface{}       `json:"id"`
+}
+
+// Middleware rejects JSON-RPC queries and batches containing queries over the rate limit of their method.
+// Authenticated users are limited by their ID and anonymous clients by their IP address,
+// so it should come after ip and auth middlewares.
+// When a batch is rejected, tokens taken for its other queries are returned.
+func Middleware(l *Limiter) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.Enabled() || r.Method != http.MethodPost || r.Body == nil ||
+				strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			queries, batch := parseQueries(body)
+			if len(queries) == 0 {
+				// Not a JSON-RPC request, leaving it to the handler
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := clientKey(r)
+			for i, q := range queries {
+				allowed, retryAfter := l.Allow(client, q.Method)
+				if allowed {
+					continue
+				}
+				for _, taken := range queries[:i] {
+					l.Refund(client, taken.Method)
+				}
+				metrics.ProxyRateLimitedCount.WithLabelValues(q.Method).Inc()
+				secs := int(math.Ceil(retryAfter.Seconds()))
+				if secs < 1 {
+					secs = 1
+				}
+				logger.Log().Debugf("%v is over the rate limit of %v", client, q.Method)
+
+				rpcErr := rpcerrors.NewRateLimitedError(fmt.Errorf("too many %v queries, retry after %d seconds", q.Method, secs))
+				responses.AddJSONContentType(w)
+				w.Header().Set("Retry-After", strconv.Itoa(secs))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write(errorResponse(rpcErr, queries, batch))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// errorResponse returns the error for every query, as a batch if the request was one.
+func errorResponse(rpcErr rpcerrors.RPCError, queries []rpcQuery, batch bool) []byte {
+	rpcError := &jsonrpc.RPCError{Code: rpcErr.Code(), Message: rpcErr.Error()}
+	if !batch {
+		res, _ := json.MarshalIndent(rpcResponse{JSONRPC: "2.0", Error: rpcError, ID: queries[0].ID}, "", "  ")
+		return res
+	}
+	items := make([]rpcResponse, len(queries))
+	for i, q := range queries {
+		items[i] = rpcResponse{JSONRPC: "2.0", Error: rpcError, ID: q.ID}
+	}
+	res, _ := json.MarshalIndent(items, "", "  ")
+	return res
+}
+
+// parseQueries returns queries of a single JSON-RPC request or a batch, nil if the body is not a JSON-RPC request.
+// Batch items are decoded one by one, so a malformed item doesn't let the others skip the limits.
+func parseQueries(body []byte) (queries []rpcQuery, batch bool) {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, true
+		}
+		for _, item := range items {
+			var q rpcQuery
+			if err := json.Unmarshal(item, &q); err != nil || q.Method == "" {
+				continue
+			}
+			queries = append(queries, q)
+		}
+		return queries, true
+	}
+	var q rpcQuery
+	if err := json.Unmarshal(trimmed, &q); err != nil || q.Method == "" {
+		return nil, false
+	}
+	return []rpcQuery{q}, false
+}
+
+// clientKey identifies who is making the request.
+func clientKey(r *http.Request) string {
+	if user, err := auth.FromRequest(r); err == nil && user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+	return "ip:" + ip.FromRequest(r)
+}
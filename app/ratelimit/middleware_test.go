@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lbryio/lbrytv/app/auth"
+	"github.com/lbryio/lbrytv/app/wallet"
+	"github.com/lbryio/lbrytv/internal/ip"
+	"github.com/lbryio/lbrytv/internal/middleware"
+	"github.com/lbryio/lbrytv/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func testHandler(limiter *Limiter) http.Handler {
+	provider := func(token, ip string) (*models.User, error) {
+		if token == "user-token" {
+			return &models.User{ID: 42}, nil
+		}
+		return nil, nil
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return middleware.Apply(middleware.Chain(ip.Middleware, auth.Middleware(provider), Middleware(limiter)), ok)
+}
+
+func post(h http.Handler, body, token, remoteIP string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/proxy", strings.NewReader(body))
+	r.Header.Set("X-Forwarded-For", remoteIP)
+	if token != "" {
+		r.Header.Set(wallet.TokenHeader, token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestMiddleware(t *testing.T) {
+	limits := Limits{"claim_search": {Rate: 0.01, Burst: 2}}
+	h := testHandler(NewLimiter(NewMemoryBackend(), limits))
+	search := `{"jsonrpc": "2.0", "method": "claim_search", "params": {}, "id": 7}`
+
+	for i := 0; i < 2; i++ {
+		rr := post(h, search, "", "8.8.8.8")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "ok", rr.Body.String())
+	}
+	rr := post(h, search, "", "8.8.8.8")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "100", rr.Header().Get("Retry-After"))
+
+	var res jsonrpc.RPCResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	require.NotNil(t, res.Error)
+	assert.Equal(t, -32086, res.Error.Code)
+	assert.Equal(t, "too many claim_search queries, retry after 100 seconds", res.Error.Message)
+	assert.Equal(t, 7, res.ID)
+
+	rr = post(h, `{"jsonrpc": "2.0", "method": "resolve", "params": {"urls": "what"}}`, "", "8.8.8.8")
+	assert.Equal(t, http.StatusOK, rr.Code, "methods without limits should not be limited")
+
+	rr = post(h, search, "", "8.8.4.4")
+	assert.Equal(t, http.StatusOK, rr.Code, "other IP addresses should have their own limit")
+
+	for i := 0; i < 2; i++ {
+		rr = post(h, search, "user-token", "8.8.8.8")
+		assert.Equal(t, http.StatusOK, rr.Code, "authenticated users should be limited by their ID")
+	}
+	rr = post(h, search, "user-token", "1.1.1.1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "authenticated users should be limited regardless of their IP")
+}
+
+func TestMiddlewareBatch(t *testing.T) {
+	limits := Limits{"wallet_send": {Rate: 0.01, Burst: 1}}
+	h := testHandler(NewLimiter(NewMemoryBackend(), limits))
+	batch := `[
+		{"jsonrpc": "2.0", "method": "resolve", "params": {"urls": "what"}, "id": 1},
+		{"jsonrpc": "2.0", "method": "wallet_send", "params": {}, "id": 2}
+	]`
+
+	rr := post(h, batch, "user-token", "8.8.8.8")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = post(h, batch, "user-token", "8.8.8.8")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+	var res []jsonrpc.RPCResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res), "rejected batch should get a batch response")
+	require.Len(t, res, 2)
+	for i, r := range res {
+		assert.Equal(t, i+1, r.ID)
+		require.NotNil(t, r.Error)
+		assert.Equal(t, -32086, r.Error.Code)
+	}
+}
+
+func TestMiddlewareBatchRefund(t *testing.T) {
+	limits := Limits{"resolve": {Rate: 0.01, Burst: 1}, "wallet_send": {Rate: 0.01, Burst: 1}}
+	h := testHandler(NewLimiter(NewMemoryBackend(), limits))
+
+	rr := post(h, `{"jsonrpc": "2.0", "method": "wallet_send", "params": {}, "id": 1}`, "user-token", "8.8.8.8")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = post(h, `[
+		{"jsonrpc": "2.0", "method": "resolve", "params": {"urls": "what"}, "id": 1},
+		{"jsonrpc": "2.0", "method": "wallet_send", "params": {}, "id": 2}
+	]`, "user-token", "8.8.8.8")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+	rr = post(h, `{"jsonrpc": "2.0", "method": "resolve", "params": {"urls": "what"}, "id": 1}`, "user-token", "8.8.8.8")
+	assert.Equal(t, http.StatusOK, rr.Code, "tokens taken by a rejected batch should be returned")
+}
+
+func TestMiddlewareBatchIDs(t *testing.T) {
+	limits := Limits{"wallet_send": {Rate: 0.01, Burst: 1}}
+	h := testHandler(NewLimiter(NewMemoryBackend(), limits))
+	batch := `[
+		{"jsonrpc": "2.0", "method": "wallet_send", "params": {}, "id": "first"},
+		{"jsonrpc": "2.0", "method": "wallet_send", "params": {}, "id": 2.5},
+		{"jsonrpc": "2.0", "id": {"not": "valid"}}
+	]`
+
+	rr := post(h, batch, "user-token", "8.8.8.8")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "items with any kind of id should be limited")
+
+	var res []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	require.Len(t, res, 2)
+	assert.Equal(t, "first", res[0]["id"])
+	assert.Equal(t, 2.5, res[1]["id"])
+}
+
+func TestMiddlewarePassThrough(t *testing.T) {
+	limits := Limits{AnyMethod: {Rate: 0.01, Burst: 1}}
+	h := testHandler(NewLimiter(NewMemoryBackend(), limits))
+
+	for _, body := range []string{"", "not json", `{"jsonrpc": "2.0"}`} {
+		for i := 0; i < 3; i++ {
+			rr := post(h, body, "", "8.8.8.8")
+			assert.Equal(t, http.StatusOK, rr.Code, "%q should be left for the handler", body)
+		}
+	}
+
+	h = testHandler(NewLimiter(NewMemoryBackend(), Limits{}))
+	for i := 0; i < 3; i++ {
+		rr := post(h, `{"jsonrpc": "2.0", "method": "resolve"}`, "", "8.8.8.8")
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
+
+func TestMiddlewareBodyPreserved(t *testing.T) {
+	limits := Limits{AnyMethod: {Rate: 1, Burst: 10}}
+	var received string
+	h := middleware.Apply(middleware.Chain(ip.Middleware, Middleware(NewLimiter(NewMemoryBackend(), limits))),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			received = string(b)
+		}))
+	body := `{"jsonrpc": "2.0", "method": "resolve", "params": {"urls": "what"}}`
+	rr := post(h, body, "", "8.8.8.8")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, body, received)
+}
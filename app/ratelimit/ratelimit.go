@@ -0,0 +1,132 @@
+// Package ratelimit throttles SDK queries per user or per IP address using token buckets.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/monitor"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+
+	// AnyMethod is the limit applied to methods without their own limit.
+	AnyMethod = "*"
+)
+
+var logger = monitor.NewModuleLogger("ratelimit")
+
+// Backend keeps token buckets.
+type Backend interface {
+	// Take removes a token from the bucket identified by key, which is refilled at rate tokens per second
+	// and holds up to burst tokens. If the bucket is empty, it returns false and the time until a token is available.
+	Take(key string, rate float64, burst int) (bool, time.Duration, error)
+	// Refund puts a token taken with Take back into the bucket.
+	Refund(key string, rate float64, burst int) error
+}
+
+// Limit is the rate limit of a method.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limits are rate limits by method name.
+type Limits map[string]Limit
+
+// NewLimits validates limits from the config.
+func NewLimits(cfg []config.RateLimit) (Limits, error) {
+	limits := Limits{}
+	for i, l := range cfg {
+		if l.Method == "" {
+			return nil, errors.Err("rate limit #%d has no method", i)
+		}
+		if l.Rate <= 0 || l.Burst <= 0 {
+			return nil, errors.Err("rate limit for %v should have positive rate and burst", l.Method)
+		}
+		limits[l.Method] = Limit{Rate: l.Rate, Burst: l.Burst}
+	}
+	return limits, nil
+}
+
+// For returns the limit of the method, false if the method isn't limited.
+func (l Limits) For(method string) (Limit, bool) {
+	if limit, ok := l[method]; ok {
+		return limit, true
+	}
+	limit, ok := l[AnyMethod]
+	return limit, ok
+}
+
+// Limiter checks queries against the limits.
+type Limiter struct {
+	backend Backend
+	limits  Limits
+}
+
+// NewLimiter creates a limiter keeping its state in the backend.
+func NewLimiter(backend Backend, limits Limits) *Limiter {
+	return &Limiter{backend: backend, limits: limits}
+}
+
+// New creates a limiter with the backend of the given type. redisURL is only used by the redis backend.
+func New(backend, redisURL string, limits Limits) (*Limiter, error) {
+	switch backend {
+	case BackendMemory, "":
+		return NewLimiter(NewMemoryBackend(), limits), nil
+	case BackendRedis:
+		b, err := NewRedisBackend(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewLimiter(b, limits), nil
+	}
+	return nil, fmt.Errorf("unknown rate limit backend: %v", backend)
+}
+
+// Allow takes a token for the method from the bucket of the client (user or IP address).
+// If the client is over the limit, it returns false and the time after which the query can be retried.
+// Backend failures don't prevent queries from going through.
+func (l *Limiter) Allow(client, method string) (bool, time.Duration) {
+	limit, ok := l.limits.For(method)
+	if !ok {
+		return true, 0
+	}
+	allowed, retryAfter, err := l.backend.Take(client+":"+method, limit.Rate, limit.Burst)
+	if err != nil {
+		logger.Log().Errorf("rate limit backend failed: %v", err)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+// Refund returns the token taken by Allow for a query that wasn't sent after all.
+func (l *Limiter) Refund(client, method string) {
+	limit, ok := l.limits.For(method)
+	if !ok {
+		return
+	}
+	if err := l.backend.Refund(client+":"+method, limit.Rate, limit.Burst); err != nil {
+		logger.Log().Errorf("rate limit backend failed: %v", err)
+	}
+}
+
+// Enabled returns true if any limits are set.
+func (l *Limiter) Enabled() bool {
+	return len(l.limits) > 0
+}
+
+// refill returns the number of tokens in the bucket after elapsed time.
+func refill(tokens float64, elapsed time.Duration, rate float64, burst int) float64 {
+	return math.Min(float64(burst), tokens+elapsed.Seconds()*rate)
+}
+
+// waitTime returns how long it takes for the bucket to get a whole token.
+func waitTime(tokens, rate float64) time.Duration {
+	return time.Duration((1 - tokens) / rate * float64(time.Second))
+}
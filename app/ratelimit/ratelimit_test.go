@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a fake time source for backends.
+// onAdvance is called with the new time, so clocks outside of the process can follow it.
+type clock struct {
+	t         time.Time
+	onAdvance func(time.Time)
+}
+
+func (c *clock) now() time.Time { return c.t }
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+	if c.onAdvance != nil {
+		c.onAdvance(c.t)
+	}
+}
+
+func newClock() *clock {
+	return &clock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func testBackend(t *testing.T, b Backend, c *clock) {
+	for i := 0; i < 3; i++ {
+		ok, _, err := b.Take("user:1:claim_search", 2, 3)
+		require.NoError(t, err)
+		assert.True(t, ok, "query %d should be within burst", i)
+	}
+	ok, retryAfter, err := b.Take("user:1:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.InDelta(t, 500*time.Millisecond, retryAfter, float64(10*time.Millisecond))
+
+	ok, _, err = b.Take("user:2:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.True(t, ok, "other clients should have their own buckets")
+
+	c.advance(500 * time.Millisecond)
+	ok, _, err = b.Take("user:1:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.True(t, ok, "a token should be available after refill")
+	ok, _, err = b.Take("user:1:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	c.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _, err = b.Take("user:1:claim_search", 2, 3)
+		require.NoError(t, err)
+		assert.True(t, ok, "bucket should refill up to burst")
+	}
+	ok, _, err = b.Take("user:1:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, b.Refund("user:1:claim_search", 2, 3))
+	ok, _, err = b.Take("user:1:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.True(t, ok, "refunded token should be available")
+	ok, _, err = b.Take("user:1:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, b.Refund("user:3:claim_search", 2, 3))
+	ok, _, err = b.Take("user:3:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, b.Refund("user:3:claim_search", 2, 3))
+	require.NoError(t, b.Refund("user:3:claim_search", 2, 3))
+	for i := 0; i < 3; i++ {
+		ok, _, err = b.Take("user:3:claim_search", 2, 3)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, _, err = b.Take("user:3:claim_search", 2, 3)
+	require.NoError(t, err)
+	assert.False(t, ok, "refunds should not fill the bucket over burst")
+}
+
+func TestNewLimits(t *testing.T) {
+	limits, err := NewLimits([]config.RateLimit{
+		{Method: "claim_search", Rate: 5, Burst: 20},
+		{Method: AnyMethod, Rate: 50, Burst: 100},
+	})
+	require.NoError(t, err)
+
+	l, ok := limits.For("claim_search")
+	assert.True(t, ok)
+	assert.Equal(t, Limit{Rate: 5, Burst: 20}, l)
+	l, ok = limits.For("resolve")
+	assert.True(t, ok)
+	assert.Equal(t, Limit{Rate: 50, Burst: 100}, l)
+
+	limits, err = NewLimits([]config.RateLimit{{Method: "claim_search", Rate: 5, Burst: 20}})
+	require.NoError(t, err)
+	_, ok = limits.For("resolve")
+	assert.False(t, ok)
+
+	_, err = NewLimits([]config.RateLimit{{Rate: 5, Burst: 20}})
+	assert.EqualError(t, err, "rate limit #0 has no method")
+	_, err = NewLimits([]config.RateLimit{{Method: "resolve", Rate: 0, Burst: 20}})
+	assert.EqualError(t, err, "rate limit for resolve should have positive rate and burst")
+}
+
+func TestGetRateLimits(t *testing.T) {
+	config.Override("RateLimits", []map[string]interface{}{{"Method": "wallet_send", "Rate": 0.1, "Burst": 3}})
+	defer config.RestoreOverridden()
+	limits, err := config.GetRateLimits()
+	require.NoError(t, err)
+	assert.Equal(t, []config.RateLimit{{Method: "wallet_send", Rate: 0.1, Burst: 3}}, limits)
+}
+
+func TestNew(t *testing.T) {
+	l, err := New(BackendMemory, "", Limits{})
+	require.NoError(t, err)
+	assert.False(t, l.Enabled())
+	allowed, _ := l.Allow("ip:1.1.1.1", "resolve")
+	assert.True(t, allowed)
+
+	_, err = New("memcached", "", Limits{})
+	assert.EqualError(t, err, "unknown rate limit backend: memcached")
+}
+
+func TestMemoryBackend(t *testing.T) {
+	c := newClock()
+	b := NewMemoryBackend()
+	b.now = c.now
+	testBackend(t, b, c)
+}
+
+func TestMemoryBackendSweep(t *testing.T) {
+	c := newClock()
+	b := NewMemoryBackend()
+	b.now = c.now
+	b.lastSweep = c.now()
+
+	b.Take("user:1:resolve", 1, 5)
+	b.Take("user:2:resolve", 0.001, 5)
+	assert.Len(t, b.buckets, 2)
+
+	c.advance(2 * sweepInterval)
+	b.Take("user:3:resolve", 1, 5)
+	assert.Len(t, b.buckets, 2, "refilled bucket should be removed")
+	assert.NotContains(t, b.buckets, "user:1:resolve")
+}
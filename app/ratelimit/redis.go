@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lbryio/lbrytv/internal/downtime"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	redisKeyPrefix = "lbrytv:ratelimit:"
+	// redisRetryAfter is how long the fallback backend is used after a Redis failure
+	// before Redis is tried again.
+	redisRetryAfter = 10 * time.Second
+	redisTimeout    = 200 * time.Millisecond
+)
+
+// nowScript starts scripts with now, the current Redis time in milliseconds.
+// Buckets are refilled by the Redis clock rather than the ones of API instances, which can be skewed,
+// and never by a clock going backwards. Scripts calling TIME have to replicate their effects instead of themselves.
+const nowScript = `
+redis.replicate_commands()
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+`
+
+// takeScript refills the bucket, takes a token from it if there is one and sets the bucket to expire when it's full.
+// It returns 1 and the number of remaining tokens if a token was taken, 0 and the number of tokens otherwise.
+var takeScript = redis.NewScript(nowScript + `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local b = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(b[1])
+local ts = tonumber(b[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+now = math.max(now, ts)
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// refundScript refills the bucket and puts a token back into it, if the bucket still exists.
+var refundScript = redis.NewScript(nowScript + `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local b = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(b[1])
+local ts = tonumber(b[2])
+if tokens == nil or ts == nil then
+	return 0
+end
+now = math.max(now, ts)
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate + 1)
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return 1
+`)
+
+// redisBackend keeps token buckets in Redis so limits are shared by multiple API instances.
+// When Redis is unavailable, it falls back to buckets kept in memory.
+type redisBackend struct {
+	client   *redis.Client
+	fallback *memoryBackend
+
+	// health keeps Redis marked as unavailable for redisRetryAfter after it fails
+	health *downtime.Tracker
+}
+
+// NewRedisBackend creates a Redis-backed token bucket store. url should be in the redis://[:password@]host[:port][/db] form.
+func NewRedisBackend(url string) (*redisBackend, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	opts.DialTimeout = redisTimeout
+	opts.ReadTimeout = redisTimeout
+	opts.WriteTimeout = redisTimeout
+	opts.MaxRetries = 0
+
+	b := &redisBackend{
+		client:   redis.NewClient(opts),
+		fallback: NewMemoryBackend(),
+		health:   downtime.NewTracker(redisRetryAfter),
+	}
+	if err := b.client.Ping().Err(); err != nil {
+		logger.Log().Errorf("redis at %v is not available, keeping rate limits in memory until it is: %v", opts.Addr, err)
+		b.health.MarkDown()
+	}
+	return b, nil
+}
+
+func (b *redisBackend) Take(key string, rate float64, burst int) (bool, time.Duration, error) {
+	if !b.health.IsUp() {
+		return b.fallback.Take(key, rate, burst)
+	}
+	res, err := takeScript.Run(b.client, []string{redisKeyPrefix + key}, rate, burst).Result()
+	if err != nil {
+		logger.Log().Errorf("redis rate limit backend failed, keeping rate limits in memory: %v", err)
+		b.health.MarkDown()
+		return b.fallback.Take(key, rate, burst)
+	}
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return b.fallback.Take(key, rate, burst)
+	}
+	allowed, _ := vals[0].(int64)
+	tokensStr, _ := vals[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return b.fallback.Take(key, rate, burst)
+	}
+	if allowed == 1 {
+		return true, 0, nil
+	}
+	return false, waitTime(tokens, rate), nil
+}
+
+func (b *redisBackend) Refund(key string, rate float64, burst int) error {
+	if !b.health.IsUp() {
+		return b.fallback.Refund(key, rate, burst)
+	}
+	err := refundScript.Run(b.client, []string{redisKeyPrefix + key}, rate, burst).Err()
+	if err != nil {
+		logger.Log().Errorf("redis rate limit backend failed, keeping rate limits in memory: %v", err)
+		b.health.MarkDown()
+		return b.fallback.Refund(key, rate, burst)
+	}
+	return nil
+}
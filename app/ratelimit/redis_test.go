@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisBackend(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// buckets are refilled by the Redis clock
+	c := newClock()
+	c.onAdvance = srv.SetTime
+	srv.SetTime(c.t)
+	b, err := NewRedisBackend("redis://" + srv.Addr())
+	require.NoError(t, err)
+	testBackend(t, b, c)
+	assert.True(t, b.health.IsUp())
+	assert.True(t, srv.Exists(redisKeyPrefix+"user:1:claim_search"))
+	assert.Greater(t, int64(srv.TTL(redisKeyPrefix+"user:1:claim_search")), int64(0))
+}
+
+func TestRedisBackendShared(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	b1, err := NewRedisBackend("redis://" + srv.Addr())
+	require.NoError(t, err)
+	b2, err := NewRedisBackend("redis://" + srv.Addr())
+	require.NoError(t, err)
+
+	ok, _, err := b1.Take("ip:1.1.1.1:resolve", 0.01, 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, retryAfter, err := b2.Take("ip:1.1.1.1:resolve", 0.01, 1)
+	require.NoError(t, err)
+	assert.False(t, ok, "bucket should be shared by instances")
+	assert.Greater(t, int64(retryAfter), int64(time.Minute))
+}
+
+func TestRedisBackendClockSkew(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+	c := newClock()
+	srv.SetTime(c.t)
+
+	b, err := NewRedisBackend("redis://" + srv.Addr())
+	require.NoError(t, err)
+	ok, _, err := b.Take("ip:1.1.1.1:resolve", 1, 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// API instance clocks don't matter, only the Redis one does
+	ok, _, err = b.Take("ip:1.1.1.1:resolve", 1, 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	srv.SetTime(c.t.Add(-time.Hour))
+	ok, _, err = b.Take("ip:1.1.1.1:resolve", 1, 1)
+	require.NoError(t, err)
+	assert.False(t, ok, "a clock going backwards should not refill buckets")
+	srv.SetTime(c.t.Add(time.Second))
+	ok, _, err = b.Take("ip:1.1.1.1:resolve", 1, 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestRedisBackendFallback(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	b, err := NewRedisBackend("redis://" + srv.Addr())
+	require.NoError(t, err)
+	srv.Close()
+
+	ok, _, err := b.Take("ip:1.1.1.1:resolve", 0.01, 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, b.health.IsUp())
+	ok, _, err = b.Take("ip:1.1.1.1:resolve", 0.01, 1)
+	require.NoError(t, err)
+	assert.False(t, ok, "fallback should keep limiting")
+
+	_, err = NewRedisBackend("http://wrong")
+	assert.Error(t, err)
+}
@@ -16,7 +16,13 @@ const (
 	rpcErrorCodeSDK              int = -32603 // otherwise-unspecified errors from the SDK
 	rpcErrorCodeAuthRequired     int = -32084 // auth info is required but is not provided
 	rpcErrorCodeForbidden        int = -32085 // auth info is provided but is not found in the database
+	rpcErrorCodeRateLimited      int = -32086 // the client is calling the method too often
+	rpcErrorCodeServerBusy       int = -32087 // the SDK server has too many queries in flight
+	rpcErrorCodeCircuitOpen      int = -32088 // the SDK server has been failing recently and is not called
+	rpcErrorCodeTimeout          int = -32089 // the SDK server hasn't responded in time
+	rpcErrorCodeSpendingLimit    int = -32090 // the query would take the user over their spending limits
 	rpcErrorCodeJSONParse        int = -32700 // invalid JSON was received by the server
+	rpcErrorCodeInvalidRequest   int = -32600 // the JSON sent is not a valid request object
 	rpcErrorCodeInvalidParams    int = -32602 // error in params that the client provided
 	rpcErrorCodeMethodNotAllowed int = -32601 // the requested method is not allowed to be called
 )
@@ -36,30 +42,45 @@ func (e RPCError) Error() string {
 }
 
 func (e RPCError) JSON() []byte {
-	b, err := json.MarshalIndent(jsonrpc.RPCResponse{
+	b, err := json.MarshalIndent(e.Response(0), "", "  ")
+	if err != nil {
+		logger.Log().Errorf("rpc error to json: %v", err)
+	}
+	return b
+}
+
+// Response wraps the error into a JSON-RPC response object for the request with the supplied id.
+func (e RPCError) Response(id int) *jsonrpc.RPCResponse {
+	return &jsonrpc.RPCResponse{
 		Error: &jsonrpc.RPCError{
 			Code:    e.Code(),
 			Message: e.Error(),
 		},
 		JSONRPC: "2.0",
-	}, "", "  ")
-	if err != nil {
-		logger.Log().Errorf("rpc error to json: %v", err)
+		ID:      id,
 	}
-	return b
 }
 
 var ErrAuthRequired = errors.Base(responses.AuthRequiredErrorMessage)
 
+// ErrTimeout is wrapped by errors of queries which took longer than their method timeout.
+var ErrTimeout = errors.Base("sdk query timed out")
+
 func newRPCErr(e error, code int) RPCError { return RPCError{errors.Err(e), code} }
 
 func NewInternalError(e error) RPCError         { return newRPCErr(e, rpcErrorCodeInternal) }
 func NewJSONParseError(e error) RPCError        { return newRPCErr(e, rpcErrorCodeJSONParse) }
+func NewInvalidRequestError(e error) RPCError   { return newRPCErr(e, rpcErrorCodeInvalidRequest) }
 func NewMethodNotAllowedError(e error) RPCError { return newRPCErr(e, rpcErrorCodeMethodNotAllowed) }
 func NewInvalidParamsError(e error) RPCError    { return newRPCErr(e, rpcErrorCodeInvalidParams) }
 func NewSDKError(e error) RPCError              { return newRPCErr(e, rpcErrorCodeSDK) }
 func NewForbiddenError(e error) RPCError        { return newRPCErr(e, rpcErrorCodeForbidden) }
 func NewAuthRequiredError() RPCError            { return newRPCErr(ErrAuthRequired, rpcErrorCodeAuthRequired) }
+func NewRateLimitedError(e error) RPCError      { return newRPCErr(e, rpcErrorCodeRateLimited) }
+func NewServerBusyError(e error) RPCError       { return newRPCErr(e, rpcErrorCodeServerBusy) }
+func NewCircuitOpenError(e error) RPCError      { return newRPCErr(e, rpcErrorCodeCircuitOpen) }
+func NewTimeoutError(e error) RPCError          { return newRPCErr(e, rpcErrorCodeTimeout) }
+func NewSpendingLimitError(e error) RPCError    { return newRPCErr(e, rpcErrorCodeSpendingLimit) }
 
 func isJSONParseError(err error) bool {
 	var e RPCError
@@ -81,3 +102,12 @@ func ToJSON(err error) []byte {
 	}
 	return NewInternalError(err).JSON()
 }
+
+// ToResponse converts error into a JSON-RPC response object for the request with the supplied id.
+func ToResponse(err error, id int) *jsonrpc.RPCResponse {
+	var e RPCError
+	if errors.As(err, &e) {
+		return e.Response(id)
+	}
+	return NewInternalError(err).Response(id)
+}
@@ -0,0 +1,276 @@
+package sdkrouter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+)
+
+// BreakerState is the state of an SDK endpoint circuit breaker.
+type BreakerState int
+
+const (
+	// StateClosed lets all calls through.
+	StateClosed BreakerState = iota
+	// StateHalfOpen lets a few probe calls through to check if the endpoint has recovered.
+	StateHalfOpen
+	// StateOpen fails all calls right away.
+	StateOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half_open"
+	case StateOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// ErrCircuitOpen is returned for calls to an endpoint which has been failing or responding too slowly recently.
+var ErrCircuitOpen = errors.Base("circuit breaker is open")
+
+// BreakerSettings define when circuit breakers trip and recover.
+// A breaker opens when, over Window, at least MinRequests calls were made and the share of them
+// that failed reached ErrorRate or the share of them that took longer than SlowCall reached SlowCallRate.
+// After OpenDuration, HalfOpenRequests probe calls are let through: if all of them succeed in time,
+// the breaker closes, otherwise it opens again.
+type BreakerSettings struct {
+	Window           time.Duration
+	MinRequests      int
+	ErrorRate        float64
+	SlowCall         time.Duration
+	SlowCallRate     float64
+	OpenDuration     time.Duration
+	HalfOpenRequests int
+}
+
+// NewBreakerSettings validates circuit breaker settings from the config.
+// Zero settings disable circuit breakers.
+func NewBreakerSettings(cfg config.SDKCircuitBreaker) (BreakerSettings, error) {
+	s := BreakerSettings{
+		Window:           time.Duration(cfg.Window) * time.Second,
+		MinRequests:      cfg.MinRequests,
+		ErrorRate:        cfg.ErrorRate,
+		SlowCall:         time.Duration(cfg.SlowCall) * time.Second,
+		SlowCallRate:     cfg.SlowCallRate,
+		OpenDuration:     time.Duration(cfg.OpenDuration) * time.Second,
+		HalfOpenRequests: cfg.HalfOpenRequests,
+	}
+	if !s.enabled() {
+		return s, nil
+	}
+	if s.ErrorRate < 0 || s.ErrorRate > 1 || s.SlowCallRate < 0 || s.SlowCallRate > 1 {
+		return s, errors.Err("circuit breaker ErrorRate and SlowCallRate should be between 0 and 1")
+	}
+	if s.SlowCallRate > 0 && s.SlowCall <= 0 {
+		return s, errors.Err("circuit breaker SlowCall should be positive when SlowCallRate is set")
+	}
+	if s.Window <= 0 || s.MinRequests <= 0 || s.OpenDuration <= 0 || s.HalfOpenRequests <= 0 {
+		return s, errors.Err("circuit breaker Window, MinRequests, OpenDuration and HalfOpenRequests should be positive")
+	}
+	return s, nil
+}
+
+func (s BreakerSettings) enabled() bool {
+	return s.ErrorRate > 0 || s.SlowCallRate > 0
+}
+
+// breaker is the circuit breaker of a single endpoint.
+type breaker struct {
+	mu      sync.Mutex
+	address string
+	state   BreakerState
+	// generation changes with every state transition so outcomes of calls admitted in a previous state are ignored
+	generation int64
+
+	windowStart time.Time
+	requests    int
+	failures    int
+	slow        int
+
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+// BreakerCall is a call admitted by a circuit breaker. Its outcome should be reported with Done,
+// or Cancel if the call was never made.
+type BreakerCall struct {
+	b          *Breakers
+	br         *breaker
+	generation int64
+}
+
+// Breakers keeps a circuit breaker for each SDK endpoint.
+type Breakers struct {
+	mu       sync.Mutex
+	settings BreakerSettings
+	breakers map[string]*breaker
+	now      func() time.Time
+}
+
+// NewBreakers creates circuit breakers with the given settings. Zero settings let all calls through.
+func NewBreakers(s BreakerSettings) *Breakers {
+	return &Breakers{settings: s, breakers: map[string]*breaker{}, now: time.Now}
+}
+
+// Allow admits a call to the endpoint at address, returning ErrCircuitOpen if the call should not be made.
+func (b *Breakers) Allow(address string) (BreakerCall, error) {
+	if !b.settings.enabled() {
+		return BreakerCall{}, nil
+	}
+	br := b.breaker(address)
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	now := b.now()
+	if br.state == StateOpen {
+		if now.Sub(br.openedAt) < b.settings.OpenDuration {
+			return BreakerCall{}, errors.Err(ErrCircuitOpen)
+		}
+		b.transition(br, StateHalfOpen, now)
+	}
+	if br.state == StateHalfOpen {
+		if br.probes >= b.settings.HalfOpenRequests {
+			return BreakerCall{}, errors.Err(ErrCircuitOpen)
+		}
+		br.probes++
+	}
+	return BreakerCall{b: b, br: br, generation: br.generation}, nil
+}
+
+// State returns the state of the circuit breaker of the endpoint at address.
+func (b *Breakers) State(address string) BreakerState {
+	if !b.settings.enabled() {
+		return StateClosed
+	}
+	br := b.breaker(address)
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if br.state == StateOpen && b.now().Sub(br.openedAt) >= b.settings.OpenDuration {
+		return StateHalfOpen
+	}
+	return br.state
+}
+
+func (b *Breakers) breaker(address string) *breaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br, ok := b.breakers[address]
+	if !ok {
+		br = &breaker{address: address, windowStart: b.now()}
+		b.breakers[address] = br
+	}
+	return br
+}
+
+// transition should be called with br.mu held.
+func (b *Breakers) transition(br *breaker, state BreakerState, now time.Time) {
+	if state == StateOpen {
+		logger.Log().Warnf("circuit breaker for %v is open after %d failed and %d slow out of %d calls",
+			br.address, br.failures, br.slow, br.requests)
+	} else {
+		logger.Log().Infof("circuit breaker for %v is %v", br.address, state)
+	}
+	br.state = state
+	br.generation++
+	br.windowStart = now
+	br.requests, br.failures, br.slow = 0, 0, 0
+	br.probes, br.successes = 0, 0
+	if state == StateOpen {
+		br.openedAt = now
+	}
+	metrics.ProxySDKCircuitState.WithLabelValues(br.address).Set(float64(state))
+	metrics.ProxySDKCircuitTransitionCount.WithLabelValues(br.address, state.String()).Inc()
+}
+
+// Done reports the outcome of the call. err should only be set for failures that are the endpoint's fault.
+func (c BreakerCall) Done(latency time.Duration, err error) {
+	if c.br == nil {
+		return
+	}
+	s := c.b.settings
+	br := c.br
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if br.generation != c.generation {
+		return
+	}
+
+	now := c.b.now()
+	slow := s.SlowCallRate > 0 && latency >= s.SlowCall
+	switch br.state {
+	case StateHalfOpen:
+		if err != nil || slow {
+			c.b.transition(br, StateOpen, now)
+			return
+		}
+		br.successes++
+		if br.successes >= s.HalfOpenRequests {
+			c.b.transition(br, StateClosed, now)
+		}
+	case StateClosed:
+		if now.Sub(br.windowStart) > s.Window {
+			br.windowStart = now
+			br.requests, br.failures, br.slow = 0, 0, 0
+		}
+		br.requests++
+		if err != nil {
+			br.failures++
+		}
+		if slow {
+			br.slow++
+		}
+		if br.requests < s.MinRequests {
+			return
+		}
+		requests := float64(br.requests)
+		if (s.ErrorRate > 0 && float64(br.failures)/requests >= s.ErrorRate) ||
+			(s.SlowCallRate > 0 && float64(br.slow)/requests >= s.SlowCallRate) {
+			c.b.transition(br, StateOpen, now)
+		}
+	}
+}
+
+// Cancel releases the call without reporting an outcome, for calls that were admitted but not made.
+func (c BreakerCall) Cancel() {
+	if c.br == nil {
+		return
+	}
+	c.br.mu.Lock()
+	defer c.br.mu.Unlock()
+	if c.br.generation == c.generation && c.br.state == StateHalfOpen {
+		c.br.probes--
+	}
+}
+
+// SetBreakerSettings replaces circuit breakers of all servers of the router with new ones using the settings.
+func (r *Router) SetBreakerSettings(s BreakerSettings) {
+	r.gatesMu.Lock()
+	defer r.gatesMu.Unlock()
+	r.breakers = NewBreakers(s)
+}
+
+// AllowCall admits a call to the server at address using settings set by SetBreakerSettings,
+// see Breakers.Allow.
+func (r *Router) AllowCall(address string) (BreakerCall, error) {
+	return r.currentBreakers().Allow(address)
+}
+
+// CircuitOpen returns true if calls to the server at address currently fail fast.
+func (r *Router) CircuitOpen(address string) bool {
+	return r.currentBreakers().State(address) == StateOpen
+}
+
+func (r *Router) currentBreakers() *Breakers {
+	r.gatesMu.RLock()
+	defer r.gatesMu.RUnlock()
+	return r.breakers
+}
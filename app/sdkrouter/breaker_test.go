@@ -0,0 +1,178 @@
+package sdkrouter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTest = errors.Base("connection refused")
+
+func newTestBreakers() (*Breakers, *time.Time) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBreakers(BreakerSettings{
+		Window:           time.Minute,
+		MinRequests:      4,
+		ErrorRate:        0.5,
+		SlowCall:         10 * time.Second,
+		SlowCallRate:     0.75,
+		OpenDuration:     30 * time.Second,
+		HalfOpenRequests: 2,
+	})
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func call(t *testing.T, b *Breakers, latency time.Duration, err error) {
+	c, allowErr := b.Allow("http://lbrynet1:5279/")
+	require.NoError(t, allowErr)
+	c.Done(latency, err)
+}
+
+func TestNewBreakerSettings(t *testing.T) {
+	s, err := NewBreakerSettings(config.SDKCircuitBreaker{})
+	require.NoError(t, err)
+	assert.False(t, s.enabled())
+
+	s, err = NewBreakerSettings(config.SDKCircuitBreaker{
+		Window: 60, MinRequests: 20, ErrorRate: 0.5, SlowCall: 30, SlowCallRate: 0.5, OpenDuration: 30, HalfOpenRequests: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, BreakerSettings{
+		Window: time.Minute, MinRequests: 20, ErrorRate: 0.5, SlowCall: 30 * time.Second, SlowCallRate: 0.5,
+		OpenDuration: 30 * time.Second, HalfOpenRequests: 3,
+	}, s)
+
+	_, err = NewBreakerSettings(config.SDKCircuitBreaker{ErrorRate: 1.5})
+	assert.EqualError(t, err, "circuit breaker ErrorRate and SlowCallRate should be between 0 and 1")
+	_, err = NewBreakerSettings(config.SDKCircuitBreaker{SlowCallRate: 0.5})
+	assert.EqualError(t, err, "circuit breaker SlowCall should be positive when SlowCallRate is set")
+	_, err = NewBreakerSettings(config.SDKCircuitBreaker{ErrorRate: 0.5})
+	assert.EqualError(t, err, "circuit breaker Window, MinRequests, OpenDuration and HalfOpenRequests should be positive")
+}
+
+func TestBreakersDisabled(t *testing.T) {
+	b := NewBreakers(BreakerSettings{})
+	for i := 0; i < 10; i++ {
+		call(t, b, time.Hour, errTest)
+	}
+	assert.Equal(t, StateClosed, b.State("http://lbrynet1:5279/"))
+}
+
+func TestBreakersOpenOnErrors(t *testing.T) {
+	b, now := newTestBreakers()
+	addr := "http://lbrynet1:5279/"
+
+	call(t, b, time.Second, errTest)
+	call(t, b, time.Second, errTest)
+	call(t, b, time.Second, errTest)
+	assert.Equal(t, StateClosed, b.State(addr), "should not trip before MinRequests")
+	call(t, b, time.Second, nil)
+	assert.Equal(t, StateOpen, b.State(addr))
+
+	_, err := b.Allow(addr)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	_, err = b.Allow("http://lbrynet2:5279/")
+	assert.NoError(t, err, "other endpoints should not be affected")
+
+	*now = now.Add(30 * time.Second)
+	assert.Equal(t, StateHalfOpen, b.State(addr))
+	call(t, b, time.Second, nil)
+	assert.Equal(t, StateHalfOpen, b.State(addr))
+	call(t, b, time.Second, nil)
+	assert.Equal(t, StateClosed, b.State(addr))
+}
+
+func TestBreakersOpenOnSlowCalls(t *testing.T) {
+	b, _ := newTestBreakers()
+	addr := "http://lbrynet1:5279/"
+
+	call(t, b, time.Second, nil)
+	for i := 0; i < 3; i++ {
+		call(t, b, 10*time.Second, nil)
+	}
+	assert.Equal(t, StateOpen, b.State(addr))
+}
+
+func TestBreakersWindow(t *testing.T) {
+	b, now := newTestBreakers()
+	addr := "http://lbrynet1:5279/"
+
+	call(t, b, time.Second, errTest)
+	call(t, b, time.Second, errTest)
+	*now = now.Add(2 * time.Minute)
+	call(t, b, time.Second, errTest)
+	call(t, b, time.Second, nil)
+	call(t, b, time.Second, nil)
+	assert.Equal(t, StateClosed, b.State(addr), "failures from the previous window should not count")
+	call(t, b, time.Second, nil)
+	assert.Equal(t, StateClosed, b.State(addr))
+}
+
+func TestBreakersHalfOpen(t *testing.T) {
+	b, now := newTestBreakers()
+	addr := "http://lbrynet1:5279/"
+	for i := 0; i < 4; i++ {
+		call(t, b, time.Second, errTest)
+	}
+	*now = now.Add(30 * time.Second)
+
+	probe1, err := b.Allow(addr)
+	require.NoError(t, err)
+	probe2, err := b.Allow(addr)
+	require.NoError(t, err)
+	_, err = b.Allow(addr)
+	assert.True(t, errors.Is(err, ErrCircuitOpen), "only HalfOpenRequests probes should be let through")
+
+	probe2.Cancel()
+	probe2, err = b.Allow(addr)
+	require.NoError(t, err, "cancelled probe should free its place")
+
+	probe1.Done(time.Second, nil)
+	probe2.Done(time.Second, errTest)
+	assert.Equal(t, StateOpen, b.State(addr), "failed probe should open the breaker again")
+
+	*now = now.Add(29 * time.Second)
+	_, err = b.Allow(addr)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+}
+
+func TestBreakersIgnoreStaleCalls(t *testing.T) {
+	b, now := newTestBreakers()
+	addr := "http://lbrynet1:5279/"
+
+	stale, err := b.Allow(addr)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		call(t, b, time.Second, errTest)
+	}
+	*now = now.Add(30 * time.Second)
+	call(t, b, time.Second, nil)
+	stale.Done(time.Minute, errTest)
+	assert.Equal(t, StateHalfOpen, b.State(addr), "calls made before the breaker opened should not count")
+}
+
+func TestRouterSkipsOpenCircuits(t *testing.T) {
+	bad := &models.LbrynetServer{Name: "bad", Address: "http://bad:5279/"}
+	good := &models.LbrynetServer{Name: "good", Address: "http://good:5279/"}
+	r := NewWithServers(bad, good)
+	r.SetBreakerSettings(BreakerSettings{
+		Window: time.Minute, MinRequests: 1, ErrorRate: 0.5, OpenDuration: time.Hour, HalfOpenRequests: 1,
+	})
+
+	c, err := r.AllowCall(bad.Address)
+	require.NoError(t, err)
+	c.Done(time.Second, errTest)
+	assert.True(t, r.CircuitOpen(bad.Address))
+	assert.False(t, r.IsHealthy(bad))
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, good, r.RandomServer())
+	}
+}
@@ -47,13 +47,13 @@ func TestRouterConcurrency(t *testing.T) {
 			case 0:
 				r.RandomServer()
 				r.GetAll()
-				r.LeastLoaded()
+				r.PickServer()
 			case 1:
 				r.GetAll()
-				r.LeastLoaded()
+				r.PickServer()
 				r.RandomServer()
 			case 2:
-				r.LeastLoaded()
+				r.PickServer()
 				r.RandomServer()
 				r.GetAll()
 			}
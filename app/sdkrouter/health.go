@@ -0,0 +1,181 @@
+package sdkrouter
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/internal/metrics"
+	"github.com/lbryio/lbrytv/models"
+
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+)
+
+const (
+	// unhealthyAfterFailures is the number of consecutive failed probes after which
+	// a server stops receiving new users and anonymous traffic.
+	unhealthyAfterFailures = 2
+	// healthyAfterSuccesses is the number of consecutive successful probes
+	// an unhealthy server needs to pass before it's brought back.
+	healthyAfterSuccesses = 2
+	// maxProbeTimeout caps how long a single probe can take, regardless of the probe interval.
+	maxProbeTimeout = 10 * time.Second
+)
+
+// ServerHealth is a summary of recent probes of an LbrynetServer.
+type ServerHealth struct {
+	Healthy              bool      `json:"healthy"`
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes"`
+	LastSuccess          time.Time `json:"last_success"`
+	LastError            string    `json:"last_error,omitempty"`
+	// Latency is the duration of the last probe in seconds
+	Latency float64 `json:"latency"`
+}
+
+// WatchHealth keeps probing lbrynet servers every interval, see Router.Health.
+func (r *Router) WatchHealth(interval time.Duration) {
+	if interval <= 0 {
+		logger.Log().Warn("lbrynet server health probes are disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	timeout := probeTimeout(interval)
+
+	r.probeHealth(timeout)
+	time.Sleep(time.Duration(rand.Int63n(int64(interval)))) // stagger these so they don't all happen at the same time for every api server
+
+	for {
+		<-ticker.C
+		r.probeHealth(timeout)
+	}
+}
+
+// probeTimeout is how long a probe can take before it fails, half the interval and no more than maxProbeTimeout.
+func probeTimeout(interval time.Duration) time.Duration {
+	if interval/2 > maxProbeTimeout {
+		return maxProbeTimeout
+	}
+	return interval / 2
+}
+
+// probeHealth calls `status` on all servers at once and records the outcome.
+// Probes taking longer than timeout fail, so a hung server doesn't hold up the others.
+// Servers that are no longer on the list are forgotten.
+func (r *Router) probeHealth(timeout time.Duration) {
+	servers := r.GetAll()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *models.LbrynetServer) {
+			defer wg.Done()
+			client := ljsonrpc.NewClient(server.Address)
+			client.SetRPCTimeout(timeout)
+			start := time.Now()
+			_, err := client.Status()
+			if err != nil {
+				logger.Log().Errorf("lbrynet instance %s failed health probe: %v", server.Address, err)
+			}
+			r.recordProbe(server, time.Since(start), err)
+		}(server)
+	}
+	wg.Wait()
+	r.pruneHealth(servers)
+}
+
+// pruneHealth removes health records and metrics of servers which are not in the list.
+func (r *Router) pruneHealth(servers []*models.LbrynetServer) {
+	current := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		current[s.Address] = true
+	}
+
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	for address := range r.health {
+		if current[address] {
+			continue
+		}
+		delete(r.health, address)
+		metrics.LbrynetServerHealthy.DeleteLabelValues(address)
+		metrics.LbrynetServerConsecutiveFailures.DeleteLabelValues(address)
+		metrics.LbrynetServerProbeDurations.DeleteLabelValues(address)
+	}
+}
+
+// recordProbe updates server health with the result of a single probe.
+func (r *Router) recordProbe(server *models.LbrynetServer, latency time.Duration, probeErr error) {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+
+	if r.health == nil {
+		r.health = map[string]*ServerHealth{}
+	}
+	h, ok := r.health[server.Address]
+	if !ok {
+		h = &ServerHealth{Healthy: true}
+		r.health[server.Address] = h
+	}
+
+	h.Latency = latency.Seconds()
+	if probeErr != nil {
+		h.ConsecutiveFailures++
+		h.ConsecutiveSuccesses = 0
+		h.LastError = probeErr.Error()
+		if h.Healthy && h.ConsecutiveFailures >= unhealthyAfterFailures {
+			h.Healthy = false
+			logger.Log().Warnf("lbrynet instance %s marked unhealthy after %d failed probes", server.Address, h.ConsecutiveFailures)
+		}
+	} else {
+		h.ConsecutiveSuccesses++
+		h.ConsecutiveFailures = 0
+		h.LastSuccess = time.Now()
+		h.LastError = ""
+		if !h.Healthy && h.ConsecutiveSuccesses >= healthyAfterSuccesses {
+			h.Healthy = true
+			logger.Log().Infof("lbrynet instance %s is healthy again", server.Address)
+		}
+	}
+
+	var healthy float64
+	if h.Healthy {
+		healthy = 1
+	}
+	metrics.LbrynetServerHealthy.WithLabelValues(server.Address).Set(healthy)
+	metrics.LbrynetServerConsecutiveFailures.WithLabelValues(server.Address).Set(float64(h.ConsecutiveFailures))
+	metrics.LbrynetServerProbeDurations.WithLabelValues(server.Address).Observe(h.Latency)
+}
+
+// Health returns the current health of the server.
+// Servers that haven't been probed yet are considered healthy.
+func (r *Router) Health(server *models.LbrynetServer) ServerHealth {
+	r.healthMu.RLock()
+	defer r.healthMu.RUnlock()
+
+	if h, ok := r.health[server.Address]; ok {
+		return *h
+	}
+	return ServerHealth{Healthy: true}
+}
+
+// IsHealthy returns true if the server can be given new users and anonymous traffic,
+// i.e. it passes its probes and its circuit breaker is not open.
+func (r *Router) IsHealthy(server *models.LbrynetServer) bool {
+	return r.Health(server).Healthy && !r.CircuitOpen(server.Address)
+}
+
+// healthyServers filters out servers which failed their recent probes or recent calls.
+// If all servers are unhealthy, the full list is returned so the traffic still has somewhere to go.
+func (r *Router) healthyServers(servers []*models.LbrynetServer) []*models.LbrynetServer {
+	healthy := make([]*models.LbrynetServer, 0, len(servers))
+	for _, s := range servers {
+		if r.IsHealthy(s) {
+			healthy = append(healthy, s)
+		}
+	}
+	if len(healthy) == 0 {
+		logger.Log().Error("no healthy lbrynet servers available, using all of them")
+		return servers
+	}
+	return healthy
+}
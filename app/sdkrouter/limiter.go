@@ -0,0 +1,166 @@
+package sdkrouter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+)
+
+// AnyServer is the concurrency limit applied to servers without their own limit.
+const AnyServer = "*"
+
+// ErrServerBusy is returned when a query cannot be sent to a server because it has too many queries in flight
+// and too many waiting for their turn.
+var ErrServerBusy = errors.Base("server busy")
+
+// ConcurrencyLimit is the number of queries that can be sent to a server at the same time.
+type ConcurrencyLimit struct {
+	MaxConcurrent int
+	// MaxQueue is the number of queries that can wait for a slot, queries beyond that are rejected right away
+	MaxQueue int
+	// QueueTimeout is how long a query can wait for a slot
+	QueueTimeout time.Duration
+}
+
+// ConcurrencyLimits are concurrency limits by server address.
+type ConcurrencyLimits map[string]ConcurrencyLimit
+
+// NewConcurrencyLimits validates concurrency limits from the config.
+func NewConcurrencyLimits(cfg []config.SDKConcurrencyLimit) (ConcurrencyLimits, error) {
+	limits := ConcurrencyLimits{}
+	for i, l := range cfg {
+		if l.Server == "" {
+			return nil, errors.Err("concurrency limit #%d has no server", i)
+		}
+		if l.MaxConcurrent <= 0 || l.MaxQueue < 0 || l.QueueTimeout < 0 {
+			return nil, errors.Err("concurrency limit for %v should have positive MaxConcurrent and non-negative MaxQueue and QueueTimeout", l.Server)
+		}
+		limits[l.Server] = ConcurrencyLimit{
+			MaxConcurrent: l.MaxConcurrent,
+			MaxQueue:      l.MaxQueue,
+			QueueTimeout:  time.Duration(l.QueueTimeout) * time.Second,
+		}
+	}
+	return limits, nil
+}
+
+// For returns the limit of the server, false if the server isn't limited.
+func (l ConcurrencyLimits) For(address string) (ConcurrencyLimit, bool) {
+	if limit, ok := l[address]; ok {
+		return limit, true
+	}
+	limit, ok := l[AnyServer]
+	return limit, ok
+}
+
+// gate holds in-flight query slots of a single server.
+type gate struct {
+	limit  ConcurrencyLimit
+	slots  chan struct{}
+	queued int64
+}
+
+// ConcurrencyLimiter keeps the number of queries in flight to each server within its limit.
+// Queries over the limit wait in a per-server queue until a slot is freed.
+type ConcurrencyLimiter struct {
+	mu     sync.Mutex
+	limits ConcurrencyLimits
+	gates  map[string]*gate
+}
+
+// NewConcurrencyLimiter creates a limiter. Servers without a limit are not limited.
+func NewConcurrencyLimiter(limits ConcurrencyLimits) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{limits: limits, gates: map[string]*gate{}}
+}
+
+// Acquire takes an in-flight slot of the server at address, waiting for one in the queue if needed.
+// The returned function should be called to free the slot once the query is done.
+// ErrServerBusy is returned when the queue is full or the query hasn't got a slot in time,
+// ctx error if it is done while waiting.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, address string) (func(), error) {
+	g := l.gate(address)
+	if g == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case g.slots <- struct{}{}:
+		return g.release, nil
+	default:
+	}
+
+	if atomic.AddInt64(&g.queued, 1) > int64(g.limit.MaxQueue) {
+		atomic.AddInt64(&g.queued, -1)
+		metrics.ProxySDKBusyCount.WithLabelValues(address).Inc()
+		return nil, errors.Err(ErrServerBusy)
+	}
+	metrics.ProxySDKQueueDepth.WithLabelValues(address).Inc()
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&g.queued, -1)
+		metrics.ProxySDKQueueDepth.WithLabelValues(address).Dec()
+		metrics.ProxySDKQueueWaitDurations.WithLabelValues(address).Observe(time.Since(start).Seconds())
+	}()
+
+	timer := time.NewTimer(g.limit.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case g.slots <- struct{}{}:
+		return g.release, nil
+	case <-timer.C:
+		metrics.ProxySDKBusyCount.WithLabelValues(address).Inc()
+		return nil, errors.Err(ErrServerBusy)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// InFlight returns the number of queries currently sent to the server at address.
+func (l *ConcurrencyLimiter) InFlight(address string) int {
+	g := l.gate(address)
+	if g == nil {
+		return 0
+	}
+	return len(g.slots)
+}
+
+func (l *ConcurrencyLimiter) gate(address string) *gate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if g, ok := l.gates[address]; ok {
+		return g
+	}
+	limit, ok := l.limits.For(address)
+	if !ok {
+		return nil
+	}
+	g := &gate{limit: limit, slots: make(chan struct{}, limit.MaxConcurrent)}
+	l.gates[address] = g
+	return g
+}
+
+func (g *gate) release() {
+	<-g.slots
+}
+
+// SetConcurrencyLimits replaces limits of in-flight queries for all servers of the router.
+// Queries already in flight are not counted against the new limits.
+func (r *Router) SetConcurrencyLimits(limits ConcurrencyLimits) {
+	r.gatesMu.Lock()
+	defer r.gatesMu.Unlock()
+	r.limiter = NewConcurrencyLimiter(limits)
+}
+
+// AcquireSlot takes an in-flight slot of the server at address using limits set by SetConcurrencyLimits,
+// see ConcurrencyLimiter.Acquire.
+func (r *Router) AcquireSlot(ctx context.Context, address string) (func(), error) {
+	r.gatesMu.RLock()
+	l := r.limiter
+	r.gatesMu.RUnlock()
+	return l.Acquire(ctx, address)
+}
@@ -0,0 +1,140 @@
+package sdkrouter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConcurrencyLimits(t *testing.T) {
+	limits, err := NewConcurrencyLimits([]config.SDKConcurrencyLimit{
+		{Server: "http://lbrynet1:5279/", MaxConcurrent: 10, MaxQueue: 20, QueueTimeout: 5},
+		{Server: AnyServer, MaxConcurrent: 50},
+	})
+	require.NoError(t, err)
+
+	l, ok := limits.For("http://lbrynet1:5279/")
+	assert.True(t, ok)
+	assert.Equal(t, ConcurrencyLimit{MaxConcurrent: 10, MaxQueue: 20, QueueTimeout: 5 * time.Second}, l)
+	l, ok = limits.For("http://lbrynet2:5279/")
+	assert.True(t, ok)
+	assert.Equal(t, ConcurrencyLimit{MaxConcurrent: 50}, l)
+
+	_, err = NewConcurrencyLimits([]config.SDKConcurrencyLimit{{MaxConcurrent: 10}})
+	assert.EqualError(t, err, "concurrency limit #0 has no server")
+	_, err = NewConcurrencyLimits([]config.SDKConcurrencyLimit{{Server: AnyServer}})
+	assert.EqualError(t, err, "concurrency limit for * should have positive MaxConcurrent and non-negative MaxQueue and QueueTimeout")
+}
+
+func TestConcurrencyLimiterNoLimit(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimits{"http://lbrynet1:5279/": {MaxConcurrent: 1}})
+	for i := 0; i < 10; i++ {
+		_, err := l.Acquire(context.Background(), "http://lbrynet2:5279/")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 0, l.InFlight("http://lbrynet2:5279/"))
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	addr := "http://lbrynet1:5279/"
+	l := NewConcurrencyLimiter(ConcurrencyLimits{addr: {MaxConcurrent: 2, MaxQueue: 1, QueueTimeout: 5 * time.Second}})
+
+	release1, err := l.Acquire(context.Background(), addr)
+	require.NoError(t, err)
+	release2, err := l.Acquire(context.Background(), addr)
+	require.NoError(t, err)
+	assert.Equal(t, 2, l.InFlight(addr))
+
+	queued := make(chan error)
+	go func() {
+		release, err := l.Acquire(context.Background(), addr)
+		if err == nil {
+			defer release()
+		}
+		queued <- err
+	}()
+	// Waiting for the query above to get into the queue
+	for i := 0; i < 100 && atomic.LoadInt64(&l.gate(addr).queued) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = l.Acquire(context.Background(), addr)
+	assert.True(t, errors.Is(err, ErrServerBusy), "queue should be full")
+
+	release1()
+	require.NoError(t, <-queued)
+	release2()
+	assert.Equal(t, 0, l.InFlight(addr))
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	addr := "http://lbrynet1:5279/"
+	l := NewConcurrencyLimiter(ConcurrencyLimits{addr: {MaxConcurrent: 1, MaxQueue: 10, QueueTimeout: 50 * time.Millisecond}})
+
+	release, err := l.Acquire(context.Background(), addr)
+	require.NoError(t, err)
+	defer release()
+
+	start := time.Now()
+	_, err = l.Acquire(context.Background(), addr)
+	assert.True(t, errors.Is(err, ErrServerBusy))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+	assert.EqualValues(t, 0, atomic.LoadInt64(&l.gate(addr).queued))
+}
+
+func TestConcurrencyLimiterMaxInFlight(t *testing.T) {
+	addr := "http://lbrynet1:5279/"
+	l := NewConcurrencyLimiter(ConcurrencyLimits{addr: {MaxConcurrent: 3, MaxQueue: 100, QueueTimeout: 10 * time.Second}})
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxSeen  int
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.Acquire(context.Background(), addr)
+			require.NoError(t, err)
+			mu.Lock()
+			inFlight++
+			if inFlight > maxSeen {
+				maxSeen = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, maxSeen)
+	assert.Equal(t, 0, l.InFlight(addr))
+}
+
+func TestConcurrencyLimiterContext(t *testing.T) {
+	addr := "http://lbrynet1:5279/"
+	l := NewConcurrencyLimiter(ConcurrencyLimits{addr: {MaxConcurrent: 1, MaxQueue: 10, QueueTimeout: 10 * time.Second}})
+
+	release, err := l.Acquire(context.Background(), addr)
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx, addr)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.EqualValues(t, 0, atomic.LoadInt64(&l.gate(addr).queued))
+}
@@ -11,6 +11,10 @@ type ctxKey int
 
 const contextKey ctxKey = iota
 
+func IsOnRequest(r *http.Request) bool {
+	return r.Context().Value(contextKey) != nil
+}
+
 func FromRequest(r *http.Request) *Router {
 	v := r.Context().Value(contextKey)
 	if v == nil {
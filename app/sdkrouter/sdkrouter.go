@@ -13,7 +13,11 @@ import (
 	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
 )
 
-const RPCTimeout = 300 * time.Second
+const (
+	RPCTimeout = 300 * time.Second
+
+	loadUpdateInterval = 2 * time.Minute
+)
 
 var logger = monitor.NewModuleLogger("sdkrouter")
 
@@ -23,10 +27,21 @@ type Router struct {
 	mu      sync.RWMutex
 	servers []*models.LbrynetServer
 
-	loadMu      sync.RWMutex
-	leastLoaded *models.LbrynetServer
+	// loadMu guards both load and strategy
+	loadMu   sync.RWMutex
+	load     Load
+	strategy Strategy
+
+	healthMu sync.RWMutex
+	health   map[string]*ServerHealth
 
-	useDB      bool
+	// gatesMu guards limiter and breakers
+	gatesMu  sync.RWMutex
+	limiter  *ConcurrencyLimiter
+	breakers *Breakers
+
+	useDB bool
+	// lastLoaded is guarded by mu
 	lastLoaded time.Time
 }
 
@@ -41,17 +56,33 @@ func New(servers map[string]string) *Router {
 		return NewWithServers(s...)
 	}
 
-	r := &Router{useDB: true}
+	r := &Router{
+		useDB:    true,
+		strategy: LeastLoadedStrategy{},
+		limiter:  NewConcurrencyLimiter(ConcurrencyLimits{}),
+		breakers: NewBreakers(BreakerSettings{}),
+	}
 	r.reloadServersFromDB()
 	return r
 }
 
 func NewWithServers(servers ...*models.LbrynetServer) *Router {
-	r := &Router{}
+	r := &Router{
+		strategy: LeastLoadedStrategy{},
+		limiter:  NewConcurrencyLimiter(ConcurrencyLimits{}),
+		breakers: NewBreakers(BreakerSettings{}),
+	}
 	r.setServers(servers)
 	return r
 }
 
+// SetStrategy sets the strategy used for picking servers for new users.
+func (r *Router) SetStrategy(s Strategy) {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	r.strategy = s
+}
+
 func (r *Router) GetAll() []*models.LbrynetServer {
 	r.reloadServersFromDB()
 	r.mu.RLock()
@@ -59,23 +90,88 @@ func (r *Router) GetAll() []*models.LbrynetServer {
 	return r.servers
 }
 
+// RandomServer returns a random healthy server that is not draining.
 func (r *Router) RandomServer() *models.LbrynetServer {
 	r.reloadServersFromDB()
 	r.mu.RLock()
-	defer r.mu.RUnlock()
-	return r.servers[rand.Intn(len(r.servers))]
+	servers := r.servers
+	r.mu.RUnlock()
+
+	available := r.availableServers(servers)
+	return available[rand.Intn(len(available))]
+}
+
+// AlternativeServer returns a random healthy server that is not draining and has an address
+// other than the excluded ones, nil if there is no such server.
+func (r *Router) AlternativeServer(exclude ...string) *models.LbrynetServer {
+	r.reloadServersFromDB()
+	r.mu.RLock()
+	servers := r.servers
+	r.mu.RUnlock()
+
+	var others []*models.LbrynetServer
+	for _, s := range r.availableServers(servers) {
+		excluded := false
+		for _, a := range exclude {
+			if s.Address == a {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			others = append(others, s)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return others[rand.Intn(len(others))]
+}
+
+// availableServers returns servers which can take new users and anonymous traffic,
+// i.e. the ones that are healthy and are not being drained.
+func (r *Router) availableServers(servers []*models.LbrynetServer) []*models.LbrynetServer {
+	active := make([]*models.LbrynetServer, 0, len(servers))
+	for _, s := range servers {
+		if !s.Draining {
+			active = append(active, s)
+		}
+	}
+	if len(active) == 0 {
+		logger.Log().Error("all lbrynet servers are draining, using all of them")
+		active = servers
+	}
+	return r.healthyServers(active)
+}
+
+// Invalidate makes the router reload its server list from the database right away
+// instead of waiting for the next scheduled reload.
+func (r *Router) Invalidate() {
+	if !r.useDB {
+		return
+	}
+	r.mu.Lock()
+	r.lastLoaded = time.Time{}
+	r.mu.Unlock()
+	r.reloadServersFromDB()
 }
 
 func (r *Router) reloadServersFromDB() {
 	op := metrics.StartOperation("db", "get_server")
 	defer op.End()
 
-	if !r.useDB || time.Since(r.lastLoaded) < 30*time.Second {
+	if !r.useDB {
+		return
+	}
+	r.mu.Lock()
+	if time.Since(r.lastLoaded) < 30*time.Second {
 		// don't hammer the DB
+		r.mu.Unlock()
 		return
 	}
-
 	r.lastLoaded = time.Now()
+	r.mu.Unlock()
+
 	servers, err := models.LbrynetServers().AllG()
 	if err != nil {
 		logger.Log().Error("Error retrieving lbrynet servers: ", err)
@@ -98,7 +194,7 @@ func (r *Router) setServers(servers []*models.LbrynetServer) {
 
 // WatchLoad keeps updating the metrics on the number of wallets loaded for each instance
 func (r *Router) WatchLoad() {
-	ticker := time.NewTicker(2 * time.Minute)
+	ticker := time.NewTicker(loadUpdateInterval)
 
 	logger.Log().Infof("SDK router watching load on %d instances", len(r.servers))
 	r.reloadServersFromDB()
@@ -114,8 +210,7 @@ func (r *Router) WatchLoad() {
 }
 
 func (r *Router) updateLoadAndMetrics() {
-	var best *models.LbrynetServer
-	var min uint64
+	load := Load{}
 
 	servers := r.GetAll()
 	logger.Log().Infof("updating load for %d servers", len(servers))
@@ -125,39 +220,28 @@ func (r *Router) updateLoadAndMetrics() {
 		if err != nil {
 			logger.Log().Errorf("lbrynet instance %s is not responding: %v", server.Address, err)
 			metric.Set(-1.0)
-			// TODO: maybe mark this instance as unresponsive so new users are assigned to other instances
 			continue
 		}
-
-		numWallets := walletList.TotalPages
-		logger.Log().Debugf("load update: considering %s with load %d", server.Address, numWallets)
-		if best == nil || numWallets < min {
-			logger.Log().Debugf("load update: %s has least with %d", server.Address, numWallets)
-			best = server
-			min = numWallets
-		}
 		metric.Set(float64(walletList.TotalPages))
+		logger.Log().Debugf("load update: %s has load %d", server.Address, walletList.TotalPages)
+		load[server.Name] = walletList.TotalPages
 	}
 
-	if best != nil {
-		r.loadMu.Lock()
-		defer r.loadMu.Unlock()
-		r.leastLoaded = best
-		logger.Log().Infof("After updating load, least loaded server is %s", best.Address)
-	}
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	r.load = load
+	logger.Log().Infof("load updated for %d out of %d servers", len(load), len(servers))
 }
 
-// LeastLoaded returns the least-loaded wallet
-func (r *Router) LeastLoaded() *models.LbrynetServer {
+// PickServer returns a healthy, non-draining server which a new user should be assigned to.
+// The choice is made by the router strategy, which is LeastLoadedStrategy by default.
+// Load may be unknown if it hasn't been checked yet, strategies must handle that.
+func (r *Router) PickServer() *models.LbrynetServer {
 	r.loadMu.RLock()
-	defer r.loadMu.RUnlock()
-
-	if r.leastLoaded == nil {
-		logger.Log().Warnf("LeastLoaded() called before load metrics were updated. Returning random server.")
-		return r.RandomServer()
-	}
+	load, strategy := r.load, r.strategy
+	r.loadMu.RUnlock()
 
-	return r.leastLoaded
+	return strategy.Pick(r.availableServers(r.GetAll()), load)
 }
 
 // WalletID formats user ID to use as an LbrynetServer wallet ID.
@@ -1,12 +1,16 @@
 package sdkrouter
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/lbryio/lbrytv/apps/lbrytv/config"
 	"github.com/lbryio/lbrytv/internal/storage"
 	"github.com/lbryio/lbrytv/internal/test"
+	"github.com/lbryio/lbrytv/models"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -73,13 +77,163 @@ func TestLeastLoaded(t *testing.T) {
 	rpcServer2.NextResponse <- `{"result":{"total_pages":2}}`
 	rpcServer3.NextResponse <- `{"result":{"total_pages":3}}`
 	r.updateLoadAndMetrics()
-	assert.Equal(t, "srv1", r.LeastLoaded().Name)
+	assert.Equal(t, "srv1", r.PickServer().Name)
 
 	// now do the load in decreasing order
 	rpcServer1.NextResponse <- `{"result":{"total_pages":3}}`
 	rpcServer2.NextResponse <- `{"result":{"total_pages":2}}`
 	rpcServer3.NextResponse <- `{"result":{"total_pages":1}}`
 	r.updateLoadAndMetrics()
-	assert.Equal(t, "srv3", r.LeastLoaded().Name)
+	assert.Equal(t, "srv3", r.PickServer().Name)
 
 }
+
+func TestHealth(t *testing.T) {
+	rpcServer1 := test.MockHTTPServer(nil)
+	defer rpcServer1.Close()
+	rpcServer2 := test.MockHTTPServer(nil)
+	defer rpcServer2.Close()
+
+	r := New(map[string]string{
+		"srv1": rpcServer1.URL,
+		"srv2": rpcServer2.URL,
+	})
+	srv2 := r.servers[0]
+	if srv2.Name != "srv2" {
+		srv2 = r.servers[1]
+	}
+
+	r.load = Load{"srv1": 5, "srv2": 1}
+
+	probe := func(response2 string) {
+		rpcServer1.NextResponse <- `{"result":{}}`
+		rpcServer2.NextResponse <- response2
+		r.probeHealth(time.Second)
+	}
+
+	assert.True(t, r.IsHealthy(srv2), "servers should be considered healthy before they are probed")
+
+	for i := 0; i < unhealthyAfterFailures; i++ {
+		assert.True(t, r.IsHealthy(srv2))
+		probe(`{"error":{"code":-32500,"message":"internal error"}}`)
+	}
+	health := r.Health(srv2)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, unhealthyAfterFailures, health.ConsecutiveFailures)
+	assert.Contains(t, health.LastError, "internal error")
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "srv1", r.RandomServer().Name)
+	}
+	assert.Equal(t, "srv1", r.PickServer().Name)
+
+	for i := 0; i < healthyAfterSuccesses; i++ {
+		assert.False(t, r.IsHealthy(srv2))
+		probe(`{"result":{}}`)
+	}
+	health = r.Health(srv2)
+	assert.True(t, health.Healthy)
+	assert.Equal(t, 0, health.ConsecutiveFailures)
+	assert.False(t, health.LastSuccess.IsZero())
+	assert.Equal(t, "srv2", r.PickServer().Name)
+}
+
+func TestHealthAllUnhealthy(t *testing.T) {
+	rpcServer := test.MockHTTPServer(nil)
+	rpcServer.Close()
+
+	r := New(map[string]string{"srv": rpcServer.URL})
+	for i := 0; i < unhealthyAfterFailures; i++ {
+		r.probeHealth(time.Second)
+	}
+	assert.False(t, r.IsHealthy(r.servers[0]))
+	assert.Equal(t, "srv", r.RandomServer().Name, "unhealthy servers should be used when there's nothing else")
+}
+
+func TestHealthProbeHungServer(t *testing.T) {
+	hung := make(chan struct{})
+	hungServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer hungServer.Close()
+	defer close(hung)
+	rpcServer := test.MockHTTPServer(nil)
+	defer rpcServer.Close()
+	go func() {
+		rpcServer.NextResponse <- `{"result":{}}`
+	}()
+
+	r := NewWithServers(
+		&models.LbrynetServer{Name: "hung", Address: hungServer.URL},
+		&models.LbrynetServer{Name: "srv", Address: rpcServer.URL},
+	)
+	start := time.Now()
+	r.probeHealth(200 * time.Millisecond)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "probes should time out")
+
+	assert.Equal(t, 1, r.Health(r.servers[0]).ConsecutiveFailures)
+	assert.Equal(t, 1, r.Health(r.servers[1]).ConsecutiveSuccesses)
+	assert.Less(t, r.Health(r.servers[1]).Latency, 0.2, "servers should be probed concurrently")
+}
+
+func TestProbeTimeout(t *testing.T) {
+	assert.Equal(t, 5*time.Second, probeTimeout(10*time.Second))
+	assert.Equal(t, maxProbeTimeout, probeTimeout(time.Minute))
+}
+
+func TestHealthPrune(t *testing.T) {
+	rpcServer := test.MockHTTPServer(nil)
+	rpcServer.Close()
+
+	r := NewWithServers(
+		&models.LbrynetServer{Name: "srv", Address: rpcServer.URL},
+		&models.LbrynetServer{Name: "removed", Address: "http://removed"},
+	)
+	for i := 0; i < unhealthyAfterFailures; i++ {
+		r.probeHealth(time.Second)
+	}
+	removed := r.servers[1]
+	assert.False(t, r.IsHealthy(removed))
+
+	r.setServers(r.servers[:1])
+	r.probeHealth(time.Second)
+	assert.Len(t, r.health, 1)
+	assert.True(t, r.Health(removed).Healthy, "health of removed servers should be forgotten")
+	assert.False(t, r.Health(r.servers[0]).Healthy)
+}
+
+func TestDraining(t *testing.T) {
+	r := NewWithServers(
+		&models.LbrynetServer{Name: "active", Address: "http://active"},
+		&models.LbrynetServer{Name: "draining", Address: "http://draining", Draining: true},
+	)
+	r.load = Load{"active": 100, "draining": 0}
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "active", r.PickServer().Name)
+		assert.Equal(t, "active", r.RandomServer().Name)
+	}
+	assert.Len(t, r.GetAll(), 2, "draining servers should still be available for their existing users")
+}
+
+func TestDrainingAll(t *testing.T) {
+	r := NewWithServers(&models.LbrynetServer{Name: "draining", Address: "http://draining", Draining: true})
+	assert.Equal(t, "draining", r.RandomServer().Name, "draining servers should be used when there's nothing else")
+}
+
+func TestAlternativeServer(t *testing.T) {
+	r := NewWithServers(
+		&models.LbrynetServer{Name: "one", Address: "http://one"},
+		&models.LbrynetServer{Name: "two", Address: "http://two"},
+		&models.LbrynetServer{Name: "draining", Address: "http://draining", Draining: true},
+	)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "two", r.AlternativeServer("http://one").Name)
+		assert.NotEqual(t, "draining", r.AlternativeServer("http://draining").Name)
+	}
+
+	assert.Nil(t, r.AlternativeServer("http://one", "http://two"))
+
+	r = NewWithServers(&models.LbrynetServer{Name: "one", Address: "http://one"})
+	assert.Nil(t, r.AlternativeServer("http://one"))
+}
@@ -0,0 +1,128 @@
+package sdkrouter
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/lbryio/lbrytv/models"
+)
+
+const (
+	StrategyLeastLoaded    = "least_loaded"
+	StrategyWeightedRandom = "weighted_random"
+	StrategyPowerOfTwo     = "power_of_two"
+	StrategyRoundRobin     = "round_robin"
+)
+
+// Load contains the number of wallets loaded on each server, keyed by server name.
+// Servers which didn't respond to the last load check are not present.
+type Load map[string]uint64
+
+// Strategy picks a server for a new user out of a non-empty list of servers available for assignment.
+// Implementations must be safe for concurrent use.
+type Strategy interface {
+	Pick(servers []*models.LbrynetServer, load Load) *models.LbrynetServer
+}
+
+// NewStrategy returns a load-balancing strategy by its name.
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case StrategyLeastLoaded, "":
+		return LeastLoadedStrategy{}, nil
+	case StrategyWeightedRandom:
+		return WeightedRandomStrategy{}, nil
+	case StrategyPowerOfTwo:
+		return PowerOfTwoStrategy{}, nil
+	case StrategyRoundRobin:
+		return &RoundRobinStrategy{}, nil
+	}
+	return nil, fmt.Errorf("unknown sdk router strategy: %v", name)
+}
+
+// LeastLoadedStrategy picks the server with the least wallets loaded.
+// A random server is picked if load is not known yet.
+type LeastLoadedStrategy struct{}
+
+func (LeastLoadedStrategy) Pick(servers []*models.LbrynetServer, load Load) *models.LbrynetServer {
+	var (
+		best *models.LbrynetServer
+		min  uint64
+	)
+	for _, s := range servers {
+		l, ok := load[s.Name]
+		if !ok {
+			continue
+		}
+		if best == nil || l < min {
+			best = s
+			min = l
+		}
+	}
+	if best == nil {
+		logger.Log().Warnf("load is unknown for all servers. Returning random server.")
+		return servers[rand.Intn(len(servers))]
+	}
+	return best
+}
+
+// WeightedRandomStrategy picks a random server with the probability proportional to its weight.
+// Servers with zero weight are never picked unless all servers have zero weight,
+// in which case all of them are equally likely to be picked.
+type WeightedRandomStrategy struct{}
+
+func (WeightedRandomStrategy) Pick(servers []*models.LbrynetServer, _ Load) *models.LbrynetServer {
+	var total int
+	for _, s := range servers {
+		if s.Weight > 0 {
+			total += s.Weight
+		}
+	}
+	if total == 0 {
+		return servers[rand.Intn(len(servers))]
+	}
+
+	n := rand.Intn(total)
+	for _, s := range servers {
+		if s.Weight <= 0 {
+			continue
+		}
+		if n < s.Weight {
+			return s
+		}
+		n -= s.Weight
+	}
+	return servers[len(servers)-1] // unreachable
+}
+
+// PowerOfTwoStrategy picks two random servers and returns the less loaded one of them.
+// Servers with unknown load are considered to be the most loaded.
+type PowerOfTwoStrategy struct{}
+
+func (PowerOfTwoStrategy) Pick(servers []*models.LbrynetServer, load Load) *models.LbrynetServer {
+	if len(servers) == 1 {
+		return servers[0]
+	}
+	i := rand.Intn(len(servers))
+	j := rand.Intn(len(servers) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := servers[i], servers[j]
+	la, aKnown := load[a.Name]
+	lb, bKnown := load[b.Name]
+	if !aKnown || (bKnown && lb < la) {
+		return b
+	}
+	return a
+}
+
+// RoundRobinStrategy cycles through servers in order.
+type RoundRobinStrategy struct {
+	next uint64
+}
+
+func (s *RoundRobinStrategy) Pick(servers []*models.LbrynetServer, _ Load) *models.LbrynetServer {
+	n := atomic.AddUint64(&s.next, 1) - 1
+	return servers[n%uint64(len(servers))]
+}
@@ -0,0 +1,99 @@
+package sdkrouter
+
+import (
+	"testing"
+
+	"github.com/lbryio/lbrytv/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const picks = 10000
+
+func testServers(weights ...int) []*models.LbrynetServer {
+	servers := make([]*models.LbrynetServer, len(weights))
+	for i, w := range weights {
+		servers[i] = &models.LbrynetServer{Name: string(rune('a' + i)), Weight: w}
+	}
+	return servers
+}
+
+// spread picks servers repeatedly and returns the number of times each server was picked
+func spread(s Strategy, servers []*models.LbrynetServer, load Load) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < picks; i++ {
+		counts[s.Pick(servers, load).Name]++
+	}
+	return counts
+}
+
+func TestNewStrategy(t *testing.T) {
+	for _, name := range []string{StrategyLeastLoaded, StrategyWeightedRandom, StrategyPowerOfTwo, StrategyRoundRobin} {
+		s, err := NewStrategy(name)
+		require.NoError(t, err)
+		assert.NotNil(t, s)
+	}
+	_, err := NewStrategy("whatever")
+	assert.EqualError(t, err, "unknown sdk router strategy: whatever")
+}
+
+func TestLeastLoadedStrategy(t *testing.T) {
+	servers := testServers(0, 0, 0)
+	counts := spread(LeastLoadedStrategy{}, servers, Load{"a": 30, "b": 10, "c": 20})
+	assert.Equal(t, map[string]int{"b": picks}, counts)
+
+	counts = spread(LeastLoadedStrategy{}, servers, Load{"a": 30, "c": 20})
+	assert.Equal(t, map[string]int{"c": picks}, counts, "servers with unknown load should be skipped")
+
+	counts = spread(LeastLoadedStrategy{}, servers, Load{})
+	assert.Len(t, counts, 3, "random server should be picked when load is unknown")
+}
+
+func TestWeightedRandomStrategy(t *testing.T) {
+	servers := testServers(1, 2, 7, 0)
+	counts := spread(WeightedRandomStrategy{}, servers, nil)
+	assert.InDelta(t, 0.1*picks, counts["a"], 0.03*picks)
+	assert.InDelta(t, 0.2*picks, counts["b"], 0.03*picks)
+	assert.InDelta(t, 0.7*picks, counts["c"], 0.03*picks)
+	assert.Zero(t, counts["d"], "zero weight servers should not be picked")
+
+	counts = spread(WeightedRandomStrategy{}, testServers(0, 0), nil)
+	assert.InDelta(t, 0.5*picks, counts["a"], 0.03*picks)
+	assert.InDelta(t, 0.5*picks, counts["b"], 0.03*picks)
+}
+
+func TestPowerOfTwoStrategy(t *testing.T) {
+	servers := testServers(0, 0, 0)
+	counts := spread(PowerOfTwoStrategy{}, servers, Load{"a": 1, "b": 2, "c": 3})
+	// "a" wins in both pairs it's a part of, "b" only wins against "c" and "c" never wins
+	assert.InDelta(t, 2.0/3*picks, counts["a"], 0.03*picks)
+	assert.InDelta(t, 1.0/3*picks, counts["b"], 0.03*picks)
+	assert.Zero(t, counts["c"])
+
+	counts = spread(PowerOfTwoStrategy{}, servers, Load{"a": 5, "b": 5})
+	assert.Zero(t, counts["c"], "servers with unknown load should lose")
+
+	counts = spread(PowerOfTwoStrategy{}, testServers(0), nil)
+	assert.Equal(t, map[string]int{"a": picks}, counts)
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+	servers := testServers(0, 0, 0, 0)
+	s := &RoundRobinStrategy{}
+	for i := 0; i < 8; i++ {
+		assert.Equal(t, servers[i%4], s.Pick(servers, nil))
+	}
+	counts := spread(&RoundRobinStrategy{}, servers, nil)
+	assert.Equal(t, map[string]int{"a": picks / 4, "b": picks / 4, "c": picks / 4, "d": picks / 4}, counts)
+}
+
+func TestRouterStrategy(t *testing.T) {
+	r := NewWithServers(testServers(0, 0, 0)...)
+	r.SetStrategy(&RoundRobinStrategy{})
+	counts := map[string]int{}
+	for i := 0; i < 30; i++ {
+		counts[r.PickServer().Name]++
+	}
+	assert.Equal(t, map[string]int{"a": 10, "b": 10, "c": 10}, counts, "strategy should be used before load is known")
+}
@@ -0,0 +1,270 @@
+// Package spending enforces limits on how much LBC users can spend through the proxy.
+// Spendings are recorded in the spending_log table, default limits come from the config
+// and can be overridden for individual users in the spending_limits table.
+package spending
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/query"
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/metrics"
+	"github.com/lbryio/lbrytv/internal/monitor"
+
+	"github.com/sirupsen/logrus"
+	"github.com/volatiletech/null"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+var logger = monitor.NewModuleLogger("spending")
+
+// ErrLimitReached is wrapped by errors of spendings which would take users over one of their limits.
+var ErrLimitReached = errors.Base("spending limit reached")
+
+const (
+	// DeweysPerLBC is the number of the smallest LBC units in one LBC.
+	DeweysPerLBC = 100000000
+
+	// day is the period daily limits are counted over, ending at the time of the spending.
+	day = 24 * time.Hour
+
+	// lockClass namespaces advisory locks taken on user IDs while checking their spendings.
+	lockClass = 1701
+
+	// pruneInterval is how often spendings which don't count towards daily limits anymore are deleted.
+	pruneInterval = time.Hour
+)
+
+// Limits are spending limits of a user, amounts are in deweys. Zero values mean no limit.
+type Limits struct {
+	// MaxPurchase is the largest amount a single purchase can be for
+	MaxPurchase uint64
+	// DailySpend is the total amount of purchases and sends over a day
+	DailySpend uint64
+	// DailySends is the number of sends over a day
+	DailySends int
+}
+
+// NewLimits converts LBC limits from the config into Limits.
+func NewLimits(cfg config.SpendingLimits) (Limits, error) {
+	if cfg.MaxPurchase < 0 || cfg.DailySpend < 0 || cfg.DailySends < 0 {
+		return Limits{}, errors.Err("spending limits should not be negative")
+	}
+	return Limits{
+		MaxPurchase: uint64(math.Round(cfg.MaxPurchase * DeweysPerLBC)),
+		DailySpend:  uint64(math.Round(cfg.DailySpend * DeweysPerLBC)),
+		DailySends:  cfg.DailySends,
+	}, nil
+}
+
+// Override is a spending limit override of a user, null fields are not overridden.
+type Override struct {
+	MaxPurchase null.Int64
+	DailySpend  null.Int64
+	DailySends  null.Int
+}
+
+// validate returns an error if any of the set fields is negative.
+// Negative amounts would turn into huge limits, allowing unlimited spending.
+func (o Override) validate() error {
+	if o.MaxPurchase.Int64 < 0 || o.DailySpend.Int64 < 0 || o.DailySends.Int < 0 {
+		return errors.Err("spending limit overrides should not be negative")
+	}
+	return nil
+}
+
+// apply returns limits with the set fields of the override replacing the defaults.
+func (o Override) apply(l Limits) Limits {
+	if o.MaxPurchase.Valid {
+		l.MaxPurchase = uint64(o.MaxPurchase.Int64)
+	}
+	if o.DailySpend.Valid {
+		l.DailySpend = uint64(o.DailySpend.Int64)
+	}
+	if o.DailySends.Valid {
+		l.DailySends = o.DailySends.Int
+	}
+	return l
+}
+
+// disabled returns true if none of the limits apply.
+func (l Limits) disabled() bool {
+	return l == Limits{}
+}
+
+// isSend returns true for methods sending LBC to addresses.
+func isSend(method string) bool {
+	return method == query.MethodWalletSend || method == query.MethodAccountSend
+}
+
+// spent is what a user has spent over the last day.
+type spent struct {
+	amount uint64
+	sends  int
+}
+
+// check returns an error wrapping ErrLimitReached if spending amount with the method
+// would take the user over their limits.
+func (l Limits) check(s spent, method string, amount uint64) error {
+	if method == query.MethodPurchaseCreate && l.MaxPurchase > 0 && amount > l.MaxPurchase {
+		return limitError("purchase of %v LBC is over the limit of %v LBC", lbc(amount), lbc(l.MaxPurchase))
+	}
+	if l.DailySpend > 0 && s.amount+amount > l.DailySpend {
+		return limitError("daily limit of %v LBC would be exceeded, %v LBC spent", lbc(l.DailySpend), lbc(s.amount))
+	}
+	if isSend(method) && l.DailySends > 0 && s.sends >= l.DailySends {
+		return limitError("daily limit of %v sends reached", l.DailySends)
+	}
+	return nil
+}
+
+func limitError(format string, args ...interface{}) error {
+	return rpcerrors.NewSpendingLimitError(errors.Prefix(fmt.Sprintf(format, args...), ErrLimitReached))
+}
+
+func lbc(deweys uint64) string {
+	return fmt.Sprintf("%.8g", float64(deweys)/DeweysPerLBC)
+}
+
+// Guard checks spendings of users against their limits and records them.
+type Guard struct {
+	db       *sql.DB
+	defaults Limits
+	now      func() time.Time
+}
+
+// NewGuard creates a Guard storing spendings in db, defaults apply to users without overrides.
+func NewGuard(db *sql.DB, defaults Limits) *Guard {
+	return &Guard{db: db, defaults: defaults, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// LimitsFor returns limits of the user, the defaults with the user's override applied.
+func (g *Guard) LimitsFor(exec boil.Executor, userID int) (Limits, error) {
+	var o Override
+	err := exec.QueryRow(
+		`SELECT "max_purchase", "daily_spend", "daily_sends" FROM "spending_limits" WHERE "user_id" = $1`,
+		userID,
+	).Scan(&o.MaxPurchase, &o.DailySpend, &o.DailySends)
+	if errors.Is(err, sql.ErrNoRows) {
+		return g.defaults, nil
+	} else if err != nil {
+		return Limits{}, errors.Err(err)
+	}
+	if err := o.validate(); err != nil {
+		return Limits{}, err
+	}
+	return o.apply(g.defaults), nil
+}
+
+// Reserve records a spending of amount deweys by the user with the method
+// (purchase_create, wallet_send or account_send), returning an RPC error wrapping ErrLimitReached instead
+// if it would take the user over their limits.
+// Spendings of the same user are reserved one at a time so concurrent queries can't exceed the limits.
+// Nothing is recorded for users without limits.
+// release removes the spending and should be called if the SDK has not gone through with it.
+func (g *Guard) Reserve(ctx context.Context, userID int, method string, amount uint64) (func(), error) {
+	log := logger.WithFields(logrus.Fields{"user_id": userID, "method": method, "amount": amount})
+
+	limits, err := g.LimitsFor(g.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if limits.disabled() {
+		return func() {}, nil
+	}
+
+	tx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, lockClass, userID); err != nil {
+		return nil, errors.Err(err)
+	}
+
+	now := g.now()
+	var s spent
+	err = tx.QueryRow(
+		`SELECT COALESCE(SUM("amount"), 0), COUNT(*) FILTER (WHERE "method" IN ($2, $3))
+		FROM "spending_log" WHERE "user_id" = $1 AND "timestamp" > $4`,
+		userID, query.MethodWalletSend, query.MethodAccountSend, now.Add(-day),
+	).Scan(&s.amount, &s.sends)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	if err := limits.check(s, method, amount); err != nil {
+		metrics.LbrytvSpendingLimitCount.WithLabelValues(method).Inc()
+		log.Info(err)
+		return nil, err
+	}
+
+	var id int
+	err = tx.QueryRow(
+		`INSERT INTO "spending_log" ("user_id", "method", "amount", "timestamp") VALUES ($1, $2, $3, $4) RETURNING "id"`,
+		userID, method, amount, now,
+	).Scan(&id)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Err(err)
+	}
+	log.Debug("spending reserved")
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if _, err := g.db.Exec(`DELETE FROM "spending_log" WHERE "id" = $1`, id); err != nil {
+				log.Errorf("error releasing spending: %v", err)
+				return
+			}
+			log.Debug("spending released")
+		})
+	}, nil
+}
+
+// Prune deletes spendings which no longer count towards daily limits, returning the number of deleted rows.
+func (g *Guard) Prune() (int64, error) {
+	res, err := g.db.Exec(`DELETE FROM "spending_log" WHERE "timestamp" <= $1`, g.now().Add(-day))
+	if err != nil {
+		return 0, errors.Err(err)
+	}
+	n, err := res.RowsAffected()
+	return n, errors.Err(err)
+}
+
+// WatchPrune keeps deleting spendings older than a day so spending_log doesn't grow indefinitely.
+func (g *Guard) WatchPrune() {
+	ticker := time.NewTicker(pruneInterval)
+	for {
+		if n, err := g.Prune(); err != nil {
+			logger.Log().Errorf("error pruning spending log: %v", err)
+		} else {
+			logger.Log().Debugf("pruned %d spendings from spending log", n)
+		}
+		<-ticker.C
+	}
+}
+
+// SetOverride sets spending limit overrides of the user, replacing any existing ones.
+func SetOverride(exec boil.Executor, userID int, o Override) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	_, err := exec.Exec(
+		`INSERT INTO "spending_limits" ("user_id", "max_purchase", "daily_spend", "daily_sends") VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("user_id") DO UPDATE SET
+			"max_purchase" = EXCLUDED."max_purchase", "daily_spend" = EXCLUDED."daily_spend",
+			"daily_sends" = EXCLUDED."daily_sends", "updated_at" = now()`,
+		userID, o.MaxPurchase, o.DailySpend, o.DailySends,
+	)
+	return errors.Err(err)
+}
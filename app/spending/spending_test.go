@@ -0,0 +1,222 @@
+package spending
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/query"
+	"github.com/lbryio/lbrytv/app/rpcerrors"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/storage"
+	"github.com/lbryio/lbrytv/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volatiletech/null"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+func TestMain(m *testing.M) {
+	rand.Seed(time.Now().UnixNano())
+
+	dbConfig := config.GetDatabase()
+	params := storage.ConnParams{
+		Connection: dbConfig.Connection,
+		DBName:     dbConfig.DBName,
+		Options:    dbConfig.Options + "&TimeZone=UTC",
+	}
+	dbConn, connCleanup := storage.CreateTestConn(params)
+	dbConn.SetDefaultConnection()
+
+	code := m.Run()
+
+	connCleanup()
+	os.Exit(code)
+}
+
+func assertLimitReached(t *testing.T, err error) {
+	t.Helper()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrLimitReached))
+	assert.Equal(t, -32090, rpcerrors.ToResponse(err, 0).Error.Code)
+}
+
+func TestNewLimits(t *testing.T) {
+	l, err := NewLimits(config.SpendingLimits{MaxPurchase: 2.5, DailySpend: 100, DailySends: 3})
+	require.NoError(t, err)
+	assert.Equal(t, Limits{MaxPurchase: 250000000, DailySpend: 10000000000, DailySends: 3}, l)
+
+	_, err = NewLimits(config.SpendingLimits{DailySpend: -1})
+	assert.EqualError(t, err, "spending limits should not be negative")
+}
+
+func TestLimitsCheck(t *testing.T) {
+	l := Limits{MaxPurchase: 100 * DeweysPerLBC, DailySpend: 500 * DeweysPerLBC, DailySends: 2}
+
+	assert.NoError(t, l.check(spent{}, query.MethodPurchaseCreate, 100*DeweysPerLBC))
+	err := l.check(spent{}, query.MethodPurchaseCreate, 150*DeweysPerLBC)
+	assertLimitReached(t, err)
+	assert.EqualError(t, err, "purchase of 150 LBC is over the limit of 100 LBC: spending limit reached")
+	assert.NoError(t, l.check(spent{}, query.MethodWalletSend, 150*DeweysPerLBC), "sends are not limited by MaxPurchase")
+
+	err = l.check(spent{amount: 450 * DeweysPerLBC}, query.MethodWalletSend, 60*DeweysPerLBC)
+	assertLimitReached(t, err)
+	assert.EqualError(t, err, "daily limit of 500 LBC would be exceeded, 450 LBC spent: spending limit reached")
+	assert.NoError(t, l.check(spent{amount: 450 * DeweysPerLBC}, query.MethodWalletSend, 50*DeweysPerLBC))
+
+	err = l.check(spent{sends: 2}, query.MethodWalletSend, 1)
+	assertLimitReached(t, err)
+	assert.EqualError(t, err, "daily limit of 2 sends reached: spending limit reached")
+	assertLimitReached(t, l.check(spent{sends: 2}, query.MethodAccountSend, 1))
+	assert.NoError(t, l.check(spent{sends: 2}, query.MethodPurchaseCreate, 1))
+
+	assert.NoError(t, Limits{}.check(spent{amount: 1 << 40, sends: 1000}, query.MethodPurchaseCreate, 1<<40), "zero limits should not apply")
+	assert.True(t, Limits{}.disabled())
+	assert.False(t, Limits{DailySends: 1}.disabled())
+}
+
+func TestOverrideApply(t *testing.T) {
+	defaults := Limits{MaxPurchase: 100, DailySpend: 500, DailySends: 2}
+	assert.Equal(t, defaults, Override{}.apply(defaults))
+	assert.Equal(t,
+		Limits{MaxPurchase: 100, DailySpend: 0, DailySends: 10},
+		Override{DailySpend: null.Int64From(0), DailySends: null.IntFrom(10)}.apply(defaults),
+	)
+}
+
+func TestOverrideValidate(t *testing.T) {
+	assert.NoError(t, Override{}.validate())
+	assert.NoError(t, Override{MaxPurchase: null.Int64From(0), DailySpend: null.Int64From(10), DailySends: null.IntFrom(1)}.validate())
+	for _, o := range []Override{
+		{MaxPurchase: null.Int64From(-1)},
+		{DailySpend: null.Int64From(-100)},
+		{DailySends: null.IntFrom(-1)},
+	} {
+		assert.EqualError(t, o.validate(), "spending limit overrides should not be negative")
+	}
+}
+
+func createUser(t *testing.T) int {
+	t.Helper()
+	u := &models.User{ID: rand.Intn(99999)}
+	require.NoError(t, u.InsertG(boil.Infer()))
+	return u.ID
+}
+
+func TestGuardReserve(t *testing.T) {
+	storage.Conn.Truncate([]string{models.TableNames.Users})
+	userID := createUser(t)
+	g := NewGuard(storage.Conn.DB.DB, Limits{DailySpend: 100 * DeweysPerLBC, DailySends: 2})
+	ctx := context.Background()
+
+	release, err := g.Reserve(ctx, userID, query.MethodWalletSend, 60*DeweysPerLBC)
+	require.NoError(t, err)
+	_, err = g.Reserve(ctx, userID, query.MethodWalletSend, 60*DeweysPerLBC)
+	assertLimitReached(t, err)
+
+	release()
+	release()
+	_, err = g.Reserve(ctx, userID, query.MethodWalletSend, 60*DeweysPerLBC)
+	require.NoError(t, err, "released spendings should not count")
+	_, err = g.Reserve(ctx, userID, query.MethodWalletSend, 10*DeweysPerLBC)
+	require.NoError(t, err)
+	_, err = g.Reserve(ctx, userID, query.MethodWalletSend, 1)
+	assertLimitReached(t, err)
+
+	// Spendings older than a day don't count
+	g.now = func() time.Time { return time.Now().UTC().Add(25 * time.Hour) }
+	_, err = g.Reserve(ctx, userID, query.MethodWalletSend, 90*DeweysPerLBC)
+	require.NoError(t, err)
+}
+
+func countSpendings(t *testing.T, userID int) int {
+	t.Helper()
+	var n int
+	require.NoError(t, boil.GetDB().QueryRow(`SELECT COUNT(*) FROM "spending_log" WHERE "user_id" = $1`, userID).Scan(&n))
+	return n
+}
+
+func TestGuardReserveSends(t *testing.T) {
+	storage.Conn.Truncate([]string{models.TableNames.Users})
+	userID := createUser(t)
+	g := NewGuard(storage.Conn.DB.DB, Limits{DailySends: 2})
+	ctx := context.Background()
+
+	_, err := g.Reserve(ctx, userID, query.MethodWalletSend, DeweysPerLBC)
+	require.NoError(t, err)
+	_, err = g.Reserve(ctx, userID, query.MethodAccountSend, DeweysPerLBC)
+	require.NoError(t, err)
+	_, err = g.Reserve(ctx, userID, query.MethodAccountSend, DeweysPerLBC)
+	assertLimitReached(t, err)
+	_, err = g.Reserve(ctx, userID, query.MethodPurchaseCreate, DeweysPerLBC)
+	require.NoError(t, err, "purchases should not count as sends")
+}
+
+func TestGuardReserveDisabled(t *testing.T) {
+	storage.Conn.Truncate([]string{models.TableNames.Users})
+	userID := createUser(t)
+	g := NewGuard(storage.Conn.DB.DB, Limits{})
+
+	release, err := g.Reserve(context.Background(), userID, query.MethodWalletSend, 1000*DeweysPerLBC)
+	require.NoError(t, err)
+	release()
+	assert.Equal(t, 0, countSpendings(t, userID), "spendings of users without limits should not be recorded")
+
+	require.NoError(t, SetOverride(boil.GetDB(), userID, Override{DailySends: null.IntFrom(1)}))
+	_, err = g.Reserve(context.Background(), userID, query.MethodWalletSend, 1000*DeweysPerLBC)
+	require.NoError(t, err)
+	assert.Equal(t, 1, countSpendings(t, userID), "overrides should enable limits")
+	_, err = g.Reserve(context.Background(), userID, query.MethodWalletSend, 1000*DeweysPerLBC)
+	assertLimitReached(t, err)
+}
+
+func TestGuardPrune(t *testing.T) {
+	storage.Conn.Truncate([]string{models.TableNames.Users})
+	userID := createUser(t)
+	g := NewGuard(storage.Conn.DB.DB, Limits{DailySends: 10})
+	ctx := context.Background()
+
+	_, err := g.Reserve(ctx, userID, query.MethodWalletSend, DeweysPerLBC)
+	require.NoError(t, err)
+	g.now = func() time.Time { return time.Now().UTC().Add(12 * time.Hour) }
+	_, err = g.Reserve(ctx, userID, query.MethodWalletSend, DeweysPerLBC)
+	require.NoError(t, err)
+
+	g.now = func() time.Time { return time.Now().UTC().Add(25 * time.Hour) }
+	n, err := g.Prune()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	assert.Equal(t, 1, countSpendings(t, userID), "spendings from the last day should be kept")
+}
+
+func TestGuardOverride(t *testing.T) {
+	storage.Conn.Truncate([]string{models.TableNames.Users})
+	userID := createUser(t)
+	g := NewGuard(storage.Conn.DB.DB, Limits{MaxPurchase: 10 * DeweysPerLBC})
+
+	l, err := g.LimitsFor(boil.GetDB(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, Limits{MaxPurchase: 10 * DeweysPerLBC}, l)
+	_, err = g.Reserve(context.Background(), userID, query.MethodPurchaseCreate, 50*DeweysPerLBC)
+	assertLimitReached(t, err)
+
+	require.NoError(t, SetOverride(boil.GetDB(), userID, Override{MaxPurchase: null.Int64From(100 * DeweysPerLBC), DailySends: null.IntFrom(5)}))
+	l, err = g.LimitsFor(boil.GetDB(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, Limits{MaxPurchase: 100 * DeweysPerLBC, DailySends: 5}, l)
+	_, err = g.Reserve(context.Background(), userID, query.MethodPurchaseCreate, 50*DeweysPerLBC)
+	require.NoError(t, err)
+
+	assert.Error(t, SetOverride(boil.GetDB(), userID, Override{DailySpend: null.Int64From(-1)}))
+	_, err = boil.GetDB().Exec(`UPDATE "spending_limits" SET "daily_spend" = -1 WHERE "user_id" = $1`, userID)
+	assert.Error(t, err, "negative overrides should be rejected by the database")
+
+	require.NoError(t, SetOverride(boil.GetDB(), userID, Override{}))
+	l, err = g.LimitsFor(boil.GetDB(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, Limits{MaxPurchase: 10 * DeweysPerLBC}, l, "empty overrides should restore defaults")
+}
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"sync"
 	"time"
 
 	"github.com/lbryio/lbrytv/internal/metrics"
@@ -18,6 +19,10 @@ var (
 // tokenCache stores the cache in memory
 type tokenCache struct {
 	cache *gocache.Cache
+
+	// tokens indexes cached tokens by user ID, so all of them can be dropped when the user moves to another server
+	mu     sync.Mutex
+	tokens map[int]map[string]bool
 }
 
 func init() {
@@ -25,15 +30,51 @@ func init() {
 }
 
 func NewTokenCache(timeout time.Duration) *tokenCache {
-	return &tokenCache{cache: gocache.New(timeout, 45*time.Minute)}
+	c := &tokenCache{cache: gocache.New(timeout, 45*time.Minute), tokens: map[int]map[string]bool{}}
+	c.cache.OnEvicted(c.unindex)
+	return c
 }
 
 func SetTokenCache(c *tokenCache) {
 	currentCache = c
 }
 
+// ForgetUser removes the user from the auth token cache, so their server assignment is read from the database
+// on their next request.
+func ForgetUser(userID int) {
+	currentCache.forget(userID)
+}
+
 func (c *tokenCache) set(token string, user *models.User) {
 	c.cache.Set(token, *user, gocache.DefaultExpiration)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens[user.ID] == nil {
+		c.tokens[user.ID] = map[string]bool{}
+	}
+	c.tokens[user.ID][token] = true
+}
+
+func (c *tokenCache) forget(userID int) {
+	c.mu.Lock()
+	tokens := c.tokens[userID]
+	delete(c.tokens, userID)
+	c.mu.Unlock()
+
+	for token := range tokens {
+		c.cache.Delete(token)
+	}
+}
+
+// unindex is called by the cache when a token expires or is deleted.
+func (c *tokenCache) unindex(token string, v interface{}) {
+	user := v.(models.User)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens[user.ID], token)
+	if len(c.tokens[user.ID]) == 0 {
+		delete(c.tokens, user.ID)
+	}
 }
 
 func (c *tokenCache) get(token string) *models.User {
@@ -49,4 +90,7 @@ func (c *tokenCache) get(token string) *models.User {
 
 func (c *tokenCache) flush() {
 	c.cache.Flush()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = map[int]map[string]bool{}
 }
@@ -2,10 +2,12 @@ package wallet
 
 import (
 	"testing"
+	"time"
 
 	"github.com/lbryio/lbrytv/app/sdkrouter"
 	"github.com/lbryio/lbrytv/internal/metrics"
 	"github.com/lbryio/lbrytv/internal/test"
+	"github.com/lbryio/lbrytv/models"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -30,7 +32,7 @@ func TestCache(t *testing.T) {
 	// url, cleanup := dummyAPI(srv)
 	// defer cleanup()
 
-	err = assignSDKServerToUser(boil.GetDB(), user, rt.LeastLoaded(), logger.Log())
+	err = assignSDKServerToUser(boil.GetDB(), user, rt.PickServer(), logger.Log())
 	require.NoError(t, err)
 
 	currentCache.set(token, user)
@@ -41,3 +43,19 @@ func TestCache(t *testing.T) {
 	cachedUser = currentCache.get(token)
 	assert.Equal(t, user.ID, cachedUser.ID)
 }
+
+func TestCacheForgetUser(t *testing.T) {
+	c := NewTokenCache(time.Minute)
+	c.set("one", &models.User{ID: 1})
+	c.set("other", &models.User{ID: 1})
+	c.set("two", &models.User{ID: 2})
+
+	c.forget(1)
+	assert.Nil(t, c.get("one"))
+	assert.Nil(t, c.get("other"))
+	assert.NotNil(t, c.get("two"))
+	assert.NotContains(t, c.tokens, 1)
+
+	c.cache.Delete("two")
+	assert.Empty(t, c.tokens, "deleted tokens should be removed from the index")
+}
@@ -0,0 +1,279 @@
+package migration
+
+// Package migration moves users along with their wallets from one LbrynetServer to another,
+// which is needed for draining or retiring SDK instances.
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/app/wallet"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/lbrynet"
+	"github.com/lbryio/lbrytv/internal/monitor"
+	"github.com/lbryio/lbrytv/models"
+
+	"github.com/sirupsen/logrus"
+	"github.com/volatiletech/null"
+	"github.com/volatiletech/sqlboiler/boil"
+	"github.com/volatiletech/sqlboiler/queries/qm"
+)
+
+const progressLogEvery = 100
+
+var logger = monitor.NewModuleLogger("migration")
+
+// Options define a migration run.
+type Options struct {
+	From *models.LbrynetServer
+	To   *models.LbrynetServer
+
+	// DryRun only reports users that would be migrated without changing anything.
+	DryRun bool
+	// Limit is the maximum number of users to migrate in this run, 0 means no limit.
+	Limit int
+
+	// SourceWalletDir and TargetWalletDir are directories holding wallet files
+	// of the source and target servers, as seen from the machine running the migration.
+	// When both are set, wallet files are copied from one to another.
+	// When empty, wallet storage is assumed to be shared by both servers.
+	SourceWalletDir string
+	TargetWalletDir string
+}
+
+// Progress reports the state of a migration run.
+type Progress struct {
+	Total      int
+	Migrated   int
+	Failed     int
+	LastUserID int
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("%d/%d users migrated, %d failed", p.Migrated, p.Total, p.Failed)
+}
+
+// Migrate moves users from one server to another.
+// Users are only reassigned after their wallet is loaded on the target server, so an interrupted
+// migration is resumed by running it again: users that were moved already are no longer on the source server
+// and the ones that failed are retried. The state of each user's migration is saved in user_migrations.
+// Users left in progress by an interrupted run are marked as failed first, see staleAfter.
+func Migrate(exec boil.Executor, opts Options) (*Progress, error) {
+	if err := Validate(opts); err != nil {
+		return nil, err
+	}
+	log := logger.WithFields(logrus.Fields{"from": opts.From.Name, "to": opts.To.Name, "dry_run": opts.DryRun})
+
+	if !opts.DryRun {
+		stale, err := failStale(exec, opts.From.ID)
+		if err != nil {
+			return nil, err
+		}
+		if stale > 0 {
+			log.Infof("%d interrupted user migrations will be retried", stale)
+		}
+	}
+
+	mods := []qm.QueryMod{
+		models.UserWhere.LbrynetServerID.EQ(null.IntFrom(opts.From.ID)),
+		qm.OrderBy(models.UserColumns.ID),
+	}
+	if opts.Limit > 0 {
+		mods = append(mods, qm.Limit(opts.Limit))
+	}
+	users, err := models.Users(mods...).All(exec)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+
+	progress := &Progress{Total: len(users)}
+	log.Infof("migrating %d users", progress.Total)
+	start := time.Now()
+
+	for _, u := range users {
+		progress.LastUserID = u.ID
+		if opts.DryRun {
+			log.Infof("would migrate user %d", u.ID)
+			progress.Migrated++
+			continue
+		}
+
+		err := MigrateUser(exec, u, opts)
+		if err != nil {
+			log.WithField("user_id", u.ID).Error(err)
+			monitor.ErrorToSentry(err, map[string]string{"user_id": fmt.Sprintf("%d", u.ID)})
+			progress.Failed++
+		} else {
+			progress.Migrated++
+		}
+
+		if (progress.Migrated+progress.Failed)%progressLogEvery == 0 {
+			log.Infof("progress: %s, last user id %d", progress, progress.LastUserID)
+		}
+	}
+
+	log.Infof("migration finished in %s: %s", time.Since(start), progress)
+	return progress, nil
+}
+
+// Validate checks that migration options are consistent and users can be moved onto the target server.
+func Validate(opts Options) error {
+	if opts.From == nil || opts.To == nil {
+		return errors.Err("both source and target servers are required")
+	}
+	if opts.From.ID == 0 || opts.To.ID == 0 {
+		return errors.Err("servers must be stored in the database")
+	}
+	if opts.From.ID == opts.To.ID {
+		return errors.Err("source and target servers are the same")
+	}
+	if opts.To.Draining {
+		return errors.Err("target server is draining")
+	}
+	if (opts.SourceWalletDir == "") != (opts.TargetWalletDir == "") {
+		return errors.Err("both source and target wallet dirs should be set to copy wallet files")
+	}
+	return nil
+}
+
+// MigrateUser moves a single user's wallet to the target server and reassigns the user to it.
+// The user is marked as being migrated for the duration, so API servers don't load the wallet back
+// on the source server in the meantime, see CheckReload.
+// If the user can't be reassigned, the wallet is unloaded from the target server and loaded back on the source one.
+func MigrateUser(exec boil.Executor, user *models.User, opts Options) error {
+	if err := setState(exec, user.ID, opts, StateInProgress, nil); err != nil {
+		return errors.Prefix("saving migration state", err)
+	}
+
+	err := migrateUser(exec, user, opts)
+	if err != nil {
+		if stErr := setState(exec, user.ID, opts, StateFailed, err); stErr != nil {
+			logger.WithFields(logrus.Fields{"user_id": user.ID}).Errorf("failed to save migration state: %v", stErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func migrateUser(exec boil.Executor, user *models.User, opts Options) error {
+	log := logger.WithFields(logrus.Fields{"user_id": user.ID, "from": opts.From.Name, "to": opts.To.Name})
+
+	err := wallet.UnloadWallet(opts.From.Address, user.ID)
+	if err != nil && !errors.Is(err, lbrynet.ErrWalletNotLoaded) {
+		return errors.Prefix("unloading wallet from source", err)
+	}
+
+	loaded, err := loadOnTarget(user, opts)
+	if err == nil {
+		err = reassign(exec, user, opts)
+	}
+	if err != nil {
+		rollback(exec, user, opts, loaded, log)
+		return err
+	}
+
+	log.Info("user migrated")
+	return nil
+}
+
+// loadOnTarget copies the wallet if needed and loads it on the target server.
+// It reports whether the wallet was loaded by this call.
+func loadOnTarget(user *models.User, opts Options) (bool, error) {
+	if opts.SourceWalletDir != "" {
+		err := copyWallet(user.ID, opts.SourceWalletDir, opts.TargetWalletDir)
+		if err != nil {
+			return false, errors.Prefix("copying wallet file", err)
+		}
+	}
+
+	err := wallet.LoadWallet(opts.To.Address, user.ID)
+	if errors.Is(err, lbrynet.ErrWalletAlreadyLoaded) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Prefix("loading wallet on target", err)
+	}
+	return true, nil
+}
+
+// reassign moves the user to the target server and marks the migration done in a single statement.
+// The user is only reassigned if nothing else has done it in the meantime.
+func reassign(exec boil.Executor, user *models.User, opts Options) error {
+	q := fmt.Sprintf(`
+		WITH moved AS (
+			UPDATE "%s" SET "%s" = $1 WHERE "%s" = $2 AND "%s" = $3 RETURNING "%s"
+		)
+		UPDATE user_migrations SET state = $4, error = NULL, updated_at = now()
+		WHERE user_id IN (SELECT "%s" FROM moved)`,
+		models.TableNames.Users,
+		models.UserColumns.LbrynetServerID,
+		models.UserColumns.ID,
+		models.UserColumns.LbrynetServerID,
+		models.UserColumns.ID,
+		models.UserColumns.ID,
+	)
+	result, err := exec.Exec(q, opts.To.ID, user.ID, opts.From.ID, StateDone)
+	if err != nil {
+		return errors.Err(err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return errors.Err(err)
+	}
+	if count == 0 {
+		return errors.Err("user is no longer assigned to the source server")
+	}
+	user.LbrynetServerID.SetValid(opts.To.ID)
+	return nil
+}
+
+// rollback puts the wallet back where the user is assigned to now after a failed migration.
+// loadedOnTarget is true if the wallet was loaded on the target server during the migration.
+func rollback(exec boil.Executor, user *models.User, opts Options, loadedOnTarget bool, log *logrus.Entry) {
+	assigned, err := models.Users(models.UserWhere.ID.EQ(user.ID)).One(exec)
+	if err != nil {
+		log.Errorf("failed to check server assignment, leaving wallets as they are: %v", err)
+		return
+	}
+	serverID := assigned.LbrynetServerID
+
+	if loadedOnTarget && (!serverID.Valid || serverID.Int != opts.To.ID) {
+		if err := wallet.UnloadWallet(opts.To.Address, user.ID); err != nil && !errors.Is(err, lbrynet.ErrWalletNotLoaded) {
+			log.Errorf("failed to unload wallet from target: %v", err)
+		}
+	}
+	if serverID.Valid && serverID.Int == opts.From.ID {
+		if err := wallet.LoadWallet(opts.From.Address, user.ID); err != nil && !errors.Is(err, lbrynet.ErrWalletAlreadyLoaded) {
+			log.Errorf("failed to load wallet back on source: %v", err)
+		}
+	}
+}
+
+// copyWallet copies wallet file between directories, replacing the target file atomically.
+func copyWallet(userID int, srcDir, dstDir string) error {
+	name := sdkrouter.WalletID(userID)
+	src, err := os.Open(filepath.Join(srcDir, name))
+	if err != nil {
+		return errors.Err(err)
+	}
+	defer src.Close()
+
+	tmp, err := ioutil.TempFile(dstDir, name+".*.tmp")
+	if err != nil {
+		return errors.Err(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return errors.Err(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Err(err)
+	}
+	return errors.Err(os.Rename(tmp.Name(), filepath.Join(dstDir, name)))
+}
@@ -0,0 +1,268 @@
+package migration
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/errors"
+	"github.com/lbryio/lbrytv/internal/storage"
+	"github.com/lbryio/lbrytv/internal/test"
+	"github.com/lbryio/lbrytv/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volatiletech/null"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+const walletResponse = `{"result": {"id": "lbrytv-id.1.wallet", "name": "lbrytv-id.1.wallet"}}`
+
+func TestMain(m *testing.M) {
+	rand.Seed(time.Now().UnixNano())
+
+	dbConfig := config.GetDatabase()
+	params := storage.ConnParams{
+		Connection: dbConfig.Connection,
+		DBName:     dbConfig.DBName,
+		Options:    dbConfig.Options,
+	}
+	dbConn, connCleanup := storage.CreateTestConn(params)
+	dbConn.SetDefaultConnection()
+
+	code := m.Run()
+
+	connCleanup()
+	os.Exit(code)
+}
+
+func setupServers(t *testing.T, addresses ...string) []*models.LbrynetServer {
+	storage.Conn.Truncate([]string{models.TableNames.Users, models.TableNames.LbrynetServers})
+	servers := []*models.LbrynetServer{}
+	for i, a := range addresses {
+		s := &models.LbrynetServer{Name: string(rune('a' + i)), Address: a}
+		require.NoError(t, s.InsertG(boil.Infer()))
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+func createUser(t *testing.T, server *models.LbrynetServer) *models.User {
+	u := &models.User{ID: rand.Intn(99999), LbrynetServerID: null.IntFrom(server.ID)}
+	require.NoError(t, u.InsertG(boil.Infer()))
+	return u
+}
+
+func TestMigrate(t *testing.T) {
+	srcReqs := test.ReqChan()
+	src := test.MockHTTPServer(srcReqs)
+	defer src.Close()
+	dstReqs := test.ReqChan()
+	dst := test.MockHTTPServer(dstReqs)
+	defer dst.Close()
+
+	servers := setupServers(t, src.URL, dst.URL)
+	u := createUser(t, servers[0])
+
+	src.QueueResponses(walletResponse)
+	dst.QueueResponses(walletResponse)
+	progress, err := Migrate(boil.GetDB(), Options{From: servers[0], To: servers[1]})
+	require.NoError(t, err)
+	assert.Equal(t, Progress{Total: 1, Migrated: 1, LastUserID: u.ID}, *progress)
+
+	assert.Contains(t, (<-srcReqs).Body, `"method":"wallet_remove"`)
+	assert.Contains(t, (<-dstReqs).Body, `"method":"wallet_add"`)
+
+	require.NoError(t, u.ReloadG())
+	assert.Equal(t, servers[1].ID, u.LbrynetServerID.Int)
+	states, err := StateCounts(boil.GetDB(), servers[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{StateDone: 1}, states)
+
+	// re-running should be a no-op since there's no one left on the source server
+	progress, err = Migrate(boil.GetDB(), Options{From: servers[0], To: servers[1]})
+	require.NoError(t, err)
+	assert.Equal(t, 0, progress.Total)
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	reqs := test.ReqChan()
+	ts := test.MockHTTPServer(reqs)
+	defer ts.Close()
+
+	servers := setupServers(t, ts.URL, ts.URL)
+	u1 := createUser(t, servers[0])
+	u2 := createUser(t, servers[0])
+
+	progress, err := Migrate(boil.GetDB(), Options{From: servers[0], To: servers[1], DryRun: true, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 1, progress.Total)
+	assert.Equal(t, 1, progress.Migrated)
+	assert.Len(t, reqs, 0)
+
+	for _, u := range []*models.User{u1, u2} {
+		require.NoError(t, u.ReloadG())
+		assert.Equal(t, servers[0].ID, u.LbrynetServerID.Int)
+	}
+}
+
+func TestMigrateLoadFailure(t *testing.T) {
+	srcReqs := test.ReqChan()
+	src := test.MockHTTPServer(srcReqs)
+	defer src.Close()
+	dst := test.MockHTTPServer(nil)
+	defer dst.Close()
+
+	servers := setupServers(t, src.URL, dst.URL)
+	u := createUser(t, servers[0])
+
+	src.QueueResponses(walletResponse, walletResponse)
+	dst.QueueResponses(`{"error": {"code": -32500, "message": "Wallet at path '/wallets/x' was not found."}}`)
+	progress, err := Migrate(boil.GetDB(), Options{From: servers[0], To: servers[1]})
+	require.NoError(t, err)
+	assert.Equal(t, 1, progress.Failed)
+
+	assert.Contains(t, (<-srcReqs).Body, `"method":"wallet_remove"`)
+	assert.Contains(t, (<-srcReqs).Body, `"method":"wallet_add"`, "wallet should be loaded back on the source server")
+
+	require.NoError(t, u.ReloadG())
+	assert.Equal(t, servers[0].ID, u.LbrynetServerID.Int)
+	states, err := StateCounts(boil.GetDB(), servers[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{StateFailed: 1}, states)
+}
+
+func TestMigrateUserReassigned(t *testing.T) {
+	srcReqs := test.ReqChan()
+	src := test.MockHTTPServer(srcReqs)
+	defer src.Close()
+	dstReqs := test.ReqChan()
+	dst := test.MockHTTPServer(dstReqs)
+	defer dst.Close()
+
+	servers := setupServers(t, src.URL, dst.URL, "http://other")
+	u := createUser(t, servers[0])
+	// something else moves the user while they are being migrated
+	_, err := boil.GetDB().Exec(`UPDATE users SET lbrynet_server_id = $1 WHERE id = $2`, servers[2].ID, u.ID)
+	require.NoError(t, err)
+
+	src.QueueResponses(walletResponse)
+	dst.QueueResponses(walletResponse, walletResponse)
+	err = MigrateUser(boil.GetDB(), u, Options{From: servers[0], To: servers[1]})
+	assert.EqualError(t, err, "user is no longer assigned to the source server")
+
+	assert.Contains(t, (<-srcReqs).Body, `"method":"wallet_remove"`)
+	assert.Contains(t, (<-dstReqs).Body, `"method":"wallet_add"`)
+	assert.Contains(t, (<-dstReqs).Body, `"method":"wallet_remove"`, "wallet should be unloaded from the target server")
+	assert.Len(t, srcReqs, 0, "wallet should not be loaded back on the source server")
+}
+
+func TestCheckReload(t *testing.T) {
+	servers := setupServers(t, "http://a", "http://b")
+	u := createUser(t, servers[0])
+	opts := Options{From: servers[0], To: servers[1]}
+
+	assert.NoError(t, CheckReload(boil.GetDB(), "http://a", u.ID))
+	assert.NoError(t, CheckReload(boil.GetDB(), "http://a", 0))
+
+	require.NoError(t, setState(boil.GetDB(), u.ID, opts, StateInProgress, nil))
+	assert.True(t, errors.Is(CheckReload(boil.GetDB(), "http://a", u.ID), ErrMigrating))
+
+	require.NoError(t, reassign(boil.GetDB(), u, opts))
+	assert.True(t, errors.Is(CheckReload(boil.GetDB(), "http://a", u.ID), ErrMoved))
+	assert.NoError(t, CheckReload(boil.GetDB(), "http://b", u.ID))
+}
+
+func TestMigrateInterrupted(t *testing.T) {
+	srcReqs := test.ReqChan()
+	src := test.MockHTTPServer(srcReqs)
+	defer src.Close()
+	dstReqs := test.ReqChan()
+	dst := test.MockHTTPServer(dstReqs)
+	defer dst.Close()
+
+	servers := setupServers(t, src.URL, dst.URL)
+	u := createUser(t, servers[0])
+	opts := Options{From: servers[0], To: servers[1]}
+
+	// the process migrating the user dies before it's done with them
+	require.NoError(t, setState(boil.GetDB(), u.ID, opts, StateInProgress, nil))
+	assert.True(t, errors.Is(CheckReload(boil.GetDB(), src.URL, u.ID), ErrMigrating))
+	_, err := boil.GetDB().Exec(`UPDATE user_migrations SET updated_at = now() - interval '1 hour' WHERE user_id = $1`, u.ID)
+	require.NoError(t, err)
+	assert.NoError(t, CheckReload(boil.GetDB(), src.URL, u.ID), "interrupted migrations should not block reloads")
+
+	src.QueueResponses(walletResponse)
+	dst.QueueResponses(walletResponse)
+	progress, err := Migrate(boil.GetDB(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, Progress{Total: 1, Migrated: 1, LastUserID: u.ID}, *progress)
+	assert.Contains(t, (<-srcReqs).Body, `"method":"wallet_remove"`)
+	assert.Contains(t, (<-dstReqs).Body, `"method":"wallet_add"`)
+
+	require.NoError(t, u.ReloadG())
+	assert.Equal(t, servers[1].ID, u.LbrynetServerID.Int)
+	states, err := StateCounts(boil.GetDB(), servers[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{StateDone: 1}, states)
+}
+
+func TestFailStale(t *testing.T) {
+	servers := setupServers(t, "http://a", "http://b")
+	opts := Options{From: servers[0], To: servers[1]}
+	fresh := createUser(t, servers[0])
+	stale := createUser(t, servers[0])
+	require.NoError(t, setState(boil.GetDB(), fresh.ID, opts, StateInProgress, nil))
+	require.NoError(t, setState(boil.GetDB(), stale.ID, opts, StateInProgress, nil))
+	_, err := boil.GetDB().Exec(`UPDATE user_migrations SET updated_at = now() - interval '1 hour' WHERE user_id = $1`, stale.ID)
+	require.NoError(t, err)
+
+	n, err := failStale(boil.GetDB(), servers[0].ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	states, err := StateCounts(boil.GetDB(), servers[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{StateInProgress: 1, StateFailed: 1}, states, "migrations in progress elsewhere should be left alone")
+}
+
+func TestMigrateValidation(t *testing.T) {
+	a := &models.LbrynetServer{ID: 1}
+	b := &models.LbrynetServer{ID: 2}
+	_, err := Migrate(boil.GetDB(), Options{From: a, To: a})
+	assert.EqualError(t, err, "source and target servers are the same")
+	_, err = Migrate(boil.GetDB(), Options{From: a, To: &models.LbrynetServer{}})
+	assert.EqualError(t, err, "servers must be stored in the database")
+	_, err = Migrate(boil.GetDB(), Options{From: a, To: b, SourceWalletDir: "/tmp"})
+	assert.EqualError(t, err, "both source and target wallet dirs should be set to copy wallet files")
+	_, err = Migrate(boil.GetDB(), Options{From: a, To: &models.LbrynetServer{ID: 2, Draining: true}})
+	assert.EqualError(t, err, "target server is draining")
+}
+
+func TestCopyWallet(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "src")
+	require.NoError(t, err)
+	defer os.RemoveAll(srcDir)
+	dstDir, err := ioutil.TempDir("", "dst")
+	require.NoError(t, err)
+	defer os.RemoveAll(dstDir)
+
+	name := sdkrouter.WalletID(123)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(srcDir, name), []byte("new wallet"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dstDir, name), []byte("old wallet"), 0600))
+
+	require.NoError(t, copyWallet(123, srcDir, dstDir))
+	content, err := ioutil.ReadFile(filepath.Join(dstDir, name))
+	require.NoError(t, err)
+	assert.Equal(t, "new wallet", string(content))
+
+	files, err := ioutil.ReadDir(dstDir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "temporary files should be cleaned up")
+
+	assert.Error(t, copyWallet(456, srcDir, dstDir))
+}
@@ -0,0 +1,119 @@
+package migration
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lbryio/lbrytv/app/wallet"
+	"github.com/lbryio/lbrytv/internal/errors"
+
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+// Migration states of a user, kept in user_migrations table.
+const (
+	StateInProgress = "in_progress"
+	StateFailed     = "failed"
+	StateDone       = "done"
+)
+
+// staleAfter is how long a user can be in progress before the migration is considered interrupted,
+// e.g. by the process running it being restarted. It is well over the time it takes to migrate a single user.
+const staleAfter = 10 * time.Minute
+
+var (
+	ErrMigrating = errors.Base("wallet is being migrated to another server, retry later")
+	ErrMoved     = errors.Base("wallet has been moved to another server, retry")
+)
+
+// setState records the migration state of the user. migrationErr is stored for failed migrations.
+func setState(exec boil.Executor, userID int, opts Options, state string, migrationErr error) error {
+	var errMsg sql.NullString
+	if migrationErr != nil {
+		errMsg = sql.NullString{String: migrationErr.Error(), Valid: true}
+	}
+	_, err := exec.Exec(`
+		INSERT INTO user_migrations (user_id, from_server_id, to_server_id, state, error)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			from_server_id = EXCLUDED.from_server_id,
+			to_server_id = EXCLUDED.to_server_id,
+			state = EXCLUDED.state,
+			error = EXCLUDED.error,
+			updated_at = now()`,
+		userID, opts.From.ID, opts.To.ID, state, errMsg,
+	)
+	return errors.Err(err)
+}
+
+// failStale marks migrations off the server that have been in progress for longer than staleAfter as failed,
+// so the users are retried. It returns the number of such migrations.
+func failStale(exec boil.Executor, fromServerID int) (int64, error) {
+	result, err := exec.Exec(`
+		UPDATE user_migrations SET state = $1, error = $2, updated_at = now()
+		WHERE from_server_id = $3 AND state = $4 AND updated_at < now() - $5::float8 * interval '1 second'`,
+		StateFailed, "migration interrupted", fromServerID, StateInProgress, staleAfter.Seconds(),
+	)
+	if err != nil {
+		return 0, errors.Err(err)
+	}
+	n, err := result.RowsAffected()
+	return n, errors.Err(err)
+}
+
+// StateCounts returns the number of users in each migration state for migrations off the server.
+func StateCounts(exec boil.Executor, fromServerID int) (map[string]int, error) {
+	rows, err := exec.Query(
+		`SELECT state, count(*) FROM user_migrations WHERE from_server_id = $1 GROUP BY state`, fromServerID)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var (
+			state string
+			n     int
+		)
+		if err := rows.Scan(&state, &n); err != nil {
+			return nil, errors.Err(err)
+		}
+		counts[state] = n
+	}
+	return counts, errors.Err(rows.Err())
+}
+
+// CheckReload returns an error if the wallet of the user should not be loaded on the server at address,
+// which is when the user is being migrated or has been moved to another server.
+// Migrations in progress for longer than staleAfter have been interrupted and don't block reloads.
+// Users moved to another server are also removed from the auth token cache, so they get routed to the new one.
+func CheckReload(exec boil.Executor, address string, userID int) error {
+	if userID <= 0 {
+		return nil
+	}
+	var (
+		assigned  sql.NullString
+		migrating sql.NullBool
+	)
+	err := exec.QueryRow(`
+		SELECT s.address, m.state = $2 AND m.updated_at >= now() - $3::float8 * interval '1 second' FROM users u
+		LEFT JOIN lbrynet_servers s ON s.id = u.lbrynet_server_id
+		LEFT JOIN user_migrations m ON m.user_id = u.id
+		WHERE u.id = $1`, userID, StateInProgress, staleAfter.Seconds(),
+	).Scan(&assigned, &migrating)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return errors.Err(err)
+	}
+
+	if migrating.Bool {
+		return errors.Err(ErrMigrating)
+	}
+	if assigned.Valid && assigned.String != address {
+		wallet.ForgetUser(userID)
+		return errors.Err(ErrMoved)
+	}
+	return nil
+}
@@ -72,7 +72,7 @@ func GetUserWithSDKServer(rt *sdkrouter.Router, internalAPIHost, token, metaRemo
 		}
 
 		if localUser.LbrynetServerID.IsZero() {
-			err := assignSDKServerToUser(tx, localUser, rt.LeastLoaded(), log)
+			err := assignSDKServerToUser(tx, localUser, rt.PickServer(), log)
 			if err != nil {
 				return err
 			}
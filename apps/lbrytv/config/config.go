@@ -38,12 +38,20 @@ func init() {
 	c.Viper.BindEnv("Lbrynet")
 	c.Viper.BindEnv("SentryDSN")
 	c.Viper.BindEnv("DatabaseDSN")
+	c.Viper.BindEnv("AdminToken")
 
 	c.Viper.SetDefault("Address", ":8080")
 	c.Viper.SetDefault("Host", "http://localhost:8080")
 	c.Viper.SetDefault("BaseContentURL", "http://localhost:8080/content/")
 	c.Viper.SetDefault("ReflectorTimeout", int64(10))
 	c.Viper.SetDefault("RefractorTimeout", int64(10))
+	c.Viper.SetDefault("MaxBatchSize", 100)
+	c.Viper.SetDefault("SDKRouterStrategy", "least_loaded")
+	c.Viper.SetDefault("SDKHealthProbeInterval", 30)
+	c.Viper.SetDefault("QueryCacheBackend", "memory")
+	c.Viper.SetDefault("QueryCacheTTL", 300)
+	c.Viper.SetDefault("QueryCacheMaxSize", 256)
+	c.Viper.SetDefault("RateLimitBackend", "memory")
 
 	c.Viper.AddConfigPath(os.Getenv("LBRYTV_CONFIG_DIR"))
 	c.Viper.AddConfigPath(ProjectRoot())
@@ -103,6 +111,11 @@ func GetPaidTokenPrivKey() string {
 	return Config.Viper.GetString("PaidTokenPrivKey")
 }
 
+// GetMaxBatchSize returns the maximum number of queries allowed in a single JSON-RPC batch request
+func GetMaxBatchSize() int {
+	return Config.Viper.GetInt("MaxBatchSize")
+}
+
 // GetAddress determines address to bind http API server to
 func GetAddress() string {
 	return Config.Viper.GetString("Address")
@@ -139,6 +152,16 @@ func RestoreOverridden() {
 	Config.RestoreOverridden()
 }
 
+// GetSDKRouterStrategy returns the name of the strategy used for assigning lbrynet servers to new users
+func GetSDKRouterStrategy() string {
+	return Config.Viper.GetString("SDKRouterStrategy")
+}
+
+// GetSDKHealthProbeInterval returns how often lbrynet servers are probed for health.
+func GetSDKHealthProbeInterval() time.Duration {
+	return Config.Viper.GetDuration("SDKHealthProbeInterval") * time.Second
+}
+
 func GetLbrynetXServer() string {
 	return Config.Viper.GetString("LbrynetXServer")
 }
@@ -147,6 +170,233 @@ func GetLbrynetXPercentage() int {
 	return Config.Viper.GetInt("LbrynetXPercentage")
 }
 
+// GetMethodPolicyFile returns the path to YAML file declaring which SDK methods can be called,
+// the built-in policy is used if it's not set.
+func GetMethodPolicyFile() string {
+	return Config.Viper.GetString("MethodPolicyFile")
+}
+
+// GetMethodTimeouts returns for how long (in seconds) SDK queries are waited on, by method.
+// Only overrides of the built-in timeouts need to be set.
+func GetMethodTimeouts() (map[string]int, error) {
+	var timeouts map[string]int
+	err := Config.Viper.UnmarshalKey("MethodTimeouts", &timeouts)
+	return timeouts, err
+}
+
+// Hedging sets which SDK queries are sent to a second server when the first one is slow to respond.
+type Hedging struct {
+	// Methods are hedged when called without a wallet, only idempotent read-only methods should be listed
+	Methods []string
+	// Percentile of recent response times of the method after which the query is hedged
+	Percentile float64
+	// MinDelay and MaxDelay (in milliseconds) bound the hedging delay, MaxDelay is used until response times are known
+	MinDelay int
+	MaxDelay int
+}
+
+// GetHedging returns SDK query hedging settings, zero values if they're not set in the config.
+func GetHedging() (Hedging, error) {
+	var h Hedging
+	err := Config.Viper.UnmarshalKey("Hedging", &h)
+	return h, err
+}
+
+// Failover sets how SDK queries made without a wallet are resent to other servers after network errors.
+type Failover struct {
+	// Retries is the number of other servers a query is resent to
+	Retries int
+	// Backoff and MaxBackoff (in milliseconds) bound the wait before each retry, which doubles with every retry
+	Backoff    int
+	MaxBackoff int
+}
+
+// GetFailover returns SDK query failover settings, zero values if they're not set in the config.
+func GetFailover() (Failover, error) {
+	var f Failover
+	err := Config.Viper.UnmarshalKey("Failover", &f)
+	return f, err
+}
+
+// StreamCache sets how long (in seconds) `get` keeps stream metadata and purchase receipts of users.
+type StreamCache struct {
+	TTL         int
+	PurchaseTTL int
+}
+
+// GetStreamCache returns `get` cache settings, zero values if they're not set in the config.
+func GetStreamCache() (StreamCache, error) {
+	var c StreamCache
+	err := Config.Viper.UnmarshalKey("StreamCache", &c)
+	return c, err
+}
+
+// StreamURLs sets how URLs of streams returned by `get` are built.
+type StreamURLs struct {
+	// Builder is one of player, signed_cdn or regional, player is used if it's not set
+	Builder string
+	// SigningKey and ExpiresIn (in seconds) are used by the signed_cdn builder
+	SigningKey string
+	ExpiresIn  int
+	// Regions are used by the regional builder, streams are played from BaseContentURL outside of them
+	Regions []StreamURLRegion
+}
+
+// StreamURLRegion is a player serving users from certain networks.
+type StreamURLRegion struct {
+	Name     string
+	Networks []string
+	BaseURL  string
+}
+
+// GetStreamURLs returns stream URL settings, zero values if they're not set in the config.
+func GetStreamURLs() (StreamURLs, error) {
+	var u StreamURLs
+	err := Config.Viper.UnmarshalKey("StreamURLs", &u)
+	return u, err
+}
+
+// SpendingLimits are default spending limits of users, amounts are in LBC. Zero values mean no limit.
+type SpendingLimits struct {
+	// MaxPurchase is the largest amount a single purchase can be for
+	MaxPurchase float64
+	// DailySpend is the total amount of purchases and sends over a day
+	DailySpend float64
+	// DailySends is the number of wallet_send and account_send queries over a day
+	DailySends int
+}
+
+// GetSpendingLimits returns default spending limits of users, zero values if they're not set in the config.
+func GetSpendingLimits() (SpendingLimits, error) {
+	var l SpendingLimits
+	err := Config.Viper.UnmarshalKey("SpendingLimits", &l)
+	return l, err
+}
+
+// GetQueryCacheBackend returns the type of SDK query cache, lru, memory or redis.
+func GetQueryCacheBackend() string {
+	return Config.Viper.GetString("QueryCacheBackend")
+}
+
+// GetQueryCacheRedisURL returns the address of Redis server for redis query cache backend.
+func GetQueryCacheRedisURL() string {
+	return Config.Viper.GetString("QueryCacheRedisURL")
+}
+
+// GetQueryCacheTTL returns for how long SDK query responses are kept in lru and redis caches by default.
+func GetQueryCacheTTL() time.Duration {
+	return Config.Viper.GetDuration("QueryCacheTTL") * time.Second
+}
+
+// GetQueryCacheIgnoredParams returns SDK query params that are not taken into account when caching responses.
+func GetQueryCacheIgnoredParams() []string {
+	return Config.Viper.GetStringSlice("QueryCacheIgnoredParams")
+}
+
+// GetQueryCacheMaxSize returns the size limit of lru query cache in bytes.
+func GetQueryCacheMaxSize() int64 {
+	return Config.Viper.GetInt64("QueryCacheMaxSize") * 1024 * 1024
+}
+
+// QueryCacheRule describes which SDK queries should be cached and for how long.
+type QueryCacheRule struct {
+	// Name identifies the rule in metrics
+	Name   string
+	Method string
+	// TTL is in seconds
+	TTL int
+	// StaleTTL (in seconds) is for how long after TTL a stale response is served while it's refreshed in the background
+	StaleTTL int
+	// MoreItemsThan requires list params to contain more than the given number of items
+	MoreItemsThan map[string]int
+	// WithoutParams prevents queries containing any of these params from being cached
+	WithoutParams []string
+}
+
+// GetQueryCachePolicy returns SDK query caching rules, nil if they're not set in the config.
+func GetQueryCachePolicy() ([]QueryCacheRule, error) {
+	var rules []QueryCacheRule
+	err := Config.Viper.UnmarshalKey("QueryCachePolicy", &rules)
+	return rules, err
+}
+
+// RateLimit limits how often a single user or IP address can call an SDK method.
+// Method "*" applies to all methods that don't have their own limit.
+type RateLimit struct {
+	Method string
+	// Rate is the number of queries per second allowed on average
+	Rate float64
+	// Burst is the number of queries allowed at once
+	Burst int
+}
+
+// GetRateLimits returns SDK method rate limits, nil if they're not set in the config.
+func GetRateLimits() ([]RateLimit, error) {
+	var limits []RateLimit
+	err := Config.Viper.UnmarshalKey("RateLimits", &limits)
+	return limits, err
+}
+
+// GetRateLimitBackend returns where rate limiting state is kept, memory or redis.
+func GetRateLimitBackend() string {
+	return Config.Viper.GetString("RateLimitBackend")
+}
+
+// GetRateLimitRedisURL returns the address of Redis server for redis rate limit backend.
+func GetRateLimitRedisURL() string {
+	return Config.Viper.GetString("RateLimitRedisURL")
+}
+
+// SDKConcurrencyLimit limits the number of queries sent to an LbrynetServer at the same time.
+// Server "*" applies to all servers that don't have their own limit.
+type SDKConcurrencyLimit struct {
+	// Server is the LbrynetServer address
+	Server        string
+	MaxConcurrent int
+	// MaxQueue is the number of queries that can wait for their turn when MaxConcurrent queries are in flight
+	MaxQueue int
+	// QueueTimeout (in seconds) is how long a query can wait for its turn
+	QueueTimeout int
+}
+
+// GetSDKConcurrencyLimits returns per-server limits of in-flight queries, nil if they're not set in the config.
+func GetSDKConcurrencyLimits() ([]SDKConcurrencyLimit, error) {
+	var limits []SDKConcurrencyLimit
+	err := Config.Viper.UnmarshalKey("SDKConcurrencyLimits", &limits)
+	return limits, err
+}
+
+// SDKCircuitBreaker sets when calls to an LbrynetServer start failing fast.
+type SDKCircuitBreaker struct {
+	// Window (in seconds) is the period over which error and slow call rates are calculated
+	Window int
+	// MinRequests is the number of calls within Window required for the breaker to trip
+	MinRequests int
+	// ErrorRate is the share of failed calls at which the breaker trips
+	ErrorRate float64
+	// SlowCall (in seconds) is the duration after which a call is considered slow
+	SlowCall int
+	// SlowCallRate is the share of slow calls at which the breaker trips
+	SlowCallRate float64
+	// OpenDuration (in seconds) is for how long calls fail fast before probe calls are let through
+	OpenDuration int
+	// HalfOpenRequests is the number of successful probe calls required for the breaker to close
+	HalfOpenRequests int
+}
+
+// GetSDKCircuitBreaker returns LbrynetServer circuit breaker settings, zero values if they're not set in the config.
+func GetSDKCircuitBreaker() (SDKCircuitBreaker, error) {
+	var s SDKCircuitBreaker
+	err := Config.Viper.UnmarshalKey("SDKCircuitBreaker", &s)
+	return s, err
+}
+
+// GetAdminToken returns the token required for calling internal admin API.
+// Admin API is disabled when the token is not set.
+func GetAdminToken() string {
+	return Config.Viper.GetString("AdminToken")
+}
+
 func GetTokenCacheTimeout() time.Duration {
 	return Config.Viper.GetDuration("TokenCacheTimeout") * time.Second
 }
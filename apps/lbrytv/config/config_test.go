@@ -33,3 +33,17 @@ func TestGetTokenCacheTimeout(t *testing.T) {
 	defer Config.RestoreOverridden()
 	assert.Equal(t, 325*time.Second, GetTokenCacheTimeout())
 }
+
+func TestGetQueryCachePolicy(t *testing.T) {
+	Config.Override("QueryCachePolicy", []interface{}{
+		map[string]interface{}{"Name": "resolve_many", "Method": "resolve", "TTL": 60, "MoreItemsThan": map[string]interface{}{"urls": 10}},
+		map[string]interface{}{"Method": "claim_search", "WithoutParams": []interface{}{"wallet_id"}},
+	})
+	defer Config.RestoreOverridden()
+	rules, err := GetQueryCachePolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, []QueryCacheRule{
+		{Name: "resolve_many", Method: "resolve", TTL: 60, MoreItemsThan: map[string]int{"urls": 10}},
+		{Method: "claim_search", WithoutParams: []string{"wallet_id"}},
+	}, rules)
+}
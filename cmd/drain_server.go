@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/lbryio/lbrytv/app/admin"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+var undrain bool
+
+func init() {
+	drainServer.Flags().BoolVar(&undrain, "off", false, "stop draining the server so it gets new users again")
+	rootCmd.AddCommand(drainServer)
+}
+
+var drainServer = &cobra.Command{
+	Use:   "drain_server NAME",
+	Short: "Stop assigning new users to lbrynet server NAME",
+	Long: `Stop assigning new users and anonymous traffic to lbrynet server NAME.
+
+Users already assigned to the server keep using it until they are moved with migrate_users.
+This only writes to the database, running API processes pick up the change on their next
+server list reload, which happens at most every 30 seconds.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		draining := !undrain
+		server, err := admin.UpdateServer(boil.GetDB(), args[0], admin.ServerParams{Draining: &draining})
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		log.Infof("server %s draining: %v", server.Name, server.Draining)
+	},
+}
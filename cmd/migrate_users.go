@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/lbryio/lbrytv/app/wallet/migration"
+	"github.com/lbryio/lbrytv/models"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+var migrateUsersOpts migration.Options
+
+func init() {
+	migrateUsers.Flags().BoolVar(&migrateUsersOpts.DryRun, "dry-run", false, "only list users that would be migrated")
+	migrateUsers.Flags().IntVar(&migrateUsersOpts.Limit, "limit", 0, "maximum number of users to migrate (0 for no limit)")
+	migrateUsers.Flags().StringVar(&migrateUsersOpts.SourceWalletDir, "source-wallet-dir", "",
+		"directory with wallet files of the source server (omit if wallet storage is shared)")
+	migrateUsers.Flags().StringVar(&migrateUsersOpts.TargetWalletDir, "target-wallet-dir", "",
+		"directory with wallet files of the target server (omit if wallet storage is shared)")
+	rootCmd.AddCommand(migrateUsers)
+}
+
+var migrateUsers = &cobra.Command{
+	Use:   "migrate_users FROM TO",
+	Short: "Move users and their wallets from one lbrynet server to another",
+	Long: `Move users and their wallets from lbrynet server FROM to server TO (server names as stored in the database).
+
+An interrupted migration can be resumed by running the command again. Migration state of each user
+is saved in the database, admin API can start migrations and report their progress as well.
+While a user is being migrated, API servers don't load their wallet on the old server and ask them to retry.
+Once a user is moved, API servers drop them from the auth token cache when their wallet is not found
+on the old server, so the next request is routed to the new one.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		migrateUsersOpts.From, err = models.LbrynetServers(models.LbrynetServerWhere.Name.EQ(args[0])).OneG()
+		if err != nil {
+			log.Errorf("cannot find server %s: %v", args[0], err)
+			os.Exit(1)
+		}
+		migrateUsersOpts.To, err = models.LbrynetServers(models.LbrynetServerWhere.Name.EQ(args[1])).OneG()
+		if err != nil {
+			log.Errorf("cannot find server %s: %v", args[1], err)
+			os.Exit(1)
+		}
+
+		progress, err := migration.Migrate(boil.GetDB(), migrateUsersOpts)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		log.Infof("done: %s", progress)
+		if progress.Failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
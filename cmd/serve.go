@@ -6,12 +6,17 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/lbryio/lbrytv-player/pkg/paid"
+	"github.com/lbryio/lbrytv/app/query"
 	"github.com/lbryio/lbrytv/app/sdkrouter"
+	"github.com/lbryio/lbrytv/app/spending"
 	"github.com/lbryio/lbrytv/app/wallet"
 	"github.com/lbryio/lbrytv/apps/lbrytv/config"
+	"github.com/lbryio/lbrytv/internal/storage"
 	"github.com/lbryio/lbrytv/server"
 
 	"github.com/spf13/cobra"
@@ -23,10 +28,89 @@ var rootCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		rand.Seed(time.Now().UnixNano()) // always seed random!
 		sdkRouter := sdkrouter.New(config.GetLbrynetServers())
+		strategy, err := sdkrouter.NewStrategy(config.GetSDKRouterStrategy())
+		if err != nil {
+			log.Fatal(err)
+		}
+		sdkRouter.SetStrategy(strategy)
 		go sdkRouter.WatchLoad()
+		go sdkRouter.WatchHealth(config.GetSDKHealthProbeInterval())
+
+		concurrencyCfg, err := config.GetSDKConcurrencyLimits()
+		if err != nil {
+			log.Fatal(err)
+		}
+		concurrencyLimits, err := sdkrouter.NewConcurrencyLimits(concurrencyCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sdkRouter.SetConcurrencyLimits(concurrencyLimits)
+
+		breakerCfg, err := config.GetSDKCircuitBreaker()
+		if err != nil {
+			log.Fatal(err)
+		}
+		breakerSettings, err := sdkrouter.NewBreakerSettings(breakerCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sdkRouter.SetBreakerSettings(breakerSettings)
+
+		if err := query.LoadMethodPolicy(config.GetMethodPolicyFile()); err != nil {
+			log.Fatal(err)
+		}
+		policySigs := make(chan os.Signal, 1)
+		signal.Notify(policySigs, syscall.SIGHUP)
+		go query.WatchMethodPolicy(config.GetMethodPolicyFile(), policySigs)
+
+		var callerOpts query.CallerOptions
+		hedgingCfg, err := config.GetHedging()
+		if err != nil {
+			log.Fatal(err)
+		}
+		callerOpts.Hedging, err = query.NewHedging(hedgingCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		failoverCfg, err := config.GetFailover()
+		if err != nil {
+			log.Fatal(err)
+		}
+		callerOpts.Failover, err = query.NewFailover(failoverCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		streamCacheCfg, err := config.GetStreamCache()
+		if err != nil {
+			log.Fatal(err)
+		}
+		callerOpts.StreamCache = query.NewStreamCache(streamCacheCfg)
+
+		streamURLsCfg, err := config.GetStreamURLs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		callerOpts.StreamURLBuilder, err = query.NewStreamURLBuilder(config.Config.Viper.GetString("BaseContentURL"), streamURLsCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		spendingCfg, err := config.GetSpendingLimits()
+		if err != nil {
+			log.Fatal(err)
+		}
+		spendingLimits, err := spending.NewLimits(spendingCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		spendingGuard := spending.NewGuard(storage.Conn.DB.DB, spendingLimits)
+		go spendingGuard.WatchPrune()
+		callerOpts.Spending = spendingGuard
 
-		s := server.NewServer(config.GetAddress(), sdkRouter)
-		err := s.Start()
+		s := server.NewServer(config.GetAddress(), sdkRouter, callerOpts)
+		err = s.Start()
 		if err != nil {
 			log.Fatal(err)
 		}
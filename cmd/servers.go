@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/lbryio/lbrytv/app/admin"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/volatiletech/sqlboiler/boil"
+)
+
+var (
+	serverAddress  string
+	serverWeight   int
+	serverDraining bool
+)
+
+func init() {
+	for _, c := range []*cobra.Command{serversAdd, serversUpdate} {
+		c.Flags().StringVar(&serverAddress, "address", "", "lbrynet API address, e.g. http://lbrynet1:5279/")
+		c.Flags().IntVar(&serverWeight, "weight", 0, "weight for the weighted_random router strategy")
+		c.Flags().BoolVar(&serverDraining, "draining", false, "do not assign new users to the server")
+	}
+	servers.AddCommand(serversList, serversAdd, serversUpdate, serversRemove)
+	rootCmd.AddCommand(servers)
+}
+
+var servers = &cobra.Command{
+	Use:   "servers",
+	Short: "Manage lbrynet servers",
+	Long: `Manage lbrynet servers stored in the database.
+
+Server address is validated by calling lbrynet status before saving.
+
+These commands only write to the database. Running API processes reload the server list
+at most every 30 seconds, so they keep routing by the old list until their next reload.
+Changes made via admin API are applied right away by the process that handled the request,
+other processes still pick them up on their next reload.`,
+}
+
+var serversList = &cobra.Command{
+	Use:   "list",
+	Short: "List lbrynet servers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := admin.ListServers(boil.GetDB())
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tADDRESS\tWEIGHT\tDRAINING")
+		for _, s := range list {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%v\n", s.Name, s.Address, s.Weight, s.Draining)
+		}
+		w.Flush()
+	},
+}
+
+var serversAdd = &cobra.Command{
+	Use:   "add NAME",
+	Short: "Add lbrynet server NAME",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		params := serverParamsFromFlags(cmd)
+		params.Name = args[0]
+		s, err := admin.AddServer(boil.GetDB(), params)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		log.Infof("server %s added at %s, API servers will pick it up within 30 seconds", s.Name, s.Address)
+	},
+}
+
+var serversUpdate = &cobra.Command{
+	Use:   "update NAME",
+	Short: "Update address, weight or drain flag of lbrynet server NAME",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := admin.UpdateServer(boil.GetDB(), args[0], serverParamsFromFlags(cmd))
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		log.Infof(
+			"server %s updated: address %s, weight %d, draining %v, API servers will pick it up within 30 seconds",
+			s.Name, s.Address, s.Weight, s.Draining)
+	},
+}
+
+var serversRemove = &cobra.Command{
+	Use:   "remove NAME",
+	Short: "Remove lbrynet server NAME, which should have no users assigned",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := admin.RemoveServer(boil.GetDB(), args[0])
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		log.Infof("server %s removed, API servers will pick it up within 30 seconds", args[0])
+	},
+}
+
+// serverParamsFromFlags only sets the fields for flags that were given on the command line.
+func serverParamsFromFlags(cmd *cobra.Command) admin.ServerParams {
+	var params admin.ServerParams
+	if cmd.Flags().Changed("address") {
+		params.Address = &serverAddress
+	}
+	if cmd.Flags().Changed("weight") {
+		params.Weight = &serverWeight
+	}
+	if cmd.Flags().Changed("draining") {
+		params.Draining = &serverDraining
+	}
+	return params
+}
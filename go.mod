@@ -3,9 +3,11 @@ module github.com/lbryio/lbrytv
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.11.4
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/getkin/kin-openapi v0.15.0
 	github.com/getsentry/sentry-go v0.6.1
+	github.com/go-redis/redis/v7 v7.4.0
 	github.com/gobuffalo/packr/v2 v2.8.0
 	github.com/gorilla/mux v1.7.3
 	github.com/jinzhu/copier v0.0.0-20190924061706-b57f9002281a
@@ -15,6 +17,7 @@ require (
 	github.com/lbryio/lbry.go/v2 v2.6.1-0.20200520171819-ccef4d8e4d76
 	github.com/lbryio/lbrytv-player v0.3.0
 	github.com/lbryio/reflector.go v1.1.3-0.20200403124949-9c1b023de685
+	github.com/lbryio/types v0.0.0-20191228214437-05a22073b4ec
 	github.com/lib/pq v1.7.0
 	github.com/markbates/pkger v0.17.0
 	github.com/mitchellh/mapstructure v1.3.2 // indirect
@@ -40,4 +43,5 @@ require (
 	golang.org/x/text v0.3.3 // indirect
 	gopkg.in/DATA-DOG/go-sqlmock.v1 v1.3.0 // indirect
 	gopkg.in/ini.v1 v1.57.0 // indirect
+	gopkg.in/yaml.v2 v2.3.0
 )
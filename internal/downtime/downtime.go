@@ -0,0 +1,32 @@
+// Package downtime keeps track of backends that have failed recently, so their users can switch
+// to a fallback for a while instead of waiting on the failing backend with every call.
+package downtime
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Tracker marks a backend as unavailable for a period after it fails.
+// It is safe for concurrent use.
+type Tracker struct {
+	retryAfter time.Duration
+
+	// downUntil is a unix nano timestamp until which the backend is considered unavailable
+	downUntil int64
+}
+
+// NewTracker creates a Tracker considering the backend unavailable for retryAfter after each failure.
+func NewTracker(retryAfter time.Duration) *Tracker {
+	return &Tracker{retryAfter: retryAfter}
+}
+
+// IsUp returns true if the backend should be tried.
+func (t *Tracker) IsUp() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&t.downUntil)
+}
+
+// MarkDown records a failure of the backend, so it's not tried for the retry period.
+func (t *Tracker) MarkDown() {
+	atomic.StoreInt64(&t.downUntil, time.Now().Add(t.retryAfter).UnixNano())
+}
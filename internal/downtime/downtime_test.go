@@ -0,0 +1,18 @@
+package downtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker(50 * time.Millisecond)
+	assert.True(t, tr.IsUp())
+
+	tr.MarkDown()
+	assert.False(t, tr.IsUp())
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, tr.IsUp(), "backend should be tried again after the retry period")
+}
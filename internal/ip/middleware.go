@@ -11,12 +11,18 @@ const contextKey ctxKey = iota
 
 // FromRequest retrieves remote user IP from http.Request that went through our middleware
 func FromRequest(r *http.Request) string {
-	v := r.Context().Value(contextKey)
-	if v == nil {
+	if r.Context().Value(contextKey) == nil {
 		logger.Log().Warn("ip.FromRequest was called but ip.Middleware wasn't applied")
 		return ""
 	}
-	return v.(string)
+	return FromContext(r.Context())
+}
+
+// FromContext retrieves remote user IP from the context of a request that went through our middleware,
+// empty string if there is none.
+func FromContext(ctx context.Context) string {
+	v, _ := ctx.Value(contextKey).(string)
+	return v
 }
 
 // Middleware will attach remote user IP to every request
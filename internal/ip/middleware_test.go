@@ -1,6 +1,7 @@
 package ip
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -38,3 +39,15 @@ func TestFromRequest_MiddlewareNotApplied(t *testing.T) {
 	})
 	h.ServeHTTP(rr, r)
 }
+
+func TestFromContext(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "", nil)
+	r.Header.Add("X-Forwarded-For", "8.8.8.8")
+	rr := httptest.NewRecorder()
+	mw := middleware.Apply(Middleware, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "8.8.8.8", FromContext(r.Context()))
+	})
+	mw.ServeHTTP(rr, r)
+
+	assert.Equal(t, "", FromContext(context.Background()))
+}
@@ -107,7 +107,7 @@ func experimentParallel(c *query.Caller, hctx *query.HookContext) (*jsonrpc.RPCR
 	if !q.IsAuthenticated() && rand.Intn(100)+1 <= config.GetLbrynetXPercentage() {
 		r := hctx.Response
 		cc := c.CloneWithoutHook(config.GetLbrynetXServer(), query.MethodResolve, resolveHookName)
-		xr, err := cc.Call(q.Request)
+		xr, err := cc.CallContext(hctx.Context(), q.Request)
 
 		metrics.LbrynetXCallDurations.WithLabelValues(q.Method(), c.Endpoint(), metrics.GroupControl).Observe(c.Duration)
 		metrics.LbrynetXCallDurations.WithLabelValues(q.Method(), cc.Endpoint(), metrics.GroupExperimental).Observe(cc.Duration)
@@ -34,9 +34,26 @@ const (
 	FailureKindAuth             = "auth"
 	FailureKindInternal         = "internal"
 	FailureKindLbrynetXMismatch = "xmismatch"
+	// FailureKindCanceled is for queries abandoned because the client went away.
+	FailureKindCanceled = "canceled"
+	// FailureKindDeadline is for queries that didn't complete before their deadline.
+	FailureKindDeadline = "deadline"
+	// FailureKindTimeout is for queries the SDK didn't respond to within their method timeout.
+	FailureKindTimeout = "timeout"
 
 	GroupControl      = "control"
 	GroupExperimental = "experimental"
+
+	CacheStateFresh = "fresh"
+	CacheStateStale = "stale"
+
+	FailoverSucceeded = "success"
+	FailoverFailed    = "failure"
+
+	StreamCacheStreams   = "streams"
+	StreamCachePurchases = "purchases"
+	StreamCacheHit       = "hit"
+	StreamCacheMiss      = "miss"
 )
 
 var (
@@ -149,19 +166,110 @@ var (
 		Subsystem: "cache",
 		Name:      "hit_count",
 		Help:      "Total number of queries found in the local cache",
-	}, []string{"method"})
+	}, []string{"method", "policy", "state"})
 	ProxyQueryCacheMissCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: nsProxy,
 		Subsystem: "cache",
 		Name:      "miss_count",
 		Help:      "Total number of queries that were not in the local cache",
-	}, []string{"method"})
+	}, []string{"method", "policy"})
 	ProxyQueryCacheErrorCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: nsProxy,
 		Subsystem: "cache",
 		Name:      "error_count",
 		Help:      "Total number of errors retrieving queries from the local cache",
+	}, []string{"method", "policy"})
+	ProxyQueryCoalescedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "cache",
+		Name:      "coalesced_count",
+		Help:      "Total number of queries that were not sent to the SDK because an identical query was already in flight",
+	}, []string{"method"})
+	ProxyQueryCacheInvalidatedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "cache",
+		Name:      "invalidated_count",
+		Help:      "Total number of cached queries invalidated after a method changing claims",
+	}, []string{"method"})
+	ProxyQueryCacheFallbackCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "cache",
+		Name:      "fallback_count",
+		Help:      "Total number of cache operations served by the in-memory fallback because the shared backend was unavailable",
+	}, []string{"operation"})
+	ProxyRateLimitedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "ratelimit",
+		Name:      "rejected_count",
+		Help:      "Total number of requests rejected because a query in them was over the rate limit of its method",
+	}, []string{"method"})
+	ProxySDKQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: nsProxy,
+		Subsystem: "sdk_queue",
+		Name:      "depth",
+		Help:      "Number of queries waiting for an in-flight slot of an SDK server",
+	}, []string{"endpoint"})
+	ProxySDKQueueWaitDurations = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: nsProxy,
+		Subsystem: "sdk_queue",
+		Name:      "wait_seconds",
+		Help:      "Time queries spent waiting for an in-flight slot of an SDK server",
+		Buckets:   callsSecondsBuckets,
+	}, []string{"endpoint"})
+	ProxySDKBusyCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "sdk_queue",
+		Name:      "rejected_count",
+		Help:      "Total number of queries rejected because an SDK server was busy",
+	}, []string{"endpoint"})
+	ProxySDKCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: nsProxy,
+		Subsystem: "sdk_circuit",
+		Name:      "state",
+		Help:      "Circuit breaker state of an SDK server: 0 is closed, 1 is half-open, 2 is open",
+	}, []string{"endpoint"})
+	ProxySDKCircuitTransitionCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "sdk_circuit",
+		Name:      "transition_count",
+		Help:      "Total number of SDK server circuit breaker transitions by the state entered",
+	}, []string{"endpoint", "state"})
+	ProxyHedgeSentCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "hedge",
+		Name:      "sent_count",
+		Help:      "Total number of queries sent to a second SDK server because the first one was slow to respond",
 	}, []string{"method"})
+	ProxyHedgeWonCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "hedge",
+		Name:      "won_count",
+		Help:      "Total number of hedged queries answered by the second SDK server first",
+	}, []string{"method"})
+	ProxyFailoverCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "failover",
+		Name:      "attempt_count",
+		Help:      "Total number of attempts to resend queries to another SDK server after a network error",
+	}, []string{"method", "result"})
+	ProxyQueryCacheSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: nsProxy,
+		Subsystem: "cache",
+		Name:      "size_bytes",
+		Help:      "Total size of responses stored in the local cache",
+	}, []string{"cache"})
+	ProxyQueryCacheEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: nsProxy,
+		Subsystem: "cache",
+		Name:      "entries",
+		Help:      "Number of responses stored in the local cache",
+	}, []string{"cache"})
+	ProxyQueryCacheEvictionCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: nsProxy,
+		Subsystem: "cache",
+		Name:      "eviction_count",
+		Help:      "Total number of responses evicted from the local cache to stay within its size limit",
+	})
 
 	LbrynetWalletsLoaded = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: nsLbrynet,
@@ -170,6 +278,26 @@ var (
 		Help:      "Number of wallets currently loaded",
 	}, []string{LabelSource})
 
+	LbrynetServerHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: nsLbrynet,
+		Subsystem: "server",
+		Name:      "healthy",
+		Help:      "Whether the instance passes health probes (1) or not (0)",
+	}, []string{LabelSource})
+	LbrynetServerConsecutiveFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: nsLbrynet,
+		Subsystem: "server",
+		Name:      "consecutive_failures",
+		Help:      "Number of health probes failed in a row",
+	}, []string{LabelSource})
+	LbrynetServerProbeDurations = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: nsLbrynet,
+		Subsystem: "server",
+		Name:      "probe_seconds",
+		Help:      "Health probe latency distributions",
+		Buckets:   callsSecondsBuckets,
+	}, []string{LabelSource})
+
 	UIBufferCount = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: nsUI,
 		Subsystem: "content",
@@ -220,6 +348,18 @@ var (
 		Name:      "count",
 		Help:      "Total number of stream requests received",
 	}, []string{LabelNameType})
+	LbrytvSpendingLimitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsLbrytv,
+		Subsystem: "spending",
+		Name:      "limit_count",
+		Help:      "Total number of purchases and sends refused for going over user spending limits",
+	}, []string{"method"})
+	LbrytvStreamCacheCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: nsLbrytv,
+		Subsystem: "stream_cache",
+		Name:      "count",
+		Help:      "Total number of lookups of stream metadata and purchases cached for get requests",
+	}, []string{"cache", "result"})
 
 	LbrytvDBOpenConnections = promauto.NewGauge(prometheus.GaugeOpts{
 		Namespace: nsLbrytv,
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/lbryio/lbrytv/api"
+	"github.com/lbryio/lbrytv/app/query"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -50,7 +51,7 @@ func testMetricUIEvent(t *testing.T, method, name, value string) *httptest.Respo
 	req.URL.RawQuery = q.Encode()
 
 	r := mux.NewRouter()
-	api.InstallRoutes(r, nil)
+	api.InstallRoutes(r, nil, query.CallerOptions{})
 	rr := httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
 	return rr
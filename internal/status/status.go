@@ -44,9 +44,11 @@ const (
 )
 
 type serverItem struct {
-	Name   string `json:"name"`
-	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
+	Name     string                  `json:"name"`
+	Status   string                  `json:"status"`
+	Error    string                  `json:"error,omitempty"`
+	Draining bool                    `json:"draining,omitempty"`
+	Health   *sdkrouter.ServerHealth `json:"health,omitempty"`
 }
 type serverList []*serverItem
 type userData struct {
@@ -79,9 +81,16 @@ func GetStatus(w http.ResponseWriter, req *http.Request) {
 		}
 		failureDetected := false
 
-		sdks := sdkrouter.FromRequest(req).GetAll()
-		for _, s := range sdks {
-			services["lbrynet"] = append(services["lbrynet"], &serverItem{Name: s.Name, Status: statusOK})
+		rt := sdkrouter.FromRequest(req)
+		for _, s := range rt.GetAll() {
+			health := rt.Health(s)
+			srv := &serverItem{Name: s.Name, Status: statusOK, Draining: s.Draining, Health: &health}
+			if !health.Healthy {
+				srv.Status = statusOffline
+				srv.Error = fmt.Sprintf("%d consecutive failed probes: %v", health.ConsecutiveFailures, health.LastError)
+				failureDetected = true
+			}
+			services["lbrynet"] = append(services["lbrynet"], srv)
 		}
 
 		for _, ps := range PlayerServers {
@@ -139,7 +148,7 @@ func GetStatusV2(w http.ResponseWriter, r *http.Request) {
 		userID = user.ID
 	}
 
-	srv := serverItem{Name: lbrynetServer.Name, Status: statusOK}
+	srv := serverItem{Name: lbrynetServer.Name, Status: statusOK, Draining: lbrynetServer.Draining}
 
 	if cache.IsOnRequest(r) {
 		qCache = cache.FromRequest(r)
@@ -147,7 +156,7 @@ func GetStatusV2(w http.ResponseWriter, r *http.Request) {
 
 	c := query.NewCaller(lbrynetServer.Address, userID)
 	c.Cache = qCache
-	rpcRes, err := c.Call(jsonrpc.NewRequest("resolve", map[string]interface{}{"urls": resolveURL}))
+	rpcRes, err := c.CallContext(r.Context(), jsonrpc.NewRequest("resolve", map[string]interface{}{"urls": resolveURL}))
 
 	if err != nil {
 		srv.Error = err.Error()
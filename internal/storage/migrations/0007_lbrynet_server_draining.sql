@@ -0,0 +1,7 @@
+-- +migrate Up
+
+ALTER TABLE lbrynet_servers ADD COLUMN draining BOOLEAN NOT NULL DEFAULT false;
+
+-- +migrate Down
+
+ALTER TABLE lbrynet_servers DROP COLUMN draining;
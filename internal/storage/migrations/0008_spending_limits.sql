@@ -0,0 +1,30 @@
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE "spending_limits" (
+    "user_id" uinteger NOT NULL PRIMARY KEY REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    "created_at" timestamp NOT NULL DEFAULT now(),
+    "updated_at" timestamp NOT NULL DEFAULT now(),
+
+    "max_purchase" bigint CHECK ("max_purchase" >= 0),
+    "daily_spend" bigint CHECK ("daily_spend" >= 0),
+    "daily_sends" integer CHECK ("daily_sends" >= 0)
+);
+
+CREATE TABLE "spending_log" (
+    "id" SERIAL PRIMARY KEY,
+    "user_id" uinteger NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "method" varchar NOT NULL,
+    "amount" bigint NOT NULL,
+    "timestamp" timestamp NOT NULL DEFAULT now()
+);
+CREATE INDEX spending_log_user_id_timestamp_idx ON spending_log(user_id, timestamp);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE "spending_log";
+DROP TABLE "spending_limits";
+-- +migrate StatementEnd
@@ -0,0 +1,22 @@
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE "user_migrations" (
+    "user_id" uinteger NOT NULL PRIMARY KEY REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    "created_at" timestamp NOT NULL DEFAULT now(),
+    "updated_at" timestamp NOT NULL DEFAULT now(),
+
+    "from_server_id" integer NOT NULL REFERENCES "lbrynet_servers" ("id") ON DELETE CASCADE,
+    "to_server_id" integer NOT NULL REFERENCES "lbrynet_servers" ("id") ON DELETE CASCADE,
+    "state" varchar NOT NULL,
+    "error" varchar
+);
+CREATE INDEX user_migrations_from_server_id_state_idx ON user_migrations(from_server_id, state);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE "user_migrations";
+-- +migrate StatementEnd
@@ -0,0 +1,11 @@
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE INDEX spending_log_timestamp_idx ON spending_log(timestamp);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP INDEX spending_log_timestamp_idx;
+-- +migrate StatementEnd
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/lbryio/lbrytv/api"
+	"github.com/lbryio/lbrytv/app/query"
 	"github.com/lbryio/lbrytv/app/sdkrouter"
 	"github.com/lbryio/lbrytv/internal/monitor"
 
@@ -26,9 +27,9 @@ type Server struct {
 }
 
 // NewServer returns a server initialized with settings from supplied options.
-func NewServer(address string, sdkRouter *sdkrouter.Router) *Server {
+func NewServer(address string, sdkRouter *sdkrouter.Router, callerOpts query.CallerOptions) *Server {
 	r := mux.NewRouter()
-	api.InstallRoutes(r, sdkRouter)
+	api.InstallRoutes(r, sdkRouter, callerOpts)
 	r.Use(monitor.ErrorLoggingMiddleware)
 	r.Use(defaultHeadersMiddleware(map[string]string{
 		"Server":                      "api.lbry.tv",
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lbryio/lbrytv/app/query"
 	"github.com/lbryio/lbrytv/app/sdkrouter"
 	"github.com/lbryio/lbrytv/apps/lbrytv/config"
 	"github.com/lbryio/lbrytv/internal/storage"
@@ -31,7 +32,7 @@ func TestMain(m *testing.M) {
 }
 
 func TestStartAndServeUntilShutdown(t *testing.T) {
-	server := NewServer("localhost:40080", sdkrouter.New(config.GetLbrynetServers()))
+	server := NewServer("localhost:40080", sdkrouter.New(config.GetLbrynetServers()), query.CallerOptions{})
 	server.Start()
 	go server.ServeUntilShutdown()
 
@@ -60,7 +61,7 @@ func TestHeaders(t *testing.T) {
 		response *http.Response
 	)
 
-	server := NewServer("localhost:40080", sdkrouter.New(config.GetLbrynetServers()))
+	server := NewServer("localhost:40080", sdkrouter.New(config.GetLbrynetServers()), query.CallerOptions{})
 	server.Start()
 	go server.ServeUntilShutdown()
 